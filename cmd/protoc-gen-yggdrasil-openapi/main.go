@@ -0,0 +1,38 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+
+	"github.com/imkuqin-zw/yggdrasil/internal/protogen/genopenapi"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	var opts genopenapi.Options
+	flag.StringVar(&opts.Naming, "naming", "json", "field naming of the documents, json or proto")
+	flag.StringVar(&opts.Format, "format", "yaml", "format of the documents, yaml or json")
+	flag.StringVar(&opts.Title, "title", "", "title of the documents")
+	flag.StringVar(&opts.Version, "version", "", "version of the apis")
+	flag.StringVar(&opts.Merge, "merge", "", "merge the documents into one document with the name")
+	protogen.Options{
+		ParamFunc: flag.CommandLine.Set,
+	}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		return genopenapi.GenerateFiles(gen, opts)
+	})
+}
@@ -3,5 +3,6 @@ protoc \
   --yggdrasil-rpc_out=../protogen --yggdrasil-rpc_opt=paths=source_relative \
   --yggdrasil-rest_out=../protogen --yggdrasil-rest_opt=paths=source_relative \
   --yggdrasil-reason_out=../protogen --yggdrasil-reason_opt=paths=source_relative \
+  --yggdrasil-openapi_out=../protogen --yggdrasil-openapi_opt=paths=source_relative \
   -I .  -I ../../proto \
   ./*/*/*.proto ./*/*.proto
@@ -11,6 +11,7 @@ import (
 	server "github.com/imkuqin-zw/yggdrasil/pkg/server"
 	status "github.com/imkuqin-zw/yggdrasil/pkg/status"
 	code "google.golang.org/genproto/googleapis/rpc/code"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
 	io "io"
 	http "net/http"
 )
@@ -20,27 +21,62 @@ import (
 var _ = new(marshaler.ProtoMarshaller)
 var _ = io.EOF
 
-func local_handler_LibraryService_CreateShelf_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_CreateShelf_0(r *http.Request) (*CreateShelfRequest, error) {
 	protoReq := &CreateShelfRequest{}
+	protoReq.Shelf = &Shelf{}
 
-	inbound := marshaler.InboundFromContext(r.Context())
-	if err := inbound.NewDecoder(r.Body).Decode(protoReq.Shelf); err != nil && err != io.EOF {
-		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
+	if err := rest.DecodeBody(r, protoReq.Shelf); err != nil {
+		return nil, err
+	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_CreateShelf_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_CreateShelf_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).CreateShelf(ctx, req.(*CreateShelfRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
 	}
 
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/CreateShelf",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_CreateShelf_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_CreateShelf_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).CreateShelf(ctx, req.(*CreateShelfRequest))
+		out := &Shelf{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/CreateShelf", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/CreateShelf",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_GetShelf_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_GetShelf_0(r *http.Request) (*GetShelfRequest, error) {
 	protoReq := &GetShelfRequest{}
-	if err := rest.PopulateQueryParameters(protoReq, r.Form); err != nil {
+	if err := rest.PopulateQueryParameters(protoReq, r.URL.Query()); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
 
@@ -49,35 +85,106 @@ func local_handler_LibraryService_GetShelf_0(w http.ResponseWriter, r *http.Requ
 	} else if err := rest.PopulateFieldFromPath(protoReq, "name", val); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_GetShelf_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_GetShelf_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).GetShelf(ctx, req.(*GetShelfRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/GetShelf",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_GetShelf_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_GetShelf_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).GetShelf(ctx, req.(*GetShelfRequest))
+		out := &Shelf{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/GetShelf", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/GetShelf",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_ListShelves_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_ListShelves_0(r *http.Request) (*ListShelvesRequest, error) {
 	protoReq := &ListShelvesRequest{}
-	if err := rest.PopulateQueryParameters(protoReq, r.Form); err != nil {
+	if err := rest.PopulateQueryParameters(protoReq, r.URL.Query()); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
 
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_ListShelves_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_ListShelves_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).ListShelves(ctx, req.(*ListShelvesRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/ListShelves",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_ListShelves_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_ListShelves_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).ListShelves(ctx, req.(*ListShelvesRequest))
+		out := &ListShelvesResponse{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/ListShelves", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/ListShelves",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_DeleteShelf_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_DeleteShelf_0(r *http.Request) (*DeleteShelfRequest, error) {
 	protoReq := &DeleteShelfRequest{}
-	if err := rest.PopulateQueryParameters(protoReq, r.Form); err != nil {
+	if err := rest.PopulateQueryParameters(protoReq, r.URL.Query()); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
 
@@ -86,22 +193,57 @@ func local_handler_LibraryService_DeleteShelf_0(w http.ResponseWriter, r *http.R
 	} else if err := rest.PopulateFieldFromPath(protoReq, "name", val); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_DeleteShelf_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_DeleteShelf_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).DeleteShelf(ctx, req.(*DeleteShelfRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/DeleteShelf",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_DeleteShelf_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_DeleteShelf_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).DeleteShelf(ctx, req.(*DeleteShelfRequest))
+		out := &emptypb.Empty{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/DeleteShelf", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/DeleteShelf",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_MergeShelves_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_MergeShelves_0(r *http.Request) (*MergeShelvesRequest, error) {
 	protoReq := &MergeShelvesRequest{}
 
-	inbound := marshaler.InboundFromContext(r.Context())
-	if err := inbound.NewDecoder(r.Body).Decode(protoReq); err != nil && err != io.EOF {
-		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
+	if err := rest.DecodeBody(r, protoReq); err != nil {
+		return nil, err
 	}
 
 	if val := "shelves/" + v5.URLParam(r, "params0"); len(val) == 0 {
@@ -109,22 +251,58 @@ func local_handler_LibraryService_MergeShelves_0(w http.ResponseWriter, r *http.
 	} else if err := rest.PopulateFieldFromPath(protoReq, "name", val); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_MergeShelves_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_MergeShelves_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).MergeShelves(ctx, req.(*MergeShelvesRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/MergeShelves",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_MergeShelves_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_MergeShelves_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).MergeShelves(ctx, req.(*MergeShelvesRequest))
+		out := &Shelf{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/MergeShelves", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/MergeShelves",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_CreateBook_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_CreateBook_0(r *http.Request) (*CreateBookRequest, error) {
 	protoReq := &CreateBookRequest{}
+	protoReq.Book = &Book{}
 
-	inbound := marshaler.InboundFromContext(r.Context())
-	if err := inbound.NewDecoder(r.Body).Decode(protoReq.Book); err != nil && err != io.EOF {
-		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
+	if err := rest.DecodeBody(r, protoReq.Book); err != nil {
+		return nil, err
 	}
 
 	if val := "shelves/" + v5.URLParam(r, "params0"); len(val) == 0 {
@@ -132,19 +310,55 @@ func local_handler_LibraryService_CreateBook_0(w http.ResponseWriter, r *http.Re
 	} else if err := rest.PopulateFieldFromPath(protoReq, "parent", val); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_CreateBook_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_CreateBook_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).CreateBook(ctx, req.(*CreateBookRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/CreateBook",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_CreateBook_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_CreateBook_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).CreateBook(ctx, req.(*CreateBookRequest))
+		out := &Book{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/CreateBook", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/CreateBook",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_GetBook_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_GetBook_0(r *http.Request) (*GetBookRequest, error) {
 	protoReq := &GetBookRequest{}
-	if err := rest.PopulateQueryParameters(protoReq, r.Form); err != nil {
+	if err := rest.PopulateQueryParameters(protoReq, r.URL.Query()); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
 
@@ -153,19 +367,55 @@ func local_handler_LibraryService_GetBook_0(w http.ResponseWriter, r *http.Reque
 	} else if err := rest.PopulateFieldFromPath(protoReq, "name", val); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_GetBook_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_GetBook_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).GetBook(ctx, req.(*GetBookRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/GetBook",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_GetBook_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_GetBook_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).GetBook(ctx, req.(*GetBookRequest))
+		out := &Book{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/GetBook", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/GetBook",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_ListBooks_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_ListBooks_0(r *http.Request) (*ListBooksRequest, error) {
 	protoReq := &ListBooksRequest{}
-	if err := rest.PopulateQueryParameters(protoReq, r.Form); err != nil {
+	if err := rest.PopulateQueryParameters(protoReq, r.URL.Query()); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
 
@@ -174,19 +424,55 @@ func local_handler_LibraryService_ListBooks_0(w http.ResponseWriter, r *http.Req
 	} else if err := rest.PopulateFieldFromPath(protoReq, "parent", val); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_ListBooks_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_ListBooks_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).ListBooks(ctx, req.(*ListBooksRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/ListBooks",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_ListBooks_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_ListBooks_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).ListBooks(ctx, req.(*ListBooksRequest))
+		out := &ListBooksResponse{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/ListBooks", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/ListBooks",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_DeleteBook_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_DeleteBook_0(r *http.Request) (*DeleteBookRequest, error) {
 	protoReq := &DeleteBookRequest{}
-	if err := rest.PopulateQueryParameters(protoReq, r.Form); err != nil {
+	if err := rest.PopulateQueryParameters(protoReq, r.URL.Query()); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
 
@@ -195,45 +481,124 @@ func local_handler_LibraryService_DeleteBook_0(w http.ResponseWriter, r *http.Re
 	} else if err := rest.PopulateFieldFromPath(protoReq, "name", val); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_DeleteBook_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_DeleteBook_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).DeleteBook(ctx, req.(*DeleteBookRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/DeleteBook",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_DeleteBook_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_DeleteBook_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).DeleteBook(ctx, req.(*DeleteBookRequest))
+		out := &emptypb.Empty{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/DeleteBook", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/DeleteBook",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_UpdateBook_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_UpdateBook_0(r *http.Request) (*UpdateBookRequest, error) {
 	protoReq := &UpdateBookRequest{}
+	protoReq.Book = &Book{}
 
-	inbound := marshaler.InboundFromContext(r.Context())
-	if err := inbound.NewDecoder(r.Body).Decode(protoReq.Book); err != nil && err != io.EOF {
+	if err := rest.PopulateQueryParameters(protoReq, r.URL.Query()); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+	if fm, err := rest.DecodeBodyFieldMask(r, protoReq.Book); err != nil {
+		return nil, err
+	} else if len(protoReq.UpdateMask.GetPaths()) == 0 {
+		protoReq.UpdateMask = fm
+	}
+	if err := rest.ValidateFieldMask(protoReq.UpdateMask, protoReq.Book); err != nil {
+		return nil, err
+	}
 
 	if val := "shelves/" + v5.URLParam(r, "params0") + "/books/" + v5.URLParam(r, "params1"); len(val) == 0 {
 		return nil, status.Errorf(code.Code_INVALID_ARGUMENT, "not found book.name")
 	} else if err := rest.PopulateFieldFromPath(protoReq, "book.name", val); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_UpdateBook_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_UpdateBook_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).UpdateBook(ctx, req.(*UpdateBookRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/UpdateBook",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_UpdateBook_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_UpdateBook_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).UpdateBook(ctx, req.(*UpdateBookRequest))
+		out := &Book{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/UpdateBook", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/UpdateBook",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
-func local_handler_LibraryService_MoveBook_0(w http.ResponseWriter, r *http.Request, server interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+func local_request_LibraryService_MoveBook_0(r *http.Request) (*MoveBookRequest, error) {
 	protoReq := &MoveBookRequest{}
 
-	inbound := marshaler.InboundFromContext(r.Context())
-	if err := inbound.NewDecoder(r.Body).Decode(protoReq); err != nil && err != io.EOF {
-		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
+	if err := rest.DecodeBody(r, protoReq); err != nil {
+		return nil, err
 	}
 
 	if val := "shelves/" + v5.URLParam(r, "params0") + "/books/" + v5.URLParam(r, "params1"); len(val) == 0 {
@@ -241,73 +606,132 @@ func local_handler_LibraryService_MoveBook_0(w http.ResponseWriter, r *http.Requ
 	} else if err := rest.PopulateFieldFromPath(protoReq, "name", val); err != nil {
 		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
 	}
+
+	return protoReq, nil
+}
+
+func local_handler_LibraryService_MoveBook_0(w http.ResponseWriter, r *http.Request, srv interface{}, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_MoveBook_0(r)
+	if err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).MoveBook(ctx, req.(*MoveBookRequest))
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
 	info := &interceptor.UnaryServerInfo{
-		Server:     server,
+		Server:     srv,
 		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/MoveBook",
 	}
+	return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_LibraryService_MoveBook_0(w http.ResponseWriter, r *http.Request, gw server.RestGateway, unaryInt interceptor.UnaryServerInterceptor) (interface{}, error) {
+	protoReq, err := local_request_LibraryService_MoveBook_0(r)
+	if err != nil {
+		return nil, err
+	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return server.(LibraryServiceServer).MoveBook(ctx, req.(*MoveBookRequest))
+		out := &Book{}
+		if err := gw.Invoke(ctx, "/yggdrasil.example.proto.library.v1.LibraryService/MoveBook", req, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	if unaryInt == nil {
+		return handler(r.Context(), protoReq)
+	}
+
+	info := &interceptor.UnaryServerInfo{
+		Server:     gw,
+		FullMethod: "yggdrasil.example.proto.library.v1.LibraryService/MoveBook",
 	}
 	return unaryInt(r.Context(), protoReq, info, handler)
 }
 
 var LibraryServiceRestServiceDesc = server.RestServiceDesc{
+	ServiceName: "yggdrasil.example.proto.library.v1.LibraryService",
 	HandlerType: (*LibraryServiceServer)(nil),
 	Methods: []server.RestMethodDesc{
 		{
-			Method:  "POST",
-			Path:    "/v1/shelves",
-			Handler: local_handler_LibraryService_CreateShelf_0,
+			MethodName:     "CreateShelf",
+			Method:         "POST",
+			Path:           "/v1/shelves",
+			Handler:        local_handler_LibraryService_CreateShelf_0,
+			GatewayHandler: local_gateway_LibraryService_CreateShelf_0,
 		},
 		{
-			Method:  "GET",
-			Path:    "/v1/shelves/{params0}",
-			Handler: local_handler_LibraryService_GetShelf_0,
+			MethodName:     "GetShelf",
+			Method:         "GET",
+			Path:           "/v1/shelves/{params0}",
+			Handler:        local_handler_LibraryService_GetShelf_0,
+			GatewayHandler: local_gateway_LibraryService_GetShelf_0,
 		},
 		{
-			Method:  "GET",
-			Path:    "/v1/shelves",
-			Handler: local_handler_LibraryService_ListShelves_0,
+			MethodName:     "ListShelves",
+			Method:         "GET",
+			Path:           "/v1/shelves",
+			Handler:        local_handler_LibraryService_ListShelves_0,
+			GatewayHandler: local_gateway_LibraryService_ListShelves_0,
 		},
 		{
-			Method:  "DELETE",
-			Path:    "/v1/shelves/{params0}",
-			Handler: local_handler_LibraryService_DeleteShelf_0,
+			MethodName:     "DeleteShelf",
+			Method:         "DELETE",
+			Path:           "/v1/shelves/{params0}",
+			Handler:        local_handler_LibraryService_DeleteShelf_0,
+			GatewayHandler: local_gateway_LibraryService_DeleteShelf_0,
 		},
 		{
-			Method:  "POST",
-			Path:    "/v1/shelves/{params0}:merge",
-			Handler: local_handler_LibraryService_MergeShelves_0,
+			MethodName:     "MergeShelves",
+			Method:         "POST",
+			Path:           "/v1/shelves/{params0}:merge",
+			Handler:        local_handler_LibraryService_MergeShelves_0,
+			GatewayHandler: local_gateway_LibraryService_MergeShelves_0,
 		},
 		{
-			Method:  "POST",
-			Path:    "/v1/shelves/{params0}/books",
-			Handler: local_handler_LibraryService_CreateBook_0,
+			MethodName:     "CreateBook",
+			Method:         "POST",
+			Path:           "/v1/shelves/{params0}/books",
+			Handler:        local_handler_LibraryService_CreateBook_0,
+			GatewayHandler: local_gateway_LibraryService_CreateBook_0,
 		},
 		{
-			Method:  "GET",
-			Path:    "/v1/shelves/{params0}/books/{params1}",
-			Handler: local_handler_LibraryService_GetBook_0,
+			MethodName:     "GetBook",
+			Method:         "GET",
+			Path:           "/v1/shelves/{params0}/books/{params1}",
+			Handler:        local_handler_LibraryService_GetBook_0,
+			GatewayHandler: local_gateway_LibraryService_GetBook_0,
 		},
 		{
-			Method:  "GET",
-			Path:    "/v1/shelves/{params0}/books",
-			Handler: local_handler_LibraryService_ListBooks_0,
+			MethodName:     "ListBooks",
+			Method:         "GET",
+			Path:           "/v1/shelves/{params0}/books",
+			Handler:        local_handler_LibraryService_ListBooks_0,
+			GatewayHandler: local_gateway_LibraryService_ListBooks_0,
 		},
 		{
-			Method:  "DELETE",
-			Path:    "/v1/shelves/{params0}/books/{params1}",
-			Handler: local_handler_LibraryService_DeleteBook_0,
+			MethodName:     "DeleteBook",
+			Method:         "DELETE",
+			Path:           "/v1/shelves/{params0}/books/{params1}",
+			Handler:        local_handler_LibraryService_DeleteBook_0,
+			GatewayHandler: local_gateway_LibraryService_DeleteBook_0,
 		},
 		{
-			Method:  "PATCH",
-			Path:    "/v1/shelves/{params0}/books/{params1}",
-			Handler: local_handler_LibraryService_UpdateBook_0,
+			MethodName:     "UpdateBook",
+			Method:         "PATCH",
+			Path:           "/v1/shelves/{params0}/books/{params1}",
+			Handler:        local_handler_LibraryService_UpdateBook_0,
+			GatewayHandler: local_gateway_LibraryService_UpdateBook_0,
 		},
 		{
-			Method:  "POST",
-			Path:    "/v1/shelves/{params0}/books/{params1}:move",
-			Handler: local_handler_LibraryService_MoveBook_0,
+			MethodName:     "MoveBook",
+			Method:         "POST",
+			Path:           "/v1/shelves/{params0}/books/{params1}:move",
+			Handler:        local_handler_LibraryService_MoveBook_0,
+			GatewayHandler: local_gateway_LibraryService_MoveBook_0,
 		},
 	},
 }
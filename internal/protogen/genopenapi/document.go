@@ -0,0 +1,177 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genopenapi
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+const openapiVersion = "3.0.3"
+
+type document struct {
+	OpenAPI    string                               `json:"openapi" yaml:"openapi"`
+	Info       info                                 `json:"info" yaml:"info"`
+	Tags       []tag                                `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Paths      *orderedMap[*orderedMap[*operation]] `json:"paths" yaml:"paths"`
+	Components components                           `json:"components" yaml:"components"`
+}
+
+type info struct {
+	Title       string `json:"title" yaml:"title"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Version     string `json:"version" yaml:"version"`
+}
+
+type tag struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+type components struct {
+	Schemas *orderedMap[*schema] `json:"schemas" yaml:"schemas"`
+}
+
+type operation struct {
+	Tags        []string               `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Description string                 `json:"description,omitempty" yaml:"description,omitempty"`
+	OperationID string                 `json:"operationId" yaml:"operationId"`
+	Parameters  []*parameter           `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	RequestBody *requestBody           `json:"requestBody,omitempty" yaml:"requestBody,omitempty"`
+	Responses   *orderedMap[*response] `json:"responses" yaml:"responses"`
+	Deprecated  bool                   `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+}
+
+type parameter struct {
+	Name        string  `json:"name" yaml:"name"`
+	In          string  `json:"in" yaml:"in"`
+	Description string  `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool    `json:"required,omitempty" yaml:"required,omitempty"`
+	Schema      *schema `json:"schema" yaml:"schema"`
+}
+
+type requestBody struct {
+	Required bool                  `json:"required,omitempty" yaml:"required,omitempty"`
+	Content  map[string]*mediaType `json:"content" yaml:"content"`
+}
+
+type response struct {
+	Description string                `json:"description" yaml:"description"`
+	Content     map[string]*mediaType `json:"content,omitempty" yaml:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema" yaml:"schema"`
+}
+
+type schema struct {
+	Ref                  string               `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Type                 string               `json:"type,omitempty" yaml:"type,omitempty"`
+	Format               string               `json:"format,omitempty" yaml:"format,omitempty"`
+	Description          string               `json:"description,omitempty" yaml:"description,omitempty"`
+	Enum                 []string             `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Items                *schema              `json:"items,omitempty" yaml:"items,omitempty"`
+	Properties           *orderedMap[*schema] `json:"properties,omitempty" yaml:"properties,omitempty"`
+	AdditionalProperties any                  `json:"additionalProperties,omitempty" yaml:"additionalProperties,omitempty"`
+	ReadOnly             bool                 `json:"readOnly,omitempty" yaml:"readOnly,omitempty"`
+	Deprecated           bool                 `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+}
+
+// orderedMap keeps the insertion order of the keys, so the document follows
+// the order of the proto declarations.
+type orderedMap[V any] struct {
+	keys   []string
+	values map[string]V
+}
+
+func newOrderedMap[V any]() *orderedMap[V] {
+	return &orderedMap[V]{values: map[string]V{}}
+}
+
+func (m *orderedMap[V]) Get(key string) (V, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+func (m *orderedMap[V]) Set(key string, value V) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap[V]) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.keys)
+}
+
+func (m *orderedMap[V]) IsZero() bool {
+	return m.Len() == 0
+}
+
+func (m *orderedMap[V]) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBufferString("{")
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (m *orderedMap[V]) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range m.keys {
+		value := &yaml.Node{}
+		if err := value.Encode(m.values[key]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	}
+	return node, nil
+}
+
+func (d *document) marshal(format string) ([]byte, error) {
+	if format == "json" {
+		data, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	buf := bytes.NewBufferString("# Code generated by protoc-gen-yggdrasil-openapi. DO NOT EDIT.\n\n")
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(d); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
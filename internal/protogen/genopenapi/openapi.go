@@ -0,0 +1,436 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genopenapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/imkuqin-zw/yggdrasil/internal/protogen/genrest"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/imkuqin-zw/yggdrasil/proto/yggdrasil/reason"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	jsonContentType   = "application/json"
+	sseContentType    = "text/event-stream"
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// Options are the parameters of protoc-gen-yggdrasil-openapi.
+type Options struct {
+	// Naming is the naming of the fields, json for the lowerCamelCase json names
+	// and proto for the proto names. It should match the UseProtoNames option of
+	// the rest marshaler.
+	Naming string
+	// Format is the format of the documents, yaml or json.
+	Format string
+	// Title is the title of the documents, it defaults to the proto package.
+	Title string
+	// Version is the version of the apis.
+	Version string
+	// Merge merges the documents of all the files into one document with the
+	// given name if it is not empty.
+	Merge string
+}
+
+var paramsPattern = regexp.MustCompile(`{(params[0-9]+)}`)
+
+// queryWellKnown are the well known types bound to a single query parameter.
+var queryWellKnown = map[protoreflect.FullName]bool{
+	"google.protobuf.Timestamp":   true,
+	"google.protobuf.Duration":    true,
+	"google.protobuf.FieldMask":   true,
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.UInt64Value": true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.BytesValue":  true,
+}
+
+// errorResponse is the error response of the reasons mapped to a http status.
+type errorResponse struct {
+	httpCode int32
+	reasons  []string
+}
+
+type generator struct {
+	opts   Options
+	doc    *document
+	errors []*errorResponse
+}
+
+// GenerateFiles generates the OpenAPI v3 documents of the rest apis declared by
+// the google.api.http options. The routes are resolved by genrest, the same way
+// as the rest handlers.
+func GenerateFiles(gen *protogen.Plugin, opts Options) error {
+	if opts.Format != "json" {
+		opts.Format = "yaml"
+	}
+	if opts.Version == "" {
+		opts.Version = "version not set"
+	}
+	errs := reasonErrors(gen.Files)
+	var merged *generator
+	if opts.Merge != "" {
+		merged = newGenerator(opts, opts.Merge, errs)
+	}
+	for _, file := range gen.Files {
+		if !file.Generate {
+			continue
+		}
+		g := merged
+		if g == nil {
+			g = newGenerator(opts, string(file.Desc.Package()), errs)
+		}
+		for _, service := range file.Services {
+			if err := g.genService(service); err != nil {
+				return err
+			}
+		}
+		if merged == nil {
+			if err := g.write(gen, file.GeneratedFilenamePrefix); err != nil {
+				return err
+			}
+		}
+	}
+	if merged != nil {
+		return merged.write(gen, opts.Merge)
+	}
+	return nil
+}
+
+func newGenerator(opts Options, title string, errs []*errorResponse) *generator {
+	if opts.Title != "" {
+		title = opts.Title
+	}
+	return &generator{
+		opts: opts,
+		doc: &document{
+			OpenAPI:    openapiVersion,
+			Info:       info{Title: title, Version: opts.Version},
+			Paths:      newOrderedMap[*orderedMap[*operation]](),
+			Components: components{Schemas: newOrderedMap[*schema]()},
+		},
+		errors: errs,
+	}
+}
+
+func (g *generator) write(gen *protogen.Plugin, prefix string) error {
+	if g.doc.Paths.Len() == 0 {
+		return nil
+	}
+	data, err := g.doc.marshal(g.opts.Format)
+	if err != nil {
+		return err
+	}
+	_, err = gen.NewGeneratedFile(prefix+".openapi."+g.opts.Format, "").Write(data)
+	return err
+}
+
+func (g *generator) genService(service *protogen.Service) error {
+	tagged := false
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingClient() {
+			// The websocket is not described by the openapi.
+			continue
+		}
+		bindings, err := genrest.Bindings(method)
+		if err != nil {
+			return err
+		}
+		for i, bind := range bindings {
+			// The additional bindings come first, the main one keeps the plain id.
+			operationID := service.GoName + "_" + method.GoName
+			if i < len(bindings)-1 {
+				operationID = fmt.Sprintf("%s_%d", operationID, i+1)
+			}
+			if err = g.genOperation(service, method, bind, operationID); err != nil {
+				return err
+			}
+			tagged = true
+		}
+	}
+	if tagged {
+		g.doc.Tags = append(g.doc.Tags, tag{Name: string(service.Desc.Name()), Description: comment(service.Comments)})
+	}
+	return nil
+}
+
+func (g *generator) genOperation(service *protogen.Service, method *protogen.Method, bind *genrest.Binding, operationID string) error {
+	meth := strings.ToLower(bind.Method)
+	switch bind.Method {
+	case http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete,
+		http.MethodPatch, http.MethodHead, http.MethodOptions, http.MethodTrace:
+	default:
+		return fmt.Errorf("%s: http method %s can not be documented", method.Desc.FullName(), bind.Method)
+	}
+	op := &operation{
+		Tags:        []string{string(service.Desc.Name())},
+		Description: comment(method.Comments),
+		OperationID: operationID,
+		Responses:   newOrderedMap[*response](),
+		Deprecated:  method.Desc.Options().(*descriptorpb.MethodOptions).GetDeprecated(),
+	}
+
+	path, params, err := g.pathParameters(method.Input, bind)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method.Desc.FullName(), err)
+	}
+	op.Parameters = params
+	switch bind.Body {
+	case "":
+		op.Parameters = append(op.Parameters, g.queryParameters(method.Input, "", "", bind.PathVars, map[protoreflect.FullName]bool{})...)
+	case "*":
+		op.RequestBody = &requestBody{
+			Required: true,
+			Content:  map[string]*mediaType{jsonContentType: {Schema: g.messageSchema(method.Input)}},
+		}
+	default:
+		fields, err := findFields(method.Input, bind.Body)
+		if err != nil {
+			return fmt.Errorf("%s: body %w", method.Desc.FullName(), err)
+		}
+		op.RequestBody = &requestBody{
+			Required: true,
+			Content:  map[string]*mediaType{jsonContentType: {Schema: g.fieldSchema(fields[len(fields)-1])}},
+		}
+	}
+
+	if method.Desc.IsStreamingServer() {
+		op.Responses.Set("200", g.streamResponse(method.Output))
+	} else {
+		op.Responses.Set("200", &response{
+			Description: "A successful response.",
+			Content:     map[string]*mediaType{jsonContentType: {Schema: g.messageSchema(method.Output)}},
+		})
+	}
+	for _, item := range g.errors {
+		op.Responses.Set(fmt.Sprint(item.httpCode), &response{
+			Description: strings.Join(item.reasons, "\n"),
+			Content:     map[string]*mediaType{jsonContentType: {Schema: g.statusSchemaRef()}},
+		})
+	}
+	op.Responses.Set("default", &response{
+		Description: "An unexpected error response.",
+		Content:     map[string]*mediaType{jsonContentType: {Schema: g.statusSchemaRef()}},
+	})
+
+	item, ok := g.doc.Paths.Get(path)
+	if !ok {
+		item = newOrderedMap[*operation]()
+		g.doc.Paths.Set(path, item)
+	}
+	if _, ok = item.Get(meth); ok {
+		return fmt.Errorf("%s: route %s %s is declared more than once", method.Desc.FullName(), bind.Method, path)
+	}
+	item.Set(meth, op)
+	return nil
+}
+
+// streamResponse returns the response of the server streaming method, the
+// messages are streamed as server-sent events or newline delimited json, and
+// the stream ends with the status of the rpc.
+func (g *generator) streamResponse(output *protogen.Message) *response {
+	line := newOrderedMap[*schema]()
+	line.Set("result", g.messageSchema(output))
+	line.Set("status", g.statusSchemaRef())
+	return &response{
+		Description: "A stream of responses.",
+		Content: map[string]*mediaType{
+			ndjsonContentType: {Schema: &schema{
+				Type:        "object",
+				Description: "Each line is a result, the last line is the status of the stream.",
+				Properties:  line,
+			}},
+			sseContentType: {Schema: &schema{
+				Type:        "string",
+				Description: "Each result is a message event, the last event is a status event.",
+			}},
+		},
+	}
+}
+
+// pathParameters renames the {paramsN} of the route with the names of the
+// fields, and returns the parameters of them. The parameters of the variables
+// with a multi segments pattern are suffixed with their index.
+func (g *generator) pathParameters(input *protogen.Message, bind *genrest.Binding) (string, []*parameter, error) {
+	names := make([]string, 0, len(bind.PathVars))
+	for name := range bind.PathVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	renames := map[string]*parameter{}
+	for _, name := range names {
+		fields, err := findFields(input, name)
+		if err != nil {
+			return "", nil, fmt.Errorf("path variable %w", err)
+		}
+		field := fields[len(fields)-1]
+		display := g.displayPath(fields)
+		value := bind.PathVars[name]
+		matches := paramsPattern.FindAllStringSubmatch(value, -1)
+		for i, item := range matches {
+			param := &parameter{
+				Name:        display,
+				In:          "path",
+				Required:    true,
+				Description: comment(field.Comments),
+				Schema:      &schema{Type: "string"},
+			}
+			if len(matches) > 1 {
+				param.Name = fmt.Sprintf("%s_%d", display, i)
+			}
+			// The variable without a pattern is the whole field value.
+			if value == item[0] && field.Message == nil && !field.Desc.IsList() {
+				param.Schema = g.singularSchema(field)
+			}
+			renames[item[1]] = param
+		}
+		if len(matches) > 0 && value != matches[0][0] {
+			pattern := paramsPattern.ReplaceAllStringFunc(value, func(s string) string {
+				return "{" + renames[paramsPattern.FindStringSubmatch(s)[1]].Name + "}"
+			})
+			for _, item := range matches {
+				param := renames[item[1]]
+				param.Description = joinDescription(param.Description, fmt.Sprintf("Bound to `%s` as `%s`.", display, pattern))
+			}
+		}
+	}
+
+	params := make([]*parameter, 0, len(renames))
+	path := paramsPattern.ReplaceAllStringFunc(bind.Path, func(s string) string {
+		param, ok := renames[paramsPattern.FindStringSubmatch(s)[1]]
+		if !ok {
+			return s
+		}
+		params = append(params, param)
+		return "{" + param.Name + "}"
+	})
+	return path, params, nil
+}
+
+// queryParameters returns the parameters of the fields not bound to the path,
+// the nested messages are flattened with the dotted field paths the same as
+// rest.PopulateQueryParameters. The maps and the recursive messages are skipped.
+func (g *generator) queryParameters(message *protogen.Message, protoPrefix, displayPrefix string, pathVars map[string]string, visiting map[protoreflect.FullName]bool) []*parameter {
+	visiting[message.Desc.FullName()] = true
+	defer delete(visiting, message.Desc.FullName())
+
+	var params []*parameter
+	for _, field := range message.Fields {
+		protoPath := protoPrefix + string(field.Desc.Name())
+		display := displayPrefix + g.fieldName(field)
+		if _, ok := pathVars[protoPath]; ok || field.Desc.IsMap() {
+			continue
+		}
+		if field.Message != nil && !queryWellKnown[field.Message.Desc.FullName()] {
+			if field.Desc.IsList() || visiting[field.Message.Desc.FullName()] {
+				continue
+			}
+			params = append(params, g.queryParameters(field.Message, protoPath+".", display+".", pathVars, visiting)...)
+			continue
+		}
+		s := g.fieldSchema(field)
+		params = append(params, &parameter{
+			Name:        display,
+			In:          "query",
+			Description: s.Description,
+			Schema:      s,
+		})
+		s.Description = ""
+	}
+	return params
+}
+
+func (g *generator) displayPath(fields []*protogen.Field) string {
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		names = append(names, g.fieldName(field))
+	}
+	return strings.Join(names, ".")
+}
+
+// findFields returns the fields of the dotted proto field path.
+func findFields(message *protogen.Message, path string) ([]*protogen.Field, error) {
+	var fields []*protogen.Field
+	for _, name := range strings.Split(path, ".") {
+		if message == nil {
+			return nil, fmt.Errorf("%s: %s is not a message", path, fields[len(fields)-1].Desc.Name())
+		}
+		var field *protogen.Field
+		for _, item := range message.Fields {
+			if string(item.Desc.Name()) == name {
+				field = item
+				break
+			}
+		}
+		if field == nil {
+			return nil, fmt.Errorf("%s: field %s not found in %s", path, name, message.Desc.FullName())
+		}
+		fields = append(fields, field)
+		message = field.Message
+	}
+	return fields, nil
+}
+
+// reasonErrors groups the reasons declared by the enums with the default_reason
+// option by the http status, the same as the status derived from the reasons.
+func reasonErrors(files []*protogen.File) []*errorResponse {
+	responses := map[int32]*errorResponse{}
+	for _, file := range files {
+		for _, enum := range file.Enums {
+			if !proto.HasExtension(enum.Desc.Options(), reason.E_DefaultReason) {
+				continue
+			}
+			for _, value := range enum.Values {
+				c := proto.GetExtension(value.Desc.Options(), reason.E_Code).(code.Code)
+				if c == code.Code_OK {
+					continue
+				}
+				httpCode := status.New(c, nil).HttpCode()
+				item, ok := responses[httpCode]
+				if !ok {
+					item = &errorResponse{httpCode: httpCode}
+					responses[httpCode] = item
+				}
+				line := fmt.Sprintf("- `%s` (%s, %s)", value.Desc.Name(), file.Desc.Package(), c)
+				if desc := comment(value.Comments); desc != "" {
+					line += ": " + strings.ReplaceAll(desc, "\n", " ")
+				}
+				item.reasons = append(item.reasons, line)
+			}
+		}
+	}
+	res := make([]*errorResponse, 0, len(responses))
+	for _, item := range responses {
+		res = append(res, item)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].httpCode < res[j].httpCode })
+	return res
+}
@@ -0,0 +1,275 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genopenapi
+
+import (
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/proto/yggdrasil/reason"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+	"gopkg.in/yaml.v3"
+)
+
+func testField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(jsonName(name)),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     typ.Enum(),
+	}
+	if typeName != "" {
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+func jsonName(name string) string {
+	res := make([]byte, 0, len(name))
+	upper := false
+	for i := 0; i < len(name); i++ {
+		if name[i] == '_' {
+			upper = true
+			continue
+		}
+		c := name[i]
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		res = append(res, c)
+	}
+	return string(res)
+}
+
+func testMethod(name, input, output string, rule *annotations.HttpRule) *descriptorpb.MethodDescriptorProto {
+	opts := &descriptorpb.MethodOptions{}
+	proto.SetExtension(opts, annotations.E_Http, rule)
+	return &descriptorpb.MethodDescriptorProto{
+		Name:       proto.String(name),
+		InputType:  proto.String(input),
+		OutputType: proto.String(output),
+		Options:    opts,
+	}
+}
+
+func testStreamMethod(name, input, output string, rule *annotations.HttpRule) *descriptorpb.MethodDescriptorProto {
+	method := testMethod(name, input, output, rule)
+	method.ServerStreaming = proto.Bool(true)
+	return method
+}
+
+func testFile() *descriptorpb.FileDescriptorProto {
+	enumOpts := &descriptorpb.EnumOptions{}
+	proto.SetExtension(enumOpts, reason.E_DefaultReason, int32(500))
+	notFound := &descriptorpb.EnumValueOptions{}
+	proto.SetExtension(notFound, reason.E_Code, code.Code_NOT_FOUND)
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test/v1/shelf.proto"),
+		Package:    proto.String("test.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/api/annotations.proto", reason.File_reason_proto.Path()},
+		Options:    &descriptorpb.FileOptions{GoPackage: proto.String("example.com/test/v1;testpb")},
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			{
+				Name: proto.String("Kind"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("KIND_UNSPECIFIED"), Number: proto.Int32(0)},
+					{Name: proto.String("KIND_PUBLIC"), Number: proto.Int32(1)},
+				},
+			},
+			{
+				Name:    proto.String("Reason"),
+				Options: enumOpts,
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("REASON_UNSPECIFIED"), Number: proto.Int32(0)},
+					{Name: proto.String("SHELF_NOT_FOUND"), Number: proto.Int32(1), Options: notFound},
+				},
+			},
+		},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Shelf"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					testField("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					testField("kind", 2, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.v1.Kind"),
+					testField("book_count", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					testField("parent", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.v1.Shelf"),
+				},
+			},
+			{
+				Name: proto.String("Filter"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					testField("tag", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				},
+			},
+			{
+				Name: proto.String("GetShelfRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					testField("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					testField("filter", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.v1.Filter"),
+				},
+			},
+			{
+				Name: proto.String("UpdateShelfRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					testField("shelf", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.v1.Shelf"),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("ShelfService"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				testMethod("GetShelf", ".test.v1.GetShelfRequest", ".test.v1.Shelf", &annotations.HttpRule{
+					Pattern: &annotations.HttpRule_Get{Get: "/v1/{name=shelves/*}"},
+				}),
+				testStreamMethod("WatchShelf", ".test.v1.GetShelfRequest", ".test.v1.Shelf", &annotations.HttpRule{
+					Pattern: &annotations.HttpRule_Get{Get: "/v1/{name=shelves/*}:watch"},
+				}),
+				testMethod("UpdateShelf", ".test.v1.UpdateShelfRequest", ".test.v1.Shelf", &annotations.HttpRule{
+					Pattern: &annotations.HttpRule_Patch{Patch: "/v1/{shelf.name=shelves/*}"},
+					Body:    "shelf",
+					AdditionalBindings: []*annotations.HttpRule{{
+						Pattern: &annotations.HttpRule_Put{Put: "/v1/{shelf.name=shelves/*}"},
+						Body:    "*",
+					}},
+				}),
+			},
+		}},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{
+			Location: []*descriptorpb.SourceCodeInfo_Location{
+				{Path: []int32{6, 0, 2, 0}, Span: []int32{1, 0, 10}, LeadingComments: proto.String(" Gets a shelf.\n")},
+				{Path: []int32{5, 1, 2, 1}, Span: []int32{2, 0, 10}, LeadingComments: proto.String(" The shelf does not exist.\n")},
+			},
+		},
+	}
+}
+
+func generate(t *testing.T, opts Options) map[string]interface{} {
+	var files []*descriptorpb.FileDescriptorProto
+	seen := map[string]bool{}
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+		for i := 0; i < fd.Imports().Len(); i++ {
+			if dep := fd.Imports().Get(i).FileDescriptor; !dep.IsPlaceholder() {
+				add(dep)
+			}
+		}
+		file := protodesc.ToFileDescriptorProto(fd)
+		for i, dep := range file.Dependency {
+			// reason.proto is compiled with google/rpc/code.proto at another path.
+			if dep == "google/api/rpc/code.proto" {
+				file.Dependency[i] = code.File_google_rpc_code_proto.Path()
+			}
+		}
+		if file.GetOptions().GetGoPackage() == "" {
+			// The go package is required by protogen, but not used by the documents.
+			file.Options = &descriptorpb.FileOptions{GoPackage: proto.String("example.com/deps/" + fd.Path())}
+		}
+		files = append(files, file)
+	}
+	add(annotations.File_google_api_annotations_proto)
+	add(code.File_google_rpc_code_proto)
+	add(reason.File_reason_proto)
+	files = append(files, testFile())
+
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"test/v1/shelf.proto"},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile:      files,
+	})
+	require.NoError(t, err)
+	require.NoError(t, GenerateFiles(gen, opts))
+	res := gen.Response()
+	require.Nil(t, res.Error)
+	require.Len(t, res.File, 1)
+	assert.Equal(t, "test/v1/shelf.openapi.yaml", res.File[0].GetName())
+
+	doc := map[string]interface{}{}
+	require.NoError(t, yaml.Unmarshal([]byte(res.File[0].GetContent()), &doc))
+	return doc
+}
+
+func lookup(t *testing.T, v interface{}, keys ...interface{}) interface{} {
+	for _, key := range keys {
+		switch k := key.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			require.True(t, ok, "%v is not an object", key)
+			v = m[k]
+		case int:
+			l, ok := v.([]interface{})
+			require.True(t, ok, "%v is not an array", key)
+			require.Greater(t, len(l), k)
+			v = l[k]
+		}
+	}
+	return v
+}
+
+func TestGenerateFiles(t *testing.T) {
+	doc := generate(t, Options{})
+	assert.Equal(t, openapiVersion, doc["openapi"])
+
+	get := lookup(t, doc, "paths", "/v1/shelves/{name}", "get")
+	assert.Equal(t, "ShelfService_GetShelf", lookup(t, get, "operationId"))
+	assert.Equal(t, "Gets a shelf.", lookup(t, get, "description"))
+	assert.Equal(t, "name", lookup(t, get, "parameters", 0, "name"))
+	assert.Equal(t, "path", lookup(t, get, "parameters", 0, "in"))
+	assert.Equal(t, "filter.tag", lookup(t, get, "parameters", 1, "name"))
+	assert.Equal(t, "query", lookup(t, get, "parameters", 1, "in"))
+	assert.Contains(t, lookup(t, get, "responses", "404", "description"), "`SHELF_NOT_FOUND` (test.v1, NOT_FOUND): The shelf does not exist.")
+	assert.Equal(t, "#/components/schemas/google.rpc.Status", lookup(t, get, "responses", "default", "content", "application/json", "schema", "$ref"))
+
+	watch := lookup(t, doc, "paths", "/v1/shelves/{name}:watch", "get", "responses", "200", "content")
+	assert.Equal(t, "#/components/schemas/test.v1.Shelf", lookup(t, watch, "application/x-ndjson", "schema", "properties", "result", "$ref"))
+	assert.Equal(t, "#/components/schemas/google.rpc.Status", lookup(t, watch, "application/x-ndjson", "schema", "properties", "status", "$ref"))
+	assert.Contains(t, watch, "text/event-stream")
+
+	patch := lookup(t, doc, "paths", "/v1/shelves/{shelf.name}", "patch")
+	assert.Equal(t, "ShelfService_UpdateShelf", lookup(t, patch, "operationId"))
+	assert.Equal(t, "#/components/schemas/test.v1.Shelf", lookup(t, patch, "requestBody", "content", "application/json", "schema", "$ref"))
+	put := lookup(t, doc, "paths", "/v1/shelves/{shelf.name}", "put")
+	assert.Equal(t, "ShelfService_UpdateShelf_1", lookup(t, put, "operationId"))
+	assert.Equal(t, "#/components/schemas/test.v1.UpdateShelfRequest", lookup(t, put, "requestBody", "content", "application/json", "schema", "$ref"))
+
+	shelf := lookup(t, doc, "components", "schemas", "test.v1.Shelf", "properties")
+	assert.Equal(t, "string", lookup(t, shelf, "bookCount", "type"))
+	assert.Equal(t, "int64", lookup(t, shelf, "bookCount", "format"))
+	assert.Equal(t, []interface{}{"KIND_UNSPECIFIED", "KIND_PUBLIC"}, lookup(t, shelf, "kind", "enum"))
+	assert.Equal(t, "#/components/schemas/test.v1.Shelf", lookup(t, shelf, "parent", "$ref"))
+}
+
+func TestGenerateFiles_ProtoNames(t *testing.T) {
+	doc := generate(t, Options{Naming: "proto", Title: "Shelf", Version: "v1"})
+	assert.Equal(t, "Shelf", lookup(t, doc, "info", "title"))
+	assert.Equal(t, "v1", lookup(t, doc, "info", "version"))
+	shelf := lookup(t, doc, "components", "schemas", "test.v1.Shelf", "properties")
+	assert.Contains(t, shelf, "book_count")
+}
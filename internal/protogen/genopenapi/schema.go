@@ -0,0 +1,212 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genopenapi
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const statusSchema = "google.rpc.Status"
+
+// wellKnownSchemas are the schemas of the well known types, they follow the
+// protojson encoding.
+var wellKnownSchemas = map[protoreflect.FullName]func() *schema{
+	"google.protobuf.Timestamp": func() *schema { return &schema{Type: "string", Format: "date-time"} },
+	"google.protobuf.Duration": func() *schema {
+		return &schema{Type: "string", Description: "Duration in seconds with up to nine fractional digits, ending with 's'."}
+	},
+	"google.protobuf.FieldMask":   func() *schema { return &schema{Type: "string", Description: "Comma separated field paths."} },
+	"google.protobuf.DoubleValue": func() *schema { return &schema{Type: "number", Format: "double"} },
+	"google.protobuf.FloatValue":  func() *schema { return &schema{Type: "number", Format: "float"} },
+	"google.protobuf.Int64Value":  func() *schema { return &schema{Type: "string", Format: "int64"} },
+	"google.protobuf.UInt64Value": func() *schema { return &schema{Type: "string", Format: "uint64"} },
+	"google.protobuf.Int32Value":  func() *schema { return &schema{Type: "integer", Format: "int32"} },
+	"google.protobuf.UInt32Value": func() *schema { return &schema{Type: "integer", Format: "int64"} },
+	"google.protobuf.BoolValue":   func() *schema { return &schema{Type: "boolean"} },
+	"google.protobuf.StringValue": func() *schema { return &schema{Type: "string"} },
+	"google.protobuf.BytesValue":  func() *schema { return &schema{Type: "string", Format: "byte"} },
+	"google.protobuf.Struct":      func() *schema { return &schema{Type: "object", AdditionalProperties: true} },
+	"google.protobuf.Value":       func() *schema { return &schema{} },
+	"google.protobuf.ListValue":   func() *schema { return &schema{Type: "array", Items: &schema{}} },
+	"google.protobuf.Empty":       func() *schema { return &schema{Type: "object"} },
+	"google.protobuf.Any": func() *schema {
+		properties := newOrderedMap[*schema]()
+		properties.Set("@type", &schema{Type: "string"})
+		return &schema{Type: "object", Properties: properties, AdditionalProperties: true}
+	},
+}
+
+func schemaRef(name protoreflect.FullName) *schema {
+	return &schema{Ref: "#/components/schemas/" + string(name)}
+}
+
+// fieldName returns the name of the field in the json body and the query.
+func (g *generator) fieldName(field *protogen.Field) string {
+	if g.opts.Naming == "proto" {
+		return string(field.Desc.Name())
+	}
+	return field.Desc.JSONName()
+}
+
+// messageSchema returns the schema referring the message, the schemas of the
+// message and the messages it depends on are added to the components.
+func (g *generator) messageSchema(message *protogen.Message) *schema {
+	name := message.Desc.FullName()
+	if fn, ok := wellKnownSchemas[name]; ok {
+		return fn()
+	}
+	if _, ok := g.doc.Components.Schemas.Get(string(name)); ok {
+		return schemaRef(name)
+	}
+	s := &schema{Type: "object", Description: comment(message.Comments)}
+	// Register before building the fields, so the recursive messages refer it.
+	g.doc.Components.Schemas.Set(string(name), s)
+	if len(message.Fields) > 0 {
+		s.Properties = newOrderedMap[*schema]()
+	}
+	for _, field := range message.Fields {
+		s.Properties.Set(g.fieldName(field), g.fieldSchema(field))
+	}
+	return schemaRef(name)
+}
+
+func (g *generator) fieldSchema(field *protogen.Field) *schema {
+	var s *schema
+	switch {
+	case field.Desc.IsMap():
+		s = &schema{Type: "object", AdditionalProperties: g.singularSchema(field.Message.Fields[1])}
+	case field.Desc.IsList():
+		s = &schema{Type: "array", Items: g.singularSchema(field)}
+	default:
+		s = g.singularSchema(field)
+	}
+	if s.Ref != "" {
+		// The siblings of $ref are ignored, the description of the field is
+		// dropped in favour of the one of the message.
+		return s
+	}
+	s.Description = joinDescription(comment(field.Comments), s.Description)
+	if behaviors, ok := proto.GetExtension(field.Desc.Options(), annotations.E_FieldBehavior).([]annotations.FieldBehavior); ok {
+		for _, item := range behaviors {
+			if item == annotations.FieldBehavior_OUTPUT_ONLY {
+				s.ReadOnly = true
+			}
+		}
+	}
+	if field.Desc.Options().(*descriptorpb.FieldOptions).GetDeprecated() {
+		s.Deprecated = true
+	}
+	return s
+}
+
+func (g *generator) singularSchema(field *protogen.Field) *schema {
+	switch field.Desc.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return g.messageSchema(field.Message)
+	case protoreflect.EnumKind:
+		return enumSchema(field.Enum)
+	default:
+		return scalarSchema(field.Desc.Kind())
+	}
+}
+
+func scalarSchema(kind protoreflect.Kind) *schema {
+	switch kind {
+	case protoreflect.BoolKind:
+		return &schema{Type: "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return &schema{Type: "integer", Format: "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return &schema{Type: "integer", Format: "int64"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		// protojson encodes the 64-bit integers as strings.
+		return &schema{Type: "string", Format: "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return &schema{Type: "string", Format: "uint64"}
+	case protoreflect.FloatKind:
+		return &schema{Type: "number", Format: "float"}
+	case protoreflect.DoubleKind:
+		return &schema{Type: "number", Format: "double"}
+	case protoreflect.BytesKind:
+		return &schema{Type: "string", Format: "byte"}
+	default:
+		return &schema{Type: "string"}
+	}
+}
+
+// enumSchema inlines the enum, the values are encoded by name and the comments
+// of the values are listed in the description.
+func enumSchema(enum *protogen.Enum) *schema {
+	s := &schema{Type: "string"}
+	var lines []string
+	for _, value := range enum.Values {
+		name := string(value.Desc.Name())
+		s.Enum = append(s.Enum, name)
+		if desc := comment(value.Comments); desc != "" {
+			lines = append(lines, fmt.Sprintf("- %s: %s", name, strings.ReplaceAll(desc, "\n", " ")))
+		}
+	}
+	s.Description = joinDescription(comment(enum.Comments), strings.Join(lines, "\n"))
+	return s
+}
+
+// statusSchemaRef returns the schema of the error responses, the rest server
+// writes the errors as google.rpc.Status.
+func (g *generator) statusSchemaRef() *schema {
+	if _, ok := g.doc.Components.Schemas.Get(statusSchema); !ok {
+		details := newOrderedMap[*schema]()
+		details.Set("@type", &schema{Type: "string"})
+		properties := newOrderedMap[*schema]()
+		properties.Set("code", &schema{Type: "integer", Format: "int32", Description: "The status code, which should be an enum value of google.rpc.Code."})
+		properties.Set("message", &schema{Type: "string", Description: "A developer-facing error message."})
+		properties.Set("details", &schema{
+			Type:        "array",
+			Description: "A list of messages that carry the error details, such as google.rpc.ErrorInfo with the reason.",
+			Items:       &schema{Type: "object", Properties: details, AdditionalProperties: true},
+		})
+		g.doc.Components.Schemas.Set(statusSchema, &schema{
+			Type:        "object",
+			Description: "The error returned by the server.",
+			Properties:  properties,
+		})
+	}
+	return schemaRef(statusSchema)
+}
+
+// comment returns the leading comments without the comment markers.
+func comment(comments protogen.CommentSet) string {
+	lines := strings.Split(strings.TrimRight(string(comments.Leading), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(strings.TrimPrefix(line, " "), " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func joinDescription(items ...string) string {
+	res := make([]string, 0, len(items))
+	for _, item := range items {
+		if item != "" {
+			res = append(res, item)
+		}
+	}
+	return strings.Join(res, "\n\n")
+}
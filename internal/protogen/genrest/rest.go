@@ -32,9 +32,6 @@ func GenerateFiles(gen *protogen.Plugin, file *protogen.File) error {
 	pass := false
 	for _, service := range file.Services {
 		for _, method := range service.Methods {
-			if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
-				continue
-			}
 			rule, ok := proto.GetExtension(method.Desc.Options(), annotations.E_Http).(*annotations.HttpRule)
 			if rule != nil && ok {
 				pass = true
@@ -113,39 +110,55 @@ func genService(_ *protogen.Plugin, file *protogen.File, g *protogen.GeneratedFi
 }
 
 func buildMethod(sd *serviceDesc, g *protogen.GeneratedFile, method *protogen.Method) error {
-	if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
-		return nil
+	bindings, err := Bindings(method)
+	if err != nil {
+		return err
+	}
+	for _, item := range bindings {
+		sd.Methods = append(sd.Methods, buildMethodDesc(g, method, item))
 	}
-	rule, ok := proto.GetExtension(method.Desc.Options(), annotations.E_Http).(*annotations.HttpRule)
+	return nil
+}
+
+// Binding is the http binding of a rpc method declared by the google.api.http
+// option. It is shared by the generators of the rest handlers and the api
+// documents, so the documents describe what the handlers do.
+type Binding struct {
+	// Method is the http method.
+	Method string
+	// Path is the route path, the path variables are replaced by {paramsN}.
+	Path string
+	// PathVars maps the request field path to the route value of the variable.
+	// The value is {paramsN}, or the pattern with the params like
+	// shelves/{params0} if the variable has a multi segments pattern.
+	PathVars map[string]string
+	// Body is the request field path bound to the body, * is the whole request
+	// and empty is no body. The query parameters are bound if there is no body.
+	Body string
+}
+
+// Bindings returns the http bindings of the method, the additional bindings
+// come first.
+func Bindings(m *protogen.Method) ([]*Binding, error) {
+	rule, ok := proto.GetExtension(m.Desc.Options(), annotations.E_Http).(*annotations.HttpRule)
 	if !ok || rule == nil {
-		return nil
+		return nil, nil
 	}
-	for _, bind := range rule.AdditionalBindings {
-		item, err := buildHTTPRule(g, method, bind)
+	bindings := make([]*Binding, 0, len(rule.AdditionalBindings)+1)
+	for _, item := range append(rule.AdditionalBindings, rule) {
+		bind, err := buildBinding(item)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if item == nil {
-			continue
-		}
-		sd.Methods = append(sd.Methods, item)
-	}
-	item, err := buildHTTPRule(g, method, rule)
-	if err != nil {
-		return err
-	}
-	if item == nil {
-		return nil
+		bindings = append(bindings, bind)
 	}
-	sd.Methods = append(sd.Methods, item)
-	return nil
+	return bindings, nil
 }
 
-func buildHTTPRule(g *protogen.GeneratedFile, m *protogen.Method, rule *annotations.HttpRule) (*methodDesc, error) {
+func buildBinding(rule *annotations.HttpRule) (*Binding, error) {
 	var (
 		path   string
 		method string
-		body   string
 	)
 	switch pattern := rule.Pattern.(type) {
 	case *annotations.HttpRule_Get:
@@ -168,12 +181,7 @@ func buildHTTPRule(g *protogen.GeneratedFile, m *protogen.Method, rule *annotati
 		method = pattern.Custom.Kind
 	}
 
-	md, err := buildMethodDesc(g, m, method, path)
-	if err != nil {
-		return nil, err
-	}
-
-	body = rule.Body
+	body := rule.Body
 	if method == http.MethodGet || method == http.MethodDelete {
 		if body != "" {
 			return nil, fmt.Errorf("%s %s body should not be declared", method, path)
@@ -183,28 +191,62 @@ func buildHTTPRule(g *protogen.GeneratedFile, m *protogen.Method, rule *annotati
 			return nil, fmt.Errorf("%s %s does not declare a body", method, path)
 		}
 	}
-
-	if body == "*" {
-		body = ""
-		md.HasBody = true
-	} else if body != "" {
-		md.HasBody = true
-		md.Body = "." + camelCaseVars(body)
-	}
-
-	return md, nil
+	bind := &Binding{Method: method, Body: body}
+	bind.Path, bind.PathVars = buildPathVars(path)
+	return bind, nil
 }
 
-func buildMethodDesc(g *protogen.GeneratedFile, m *protogen.Method, method, path string) (*methodDesc, error) {
+func buildMethodDesc(g *protogen.GeneratedFile, m *protogen.Method, bind *Binding) *methodDesc {
 	defer func() { methodSets[m.GoName]++ }()
 	desc := &methodDesc{
-		Name:    m.GoName,
-		Num:     methodSets[m.GoName],
-		Request: g.QualifiedGoIdent(m.Input.GoIdent),
-		Method:  method,
+		Name:      m.GoName,
+		ProtoName: string(m.Desc.Name()),
+		Num:       methodSets[m.GoName],
+		Request:   g.QualifiedGoIdent(m.Input.GoIdent),
+		Method:    bind.Method,
+		Path:      bind.Path,
+		PathVars:  bind.PathVars,
+		ErrRet:    "nil, ",
+	}
+	if m.Desc.IsStreamingClient() {
+		// The websocket is upgraded from GET, the messages are the frames.
+		desc.WebSocket = true
+		desc.Method = http.MethodGet
+		return desc
+	}
+	if m.Desc.IsStreamingServer() {
+		desc.ServerStream = true
+		desc.ErrRet = ""
+	} else {
+		desc.Reply = g.QualifiedGoIdent(m.Output.GoIdent)
+	}
+	if bind.Body == "*" {
+		desc.HasBody = true
+	} else if bind.Body != "" {
+		desc.HasBody = true
+		desc.Body = "." + camelCaseVars(bind.Body)
+		for _, field := range m.Input.Fields {
+			if string(field.Desc.Name()) == bind.Body && field.Message != nil && !field.Desc.IsList() && !field.Desc.IsMap() {
+				desc.BodyType = g.QualifiedGoIdent(field.Message.GoIdent)
+			}
+		}
+		if bind.Method == http.MethodPatch {
+			desc.UpdateMask = updateMaskField(m.Input)
+		}
+	}
+	return desc
+}
+
+// updateMaskField returns the go name of the update_mask field, it is empty if
+// the request has no google.protobuf.FieldMask field named update_mask.
+func updateMaskField(msg *protogen.Message) string {
+	for _, field := range msg.Fields {
+		if field.Desc.Name() == "update_mask" && field.Message != nil && !field.Desc.IsList() &&
+			field.Message.Desc.FullName() == "google.protobuf.FieldMask" {
+			return field.GoName
+		}
 	}
-	desc.Path, desc.PathVars = buildPathVars(path)
-	return desc, nil
+	return ""
 }
 
 var (
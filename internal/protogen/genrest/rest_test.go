@@ -3,6 +3,8 @@ package genrest
 import (
 	"encoding/json"
 	"fmt"
+	"go/parser"
+	"go/token"
 	"regexp"
 	"strings"
 	"testing"
@@ -73,3 +75,38 @@ func Test_buildPathVars(t *testing.T) {
 		}
 	}
 }
+
+func TestServiceDesc_Gateway(t *testing.T) {
+	s := &serviceDesc{
+		HttpPkg:        "http.",
+		RestPkg:        "rest.",
+		SvrPkg:         "server.",
+		StatusPkg:      "status.",
+		CodePkg:        "code.",
+		InterceptorPkg: "interceptor.",
+		CtxPkg:         "context.",
+		ServiceType:    "Library",
+		ServiceName:    "test.Library",
+		Methods: []*methodDesc{
+			{Name: "GetBook_", ProtoName: "get_book", Method: "GET", Path: "/v1/book",
+				Request: "GetBookRequest", Reply: "Book", ErrRet: "nil, "},
+			{Name: "ListBooks", ProtoName: "ListBooks", Method: "GET", Path: "/v1/books",
+				Request: "ListBooksRequest", ServerStream: true},
+		},
+	}
+	out := s.execute()
+	_, err := parser.ParseFile(token.NewFileSet(), "", "package test\n"+out, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rpc is forwarded by the method name of the proto file.
+	if !strings.Contains(out, `gw.Invoke(ctx, "/test.Library/get_book", req, out)`) {
+		t.Fatalf("the forwarded method is not the proto name:\n%s", out)
+	}
+	if !strings.Contains(out, "GatewayHandler: local_gateway_Library_GetBook__0,") {
+		t.Fatalf("the gateway handler is not set:\n%s", out)
+	}
+	if strings.Contains(out, "local_gateway_Library_ListBooks_0") {
+		t.Fatalf("the gateway handler is generated for the stream:\n%s", out)
+	}
+}
@@ -10,50 +10,116 @@ import (
 
 var restTemplate = `
 {{range $method := .Methods }}
-func local_handler_{{$.ServiceType}}_{{ .Name }}_{{.Num}}(w {{$.HttpPkg}}ResponseWriter, r *{{$.HttpPkg}}Request, server interface{}, unaryInt {{$.InterceptorPkg}}UnaryServerInterceptor) (interface{}, error) {
+{{- if not $method.WebSocket}}
+{{- if $method.ServerStream}}
+func local_decoder_{{$.ServiceType}}_{{ .Name }}_{{.Num}}(r *{{$.HttpPkg}}Request, req interface{}) error {
+		protoReq := req.(*{{$method.Request}})
+{{- else}}
+func local_request_{{$.ServiceType}}_{{ .Name }}_{{.Num}}(r *{{$.HttpPkg}}Request) (*{{$method.Request}}, error) {
 		protoReq := &{{$method.Request}}{}
-		{{if $method.HasBody }}
-			inbound := {{$.MarshalerPkg}}InboundFromContext(r.Context())
-			if err := inbound.NewDecoder(r.Body).Decode(protoReq{{$method.Body}}); err != nil && err != {{$.IoPkg}}EOF {
-				return nil, {{$.StatusPkg}}New({{$.CodePkg}}Code_INVALID_ARGUMENT, err)
+{{- end}}
+		{{- if $method.BodyType}}
+			protoReq{{$method.Body}} = &{{$method.BodyType}}{}
+		{{- end}}
+		{{if $method.UpdateMask }}
+			if err := {{$.RestPkg}}PopulateQueryParameters(protoReq, r.URL.Query()); err != nil {
+				return {{$method.ErrRet}}{{$.StatusPkg}}New({{$.CodePkg}}Code_INVALID_ARGUMENT, err)
+			}
+			if fm, err := {{$.RestPkg}}DecodeBodyFieldMask(r, protoReq{{$method.Body}}); err != nil {
+				return {{$method.ErrRet}}err
+			} else if len(protoReq.{{$method.UpdateMask}}.GetPaths()) == 0 {
+				protoReq.{{$method.UpdateMask}} = fm
+			}
+			if err := {{$.RestPkg}}ValidateFieldMask(protoReq.{{$method.UpdateMask}}, protoReq{{$method.Body}}); err != nil {
+				return {{$method.ErrRet}}err
+			}
+		{{else if $method.HasBody }}
+			if err := {{$.RestPkg}}DecodeBody(r, protoReq{{$method.Body}}); err != nil {
+				return {{$method.ErrRet}}err
 			}
 		{{else -}}
 			if err := {{$.RestPkg}}PopulateQueryParameters(protoReq, r.URL.Query()); err != nil {
-				return nil,  {{$.StatusPkg}}New({{$.CodePkg}}Code_INVALID_ARGUMENT, err)
+				return {{$method.ErrRet}}{{$.StatusPkg}}New({{$.CodePkg}}Code_INVALID_ARGUMENT, err)
 			}
 		{{end -}}
 
 		{{- range  $key, $value := .PathVars}}
 			if val := {{parsePathValues $value }}; len(val) == 0 {
-				return nil, {{$.StatusPkg}}Errorf({{$.CodePkg}}Code_INVALID_ARGUMENT, "not found {{$key}}")
+				return {{$method.ErrRet}}{{$.StatusPkg}}Errorf({{$.CodePkg}}Code_INVALID_ARGUMENT, "not found {{$key}}")
 			} else if err := {{$.RestPkg}}PopulateFieldFromPath(protoReq, {{$key | printf "%q"}}, val); err != nil {
-				return nil, {{$.StatusPkg}}New({{$.CodePkg}}Code_INVALID_ARGUMENT, err)
+				return {{$method.ErrRet}}{{$.StatusPkg}}New({{$.CodePkg}}Code_INVALID_ARGUMENT, err)
 			}
 		{{- end}}
-	
+	{{if $method.ServerStream}}
+		return nil
+	{{- else}}
+		return protoReq, nil
+	{{- end}}
+}
+{{- if not $method.ServerStream}}
+
+func local_handler_{{$.ServiceType}}_{{ .Name }}_{{.Num}}(w {{$.HttpPkg}}ResponseWriter, r *{{$.HttpPkg}}Request, srv interface{}, unaryInt {{$.InterceptorPkg}}UnaryServerInterceptor) (interface{}, error) {
+		protoReq, err := local_request_{{$.ServiceType}}_{{ .Name }}_{{.Num}}(r)
+		if err != nil {
+			return nil, err
+		}
+		handler := func(ctx {{$.CtxPkg}}Context, req interface{}) (interface{}, error) {
+			return srv.({{$.ServiceType}}Server).{{$method.Name}}(ctx, req.(*{{$method.Request}}))
+		}
 		if unaryInt == nil {
-			return  server.({{$.ServiceType}}Server).{{$method.Name}}(r.Context(), protoReq)
+			return handler(r.Context(), protoReq)
 		}
 
 		info := &interceptor.UnaryServerInfo{
-			Server:     server,
+			Server:     srv,
 			FullMethod: "{{$.ServiceName}}/{{ .Name }}",
 		}
+		return unaryInt(r.Context(), protoReq, info, handler)
+}
+
+func local_gateway_{{$.ServiceType}}_{{ .Name }}_{{.Num}}(w {{$.HttpPkg}}ResponseWriter, r *{{$.HttpPkg}}Request, gw {{$.SvrPkg}}RestGateway, unaryInt {{$.InterceptorPkg}}UnaryServerInterceptor) (interface{}, error) {
+		protoReq, err := local_request_{{$.ServiceType}}_{{ .Name }}_{{.Num}}(r)
+		if err != nil {
+			return nil, err
+		}
 		handler := func(ctx {{$.CtxPkg}}Context, req interface{}) (interface{}, error) {
-			return server.({{$.ServiceType}}Server).{{$method.Name}}(ctx, req.(*{{$method.Request}}))
+			out := &{{$method.Reply}}{}
+			if err := gw.Invoke(ctx, "/{{$.ServiceName}}/{{$method.ProtoName}}", req, out); err != nil {
+				return nil, err
+			}
+			return out, nil
+		}
+		if unaryInt == nil {
+			return handler(r.Context(), protoReq)
+		}
+
+		info := &interceptor.UnaryServerInfo{
+			Server:     gw,
+			FullMethod: "{{$.ServiceName}}/{{ .Name }}",
 		}
 		return unaryInt(r.Context(), protoReq, info, handler)
 }
+{{- end}}
+{{- end}}
 {{end -}}
 
 var {{$.ServiceType}}RestServiceDesc = {{$.SvrPkg}}RestServiceDesc{
+	ServiceName: "{{$.ServiceName}}",
 	HandlerType: (*{{$.ServiceType}}Server)(nil),
 	Methods: []{{$.SvrPkg}}RestMethodDesc{
 		{{range $method := .Methods -}}
 		{
+			MethodName: "{{$method.ProtoName}}",
 			Method: "{{$method.Method}}",
 			Path: "{{$method.Path}}",
+			{{- if $method.WebSocket}}
+			WebSocket: true,
+			{{- else if $method.ServerStream}}
+			StreamDecoder: local_decoder_{{$.ServiceType}}_{{ .Name }}_{{.Num}},
+			{{- else}}
 			Handler:    local_handler_{{$.ServiceType}}_{{ .Name }}_{{.Num}},
+			GatewayHandler: local_gateway_{{$.ServiceType}}_{{ .Name }}_{{.Num}},
+			{{- end}}
 		},
 		{{end -}}
 	},
@@ -78,16 +144,35 @@ type serviceDesc struct {
 }
 
 type methodDesc struct {
-	Name    string
-	Num     int
-	Method  string
-	Request string
+	Name string
+	// ProtoName is the method name declared in the proto file, the method
+	// interceptors are configured by it.
+	ProtoName string
+	Num       int
+	Method    string
+	Request   string
+	Reply     string
 
 	PathVars map[string]string
 	Path     string
 
 	Body    string
 	HasBody bool
+	// BodyType is the message type of the body field, the field is allocated
+	// before decoding.
+	BodyType string
+	// UpdateMask is the field name of the update mask populated from the body
+	// keys, if the patch request has no mask.
+	UpdateMask string
+
+	// ServerStream generates the request decoder of the server streaming
+	// method instead of the handler.
+	ServerStream bool
+	// WebSocket upgrades the client streaming method to websocket, no handler
+	// is generated.
+	WebSocket bool
+	// ErrRet is the leading return values before the error.
+	ErrRet string
 }
 
 func (s *serviceDesc) execute() string {
@@ -41,9 +41,15 @@ type restServiceDesc struct {
 	Prefix []string
 }
 
+type restGatewayDesc struct {
+	serviceName string
+	Prefix      []string
+}
+
 type options struct {
 	serviceDesc       map[*server.ServiceDesc]interface{}
 	restServiceDesc   map[*server.RestServiceDesc]restServiceDesc
+	restGatewayDesc   map[*server.RestServiceDesc]restGatewayDesc
 	restRawHandleDesc []*server.RestRawHandlerDesc
 	server            server.Server
 	governor          *governor.Server
@@ -131,6 +137,19 @@ func WithRestServiceDesc(desc *server.RestServiceDesc, impl interface{}, prefix
 	}
 }
 
+// WithRestGatewayDesc serves the rest routes of the service by forwarding the
+// requests to the remote service discovered by the resolvers, the serviceName
+// is the name of the client.
+func WithRestGatewayDesc(desc *server.RestServiceDesc, serviceName string, prefix ...string) Option {
+	return func(opts *options) error {
+		opts.restGatewayDesc[desc] = restGatewayDesc{
+			serviceName: serviceName,
+			Prefix:      prefix,
+		}
+		return nil
+	}
+}
+
 func WithRestRawHandleDesc(desc ...*server.RestRawHandlerDesc) Option {
 	return func(opts *options) error {
 		opts.restRawHandleDesc = append(opts.restRawHandleDesc, desc...)
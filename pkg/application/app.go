@@ -49,6 +49,10 @@ const (
 
 var defaultShutdownTimeout = time.Second * 30
 
+// forceExitDelay is the extra time given to the force closing of the servers and
+// the after stop hooks, before the process is killed by the shutdown signal.
+const forceExitDelay = time.Second * 5
+
 const (
 	registryStateInit = iota
 	registryStateDone
@@ -140,8 +144,14 @@ func (app *Application) Stop() error {
 			app.runHooks(StageAfterStop)
 			defers.Done()
 		}()
-		app.deregister()
-		err = app.stopServers()
+		deregCtx, deregCancel := context.WithTimeout(context.Background(), app.getShutdownTimeout())
+		app.deregister(deregCtx)
+		deregCancel()
+		// The active streams are given the whole shutdown timeout after the
+		// application is deregistered.
+		ctx, cancel := context.WithTimeout(context.Background(), app.getShutdownTimeout())
+		defer cancel()
+		err = app.stopServers(ctx)
 	})
 	if err != nil {
 		return err
@@ -191,7 +201,7 @@ func (app *Application) register() {
 	logger.Info("application has been registered")
 }
 
-func (app *Application) deregister() {
+func (app *Application) deregister(ctx context.Context) {
 	if app.registry == nil {
 		return
 	}
@@ -202,11 +212,11 @@ func (app *Application) deregister() {
 	}
 	app.registryState = registryStateCancel
 	app.mu.Unlock()
-	ctx, cancel := context.WithTimeout(context.TODO(), defaultShutdownTimeout)
-	defer cancel()
 	if err := app.registry.Deregister(ctx, app); err != nil {
 		logger.ErrorField("fault to deregister application", logger.Err(err))
+		return
 	}
+	logger.Info("application has been deregistered")
 }
 
 func (app *Application) startServers() error {
@@ -239,13 +249,14 @@ func (app *Application) startServers() error {
 	return eg.Wait()
 }
 
-func (app *Application) stopServers() error {
-	eg := errgroup.Group{}
+func (app *Application) stopServers(ctx context.Context) error {
+	// The server is drained before the others, so that the progress can be
+	// watched through the governor.
+	var err error
 	if app.server != nil {
-		eg.Go(func() error {
-			return app.server.Stop()
-		})
+		err = app.server.Stop(ctx)
 	}
+	eg := errgroup.Group{}
 
 	for _, item := range app.internalSvr {
 		svr := item
@@ -257,8 +268,10 @@ func (app *Application) stopServers() error {
 	eg.Go(func() error {
 		return app.governor.Stop()
 	})
-
-	return eg.Wait()
+	if egErr := eg.Wait(); err == nil {
+		err = egErr
+	}
+	return err
 }
 
 func (app *Application) Region() string {
@@ -326,7 +339,9 @@ func (app *Application) waitSignals() {
 	go func() {
 		s := <-sig
 		go func() {
-			<-time.After(app.getShutdownTimeout())
+			// Both the deregistration and the stopping of the servers are
+			// bounded by the shutdown timeout.
+			<-time.After(2*app.getShutdownTimeout() + forceExitDelay)
 			os.Exit(128 + int(s.(syscall.Signal)))
 		}()
 		go func() {
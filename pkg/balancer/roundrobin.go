@@ -66,8 +66,7 @@ type roundRobinPicker struct {
 }
 
 func (r *roundRobinPicker) Next(ri RpcInfo) (PickResult, error) {
-	endpoints := r.endpoint
-	if len(endpoints) == 0 {
+	if len(r.endpoint) == 0 {
 		return nil, status.Errorf(code.Code_UNAVAILABLE, "not found endpoint")
 	}
 	idx := r.idx % int64(len(r.endpoint))
@@ -76,9 +75,11 @@ func (r *roundRobinPicker) Next(ri RpcInfo) (PickResult, error) {
 	return res, nil
 }
 
+// RoundRobin picks the endpoints in turn. The endpoints are replaced as a whole
+// by Update, the picker keeps the endpoints it is created with.
 type RoundRobin struct {
 	idx      atomic.Int64
-	endpoint []*instance
+	endpoint atomic.Pointer[[]*instance]
 }
 
 func newRoundRobin(string) Balancer {
@@ -86,9 +87,13 @@ func newRoundRobin(string) Balancer {
 }
 
 func (b *RoundRobin) GetPicker() Picker {
+	var endpoints []*instance
+	if p := b.endpoint.Load(); p != nil {
+		endpoints = *p
+	}
 	return &roundRobinPicker{
 		idx:      b.idx.Add(1),
-		endpoint: b.endpoint,
+		endpoint: endpoints,
 	}
 }
 
@@ -98,7 +103,7 @@ func (b *RoundRobin) Update(values config.Values) {
 		logger.ErrorField("fault to load endpoints config", logger.Err(err))
 		return
 	}
-	b.endpoint = endpoints
+	b.endpoint.Store(&endpoints)
 }
 
 func (b *RoundRobin) Close() error {
@@ -0,0 +1,81 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func endpointsValues(t *testing.T, key string, addresses ...string) config.Values {
+	endpoints := make([]interface{}, 0, len(addresses))
+	for _, item := range addresses {
+		endpoints = append(endpoints, map[string]interface{}{"address": item, "protocol": "grpc"})
+	}
+	require.NoError(t, config.Set(config.Join(key, config.KeySingleEndpoints), endpoints))
+	return config.ValueToValues(config.Get(key))
+}
+
+func TestRoundRobin_Next(t *testing.T) {
+	b := newRoundRobin("rr")
+	_, err := b.GetPicker().Next(RpcInfo{Ctx: context.Background()})
+	assert.Error(t, err)
+
+	b.Update(endpointsValues(t, "test.rr.next", "a", "b"))
+	picker := b.GetPicker()
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		res, err := picker.Next(RpcInfo{Ctx: context.Background()})
+		require.NoError(t, err)
+		seen[res.Endpoint().GetAddress()]++
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2}, seen)
+}
+
+func TestRoundRobin_ConcurrentUpdate(t *testing.T) {
+	b := newRoundRobin("rr")
+	values := []config.Values{
+		endpointsValues(t, "test.rr.concurrent.0", "a"),
+		endpointsValues(t, "test.rr.concurrent.1", "b", "c"),
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			b.Update(values[i%len(values)])
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 1000; k++ {
+				res, err := b.GetPicker().Next(RpcInfo{Ctx: context.Background()})
+				if err != nil {
+					continue
+				}
+				assert.Contains(t, []string{"a", "b", "c"}, fmt.Sprint(res.Endpoint().GetAddress()))
+			}
+		}()
+	}
+	wg.Wait()
+}
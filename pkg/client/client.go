@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -75,22 +76,80 @@ func (i instance) GetMetadata() map[string]interface{} {
 	return i.Metadata
 }
 
+// errRemoteClientRetired is returned when the picked remote client is removed by
+// a newer snapshot, the stream is picked again from the latest snapshot.
+var errRemoteClientRetired = status.Errorf(code.Code_UNAVAILABLE, "remote client is retired")
+
+// pickSnap is the snapshot used to pick the remote client of a stream. It is
+// immutable once published, the config and resolver updates publish a new one.
 type pickSnap struct {
-	version   int64
-	balancer  balancer.Balancer
-	remoteCli map[string]remote.Client
+	version int64
+	// balancerName is the name of the builder of the balancer, the balancer is
+	// built again for each snapshot rather than updated in place.
+	balancerName string
+	balancer     balancer.Balancer
+	remoteCli    map[string]*remoteClient
+}
+
+// remoteClient counts the active streams of the remote client, it is closed
+// after the last stream finishes once retired.
+type remoteClient struct {
+	remote.Client
+	streams   atomic.Int64
+	retired   atomic.Bool
+	closeOnce sync.Once
+}
+
+// acquire takes a stream slot, it fails if the client is retired.
+func (rc *remoteClient) acquire() bool {
+	rc.streams.Add(1)
+	if rc.retired.Load() {
+		rc.release()
+		return false
+	}
+	return true
+}
+
+func (rc *remoteClient) release() {
+	if rc.streams.Add(-1) == 0 && rc.retired.Load() {
+		rc.close()
+	}
+}
+
+// retire marks the client removed, it is closed now if there is no active stream.
+func (rc *remoteClient) retire() {
+	rc.retired.Store(true)
+	if rc.streams.Load() == 0 {
+		rc.close()
+	}
+}
+
+func (rc *remoteClient) close() {
+	rc.closeOnce.Do(func() {
+		_ = rc.Client.Close()
+	})
 }
 
 type clientStream struct {
 	desc *stream.StreamDesc
 	stream.ClientStream
 	report func(err error)
+	// release releases the stream slot of the remote client, it is idempotent.
+	release func()
+	// stop unregisters the release on the context done.
+	stop func() bool
+}
+
+func (c *clientStream) finish() {
+	c.stop()
+	c.release()
 }
 
 func (c *clientStream) SendMsg(m interface{}) error {
 	err := c.ClientStream.SendMsg(m)
 	if err != nil && err != io.EOF {
 		c.report(err)
+		c.finish()
 	}
 	return err
 }
@@ -108,20 +167,24 @@ func (c *clientStream) RecvMsg(m interface{}) error {
 	if err != nil && err != io.EOF && !c.desc.ServerStreams {
 		c.report(err)
 	}
+	if err != nil || !c.desc.ServerStreams {
+		c.finish()
+	}
 	return err
 }
 
 type client struct {
-	ctx               context.Context
-	serviceName       string
-	configChange      chan config.WatchEvent
-	transportBackoff  backoff.Strategy
-	snapVersion       atomic.Int64
-	pickSnap          pickSnap
-	resolvedEvent     *xsync.Event
-	resolver          resolver.Resolver
-	balancer          balancer.Balancer
-	remoteCli         map[string]remote.Client
+	ctx              context.Context
+	serviceName      string
+	configChange     chan config.WatchEvent
+	transportBackoff backoff.Strategy
+	snapVersion      atomic.Int64
+	pickSnap         atomic.Pointer[pickSnap]
+	resolvedEvent    *xsync.Event
+	resolver         resolver.Resolver
+	// mu serializes the snapshot updates and Close.
+	mu                sync.Mutex
+	closed            atomic.Bool
 	unaryInterceptor  interceptor.UnaryClientInterceptor
 	streamInterceptor interceptor.StreamClientInterceptor
 	statsHandler      stats.Handler
@@ -132,7 +195,6 @@ func NewClient(ctx context.Context, serviceName string) (Client, error) {
 		ctx:           ctx,
 		serviceName:   serviceName,
 		configChange:  make(chan config.WatchEvent, 1),
-		remoteCli:     map[string]remote.Client{},
 		resolvedEvent: xsync.NewEvent(),
 		statsHandler:  stats.GetClientHandler(),
 	}
@@ -158,7 +220,11 @@ func (c *client) initResolverAndBalancer(cfg config.Values) error {
 	if err != nil {
 		return err
 	}
-	c.balancer = balancerBuilder(c.serviceName)
+	c.pickSnap.Store(&pickSnap{
+		balancerName: balancerName,
+		balancer:     balancerBuilder(c.serviceName),
+		remoteCli:    map[string]*remoteClient{},
+	})
 	resolverName := cfg.Get(config.KeySingleResolver).String()
 	if resolverName != "" {
 		r, err := resolver.GetResolver(resolverName)
@@ -205,9 +271,15 @@ func (c *client) handlePickConfig(cfg config.Values) {
 		logger.ErrorField("fault to load client config", logger.Err(err))
 		return
 	}
-	remoteCli := make(map[string]remote.Client, len(endpoints))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed.Load() {
+		return
+	}
+	old := c.pickSnap.Load()
+	remoteCli := make(map[string]*remoteClient, len(endpoints))
 	for _, item := range endpoints {
-		if cli, ok := c.remoteCli[item.Address]; ok {
+		if cli, ok := old.remoteCli[item.Address]; ok {
 			remoteCli[item.Address] = cli
 			continue
 		}
@@ -218,36 +290,32 @@ func (c *client) handlePickConfig(cfg config.Values) {
 		}
 		cli := builder(c.ctx, c.serviceName, item, c.statsHandler)
 		if cli != nil {
-			remoteCli[item.Address] = cli
+			remoteCli[item.Address] = &remoteClient{Client: cli}
 		}
 
 	}
-	needDel := make([]remote.Client, 0)
-	for key, item := range c.remoteCli {
-		if _, ok := remoteCli[key]; ok {
-			continue
-		}
-		needDel = append(needDel, item)
-	}
 
-	b := c.balancer
+	// The balancer of the old snapshot may still be picking with the old clients,
+	// so the new one is built and updated before it is published with the clients.
 	balancerName := cfg.Get(config.KeySingleBalancer).String("round_robin")
-	if balancerName != c.balancer.Name() {
-		balancerBuilder, err := balancer.GetBuilder(balancerName)
-		if err != nil {
-			logger.Warn(err.Error())
-		} else {
-			b = balancerBuilder(c.serviceName)
-		}
+	balancerBuilder, err := balancer.GetBuilder(balancerName)
+	if err != nil {
+		logger.Warn(err.Error())
+		balancerName = old.balancerName
+		balancerBuilder, _ = balancer.GetBuilder(balancerName)
 	}
+	b := balancerBuilder(c.serviceName)
 	b.Update(cfg)
-	c.remoteCli = remoteCli
-	c.balancer = b
 	version := c.snapVersion.Add(1)
-	c.pickSnap = pickSnap{balancer: b, remoteCli: remoteCli, version: version}
-	for _, item := range needDel {
-		_ = item.Close()
+	c.pickSnap.Store(&pickSnap{balancerName: balancerName, balancer: b, remoteCli: remoteCli, version: version})
+	// The streams picked from the old snapshot keep the removed clients open
+	// until they finish.
+	for key, item := range old.remoteCli {
+		if _, ok := remoteCli[key]; !ok {
+			item.retire()
+		}
 	}
+	_ = old.balancer.Close()
 	if len(endpoints) != 0 {
 		c.resolvedEvent.Fire()
 	}
@@ -296,7 +364,7 @@ func (c *client) waitForResolved(ctx context.Context) error {
 	}
 }
 
-func (c *client) newConnStream(ctx context.Context, picker balancer.Picker, snap pickSnap, desc *stream.StreamDesc, method string) (stream.ClientStream, error) {
+func (c *client) newConnStream(ctx context.Context, picker balancer.Picker, snap *pickSnap, desc *stream.StreamDesc, method string) (stream.ClientStream, error) {
 	r, err := picker.Next(balancer.RpcInfo{
 		Ctx:    ctx,
 		Method: method,
@@ -308,15 +376,24 @@ func (c *client) newConnStream(ctx context.Context, picker balancer.Picker, snap
 	if !ok || cli == nil {
 		return nil, status.Errorf(code.Code_UNAVAILABLE, "server cannot connect")
 	}
+	if !cli.acquire() {
+		return nil, errRemoteClientRetired
+	}
 	st, err := cli.NewStream(ctx, desc, method)
 	if err != nil {
+		cli.release()
 		r.Report(err)
 		return nil, err
 	}
+	var once sync.Once
+	release := func() { once.Do(cli.release) }
 	return &clientStream{
 		desc:         desc,
 		ClientStream: st,
 		report:       r.Report,
+		release:      release,
+		// The stream abandoned by the caller is released when its context is done.
+		stop: context.AfterFunc(ctx, release),
 	}, nil
 }
 
@@ -325,9 +402,12 @@ func (c *client) newStream(ctx context.Context, desc *stream.StreamDesc, method
 		return nil, err
 	}
 	retries := 0
-	snap := c.pickSnap
+	snap := c.pickSnap.Load()
 	picker := snap.balancer.GetPicker()
 	for {
+		if c.closed.Load() {
+			return nil, ErrClientClosing
+		}
 		st, err := c.newConnStream(ctx, picker, snap, desc, method)
 		if err == nil {
 			return st, nil
@@ -339,14 +419,22 @@ func (c *client) newStream(ctx context.Context, desc *stream.StreamDesc, method
 		if retries > 3 {
 			return nil, err
 		}
-		t := time.NewTimer(c.transportBackoff.Backoff(retries))
+		retries++
+		// Pick again from the latest snapshot without backoff if it is updated.
+		if latest := c.pickSnap.Load(); latest.version != snap.version {
+			snap = latest
+			picker = snap.balancer.GetPicker()
+			continue
+		}
+		t := time.NewTimer(c.transportBackoff.Backoff(retries - 1))
 		select {
 		case <-c.ctx.Done():
+			t.Stop()
 			return nil, ErrClientClosing
 		case <-ctx.Done():
+			t.Stop()
 			return nil, err
 		case <-t.C:
-			retries++
 		}
 	}
 }
@@ -373,6 +461,16 @@ func (c *client) NewStream(ctx context.Context, desc *stream.StreamDesc, method
 }
 
 func (c *client) Close() error {
+	c.mu.Lock()
+	if c.closed.Swap(true) {
+		c.mu.Unlock()
+		return ErrClientClosing
+	}
+	// The remote clients are closed after their active streams finish.
+	for _, item := range c.pickSnap.Load().remoteCli {
+		item.retire()
+	}
+	c.mu.Unlock()
 	var mErr []error
 	if err := config.DelWatcher(fmt.Sprintf(config.KeyClientInstance, c.serviceName), c.notifyConfigChange); err != nil {
 		mErr = append(mErr, err)
@@ -0,0 +1,266 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/balancer"
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/resolver"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStream struct {
+	ctx      context.Context
+	desc     *stream.StreamDesc
+	cli      *fakeClient
+	wait     chan struct{}
+	finished atomic.Bool
+}
+
+func (s *fakeStream) Header() (metadata.MD, error) { return nil, nil }
+func (s *fakeStream) Trailer() metadata.MD         { return nil }
+func (s *fakeStream) CloseSend() error             { return nil }
+func (s *fakeStream) Context() context.Context     { return s.ctx }
+func (s *fakeStream) SendMsg(any) error            { return nil }
+
+func (s *fakeStream) RecvMsg(any) error {
+	if s.desc.ServerStreams {
+		<-s.wait
+		s.finish()
+		return io.EOF
+	}
+	s.finish()
+	return nil
+}
+
+func (s *fakeStream) finish() {
+	if !s.finished.Swap(true) {
+		s.cli.active.Add(-1)
+	}
+}
+
+type fakeClient struct {
+	address  string
+	protocol string
+	active   atomic.Int64
+	// activeAtClose is the number of the active streams when the client is closed.
+	activeAtClose atomic.Int64
+	closed        atomic.Bool
+	// wait blocks RecvMsg of the server streams until it is closed.
+	wait chan struct{}
+}
+
+func (c *fakeClient) NewStream(ctx context.Context, desc *stream.StreamDesc, _ string) (stream.ClientStream, error) {
+	if c.closed.Load() {
+		return nil, fmt.Errorf("client %s is closed", c.address)
+	}
+	c.active.Add(1)
+	return &fakeStream{ctx: ctx, desc: desc, cli: c, wait: c.wait}, nil
+}
+
+func (c *fakeClient) Close() error {
+	c.activeAtClose.Store(c.active.Load())
+	c.closed.Store(true)
+	return nil
+}
+
+func (c *fakeClient) Scheme() string { return c.protocol }
+
+// fakeClients builds the fake clients of its own protocol, so the clients built
+// by a test are not seen by the others.
+type fakeClients struct {
+	protocol string
+	mu       sync.Mutex
+	clients  []*fakeClient
+}
+
+var fakeProtocolSeq atomic.Int64
+
+func newFakeClients() *fakeClients {
+	f := &fakeClients{protocol: fmt.Sprintf("fake-%d", fakeProtocolSeq.Add(1))}
+	remote.RegisterClientBuilder(f.protocol, f.build)
+	return f
+}
+
+func (f *fakeClients) build(_ context.Context, _ string, endpoint resolver.Endpoint, _ stats.Handler) remote.Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cli := &fakeClient{address: endpoint.GetAddress(), protocol: f.protocol, wait: make(chan struct{})}
+	f.clients = append(f.clients, cli)
+	return cli
+}
+
+func (f *fakeClients) get(address string) []*fakeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := make([]*fakeClient, 0)
+	for _, item := range f.clients {
+		if item.address == address {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+func (f *fakeClients) setEndpoints(serviceName string, addresses ...string) error {
+	endpoints := make([]interface{}, 0, len(addresses))
+	for _, item := range addresses {
+		endpoints = append(endpoints, map[string]interface{}{"address": item, "protocol": f.protocol})
+	}
+	return config.Set(fmt.Sprintf(config.KeyClientEndpoints, serviceName), endpoints)
+}
+
+func (f *fakeClients) newClient(t *testing.T, serviceName string, addresses ...string) *client {
+	require.NoError(t, f.setEndpoints(serviceName, addresses...))
+	cli, err := NewClient(context.Background(), serviceName)
+	require.NoError(t, err)
+	return cli.(*client)
+}
+
+// updateEndpoints updates the endpoints of the client, it is safe to call from
+// the goroutines other than the test one.
+func (f *fakeClients) updateEndpoints(c *client, addresses ...string) error {
+	if err := f.setEndpoints(c.serviceName, addresses...); err != nil {
+		return err
+	}
+	c.handlePickConfig(config.ValueToValues(config.Get(fmt.Sprintf(config.KeyClientInstance, c.serviceName))))
+	return nil
+}
+
+func TestClient_RetireAfterLastStream(t *testing.T) {
+	fakes := newFakeClients()
+	c := fakes.newClient(t, "retire", "retire-a")
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	st, err := c.NewStream(ctx, &stream.StreamDesc{ServerStreams: true}, "/test/stream")
+	require.NoError(t, err)
+
+	require.NoError(t, fakes.updateEndpoints(c, "retire-b"))
+	a := fakes.get("retire-a")
+	require.Len(t, a, 1)
+	assert.False(t, a[0].closed.Load(), "the client is closed with an active stream")
+	require.NoError(t, c.Invoke(ctx, "/test/unary", nil, nil))
+	require.Len(t, fakes.get("retire-b"), 1)
+
+	close(a[0].wait)
+	assert.Equal(t, io.EOF, st.RecvMsg(nil))
+	assert.True(t, a[0].closed.Load())
+	assert.Zero(t, a[0].activeAtClose.Load())
+}
+
+func TestClient_ReleaseOnContextDone(t *testing.T) {
+	fakes := newFakeClients()
+	c := fakes.newClient(t, "abandon", "abandon-a")
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := c.NewStream(ctx, &stream.StreamDesc{ClientStreams: true, ServerStreams: true}, "/test/stream")
+	require.NoError(t, err)
+
+	require.NoError(t, fakes.updateEndpoints(c, "abandon-b"))
+	a := fakes.get("abandon-a")
+	require.Len(t, a, 1)
+	assert.False(t, a[0].closed.Load())
+	cancel()
+	require.Eventually(t, a[0].closed.Load, time.Second, 10*time.Millisecond)
+}
+
+func TestClient_SnapshotBalancer(t *testing.T) {
+	fakes := newFakeClients()
+	c := fakes.newClient(t, "snapshot", "snapshot-a")
+	defer c.Close()
+	old := c.pickSnap.Load()
+
+	require.NoError(t, fakes.updateEndpoints(c, "snapshot-b"))
+	latest := c.pickSnap.Load()
+	assert.NotSame(t, old.balancer, latest.balancer)
+	// The balancer of the old snapshot still picks the clients of the snapshot.
+	for i := 0; i < 4; i++ {
+		res, err := old.balancer.GetPicker().Next(balancer.RpcInfo{Ctx: context.Background()})
+		require.NoError(t, err)
+		assert.Contains(t, old.remoteCli, res.Endpoint().GetAddress())
+		res, err = latest.balancer.GetPicker().Next(balancer.RpcInfo{Ctx: context.Background()})
+		require.NoError(t, err)
+		assert.Contains(t, latest.remoteCli, res.Endpoint().GetAddress())
+	}
+}
+
+func TestClient_ConcurrentUpdate(t *testing.T) {
+	fakes := newFakeClients()
+	c := fakes.newClient(t, "concurrent", "concurrent-a", "concurrent-b")
+	sets := [][]string{
+		{"concurrent-a", "concurrent-b"},
+		{"concurrent-b", "concurrent-c"},
+		{"concurrent-c"},
+		{"concurrent-a", "concurrent-c"},
+	}
+	stop := make(chan struct{})
+	// updateErr reports the error of the updates, require must not be called
+	// out of the test goroutine.
+	updateErr := make(chan error, 1)
+	go func() {
+		defer close(updateErr)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := fakes.updateEndpoints(c, sets[i%len(sets)]...); err != nil {
+				updateErr <- err
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 200; k++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				assert.NoError(t, c.Invoke(ctx, "/test/unary", nil, nil))
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	require.NoError(t, <-updateErr)
+	require.NoError(t, c.Close())
+
+	for _, address := range []string{"concurrent-a", "concurrent-b", "concurrent-c"} {
+		for _, item := range fakes.get(address) {
+			assert.True(t, item.closed.Load(), "client %s is not closed", address)
+			assert.Zero(t, item.activeAtClose.Load(), "client %s is closed with active streams", address)
+		}
+	}
+	_, err := c.NewStream(context.Background(), &stream.StreamDesc{}, "/test/stream")
+	assert.Equal(t, ErrClientClosing, err)
+}
@@ -40,8 +40,19 @@ var (
 	KeyClientStreamInt   = Join(KeyClientInterceptor, "stream")
 	KeyClientIntCfg      = Join(KeyClientInterceptor, "config", "{%s}")
 
-	KeyServer         = Join(KeyBase, "server")
-	KeyServerProtocol = Join(KeyServer, "protocol")
+	KeyServer                 = Join(KeyBase, "server")
+	KeyServerProtocol         = Join(KeyServer, "protocol")
+	KeyServerListeners        = Join(KeyServer, "listeners")
+	KeyServerListener         = Join(KeyServerListeners, "{%s}")
+	KeyServerListenerProtoCfg = Join(KeyServerListener, "protocolConfig")
+	KeyServerService          = Join(KeyServer, "services", "{%s}")
+	KeyServerServiceInt       = Join(KeyServerService, "interceptor")
+	KeyServerServiceUnaryInt  = Join(KeyServerServiceInt, "unary")
+	KeyServerServiceStreamInt = Join(KeyServerServiceInt, "stream")
+	KeyServerMethod           = Join(KeyServerService, "methods", "{%s}")
+	KeyServerMethodInt        = Join(KeyServerMethod, "interceptor")
+	KeyServerMethodUnaryInt   = Join(KeyServerMethodInt, "unary")
+	KeyServerMethodStreamInt  = Join(KeyServerMethodInt, "stream")
 
 	KeyGovernor = Join(KeyBase, "governor")
 
@@ -50,6 +61,8 @@ var (
 	KeyRestMarshaler        = Join(KeyRest, "marshaler")
 	KeyRestMarshalerSupport = Join(KeyRestMarshaler, "support")
 	KeyRestMarshalerCfg     = Join(KeyRestMarshaler, "config", "{%s}")
+	KeyRestMiddleware       = Join(KeyRest, "middleware")
+	KeyRestMiddlewareCfg    = Join(KeyRestMiddleware, "config", "{%s}")
 
 	KeyInterceptor     = Join(KeyBase, "interceptor")
 	KeyIntUnaryClient  = Join(KeyInterceptor, "unaryClient")
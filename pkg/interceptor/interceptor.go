@@ -246,7 +246,18 @@ func ChainUnaryServerInterceptors(names []string) UnaryServerInterceptor {
 		}
 		interceptors = append(interceptors, builder())
 	}
+	return ChainUnaryServer(interceptors...)
+}
 
+// ChainUnaryServer chains the unary server interceptors into one, the nil
+// interceptors are skipped.
+func ChainUnaryServer(ints ...UnaryServerInterceptor) UnaryServerInterceptor {
+	interceptors := make([]UnaryServerInterceptor, 0, len(ints))
+	for _, item := range ints {
+		if item != nil {
+			interceptors = append(interceptors, item)
+		}
+	}
 	if len(interceptors) == 0 {
 		return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
 			return handler(ctx, req)
@@ -280,7 +291,18 @@ func ChainStreamServerInterceptors(names []string) StreamServerInterceptor {
 		}
 		interceptors = append(interceptors, builder())
 	}
+	return ChainStreamServer(interceptors...)
+}
 
+// ChainStreamServer chains the stream server interceptors into one, the nil
+// interceptors are skipped.
+func ChainStreamServer(ints ...StreamServerInterceptor) StreamServerInterceptor {
+	interceptors := make([]StreamServerInterceptor, 0, len(ints))
+	for _, item := range ints {
+		if item != nil {
+			interceptors = append(interceptors, item)
+		}
+	}
 	if len(interceptors) == 0 {
 		return func(srv interface{}, ss stream.ServerStream, info *StreamServerInfo, handler stream.StreamHandler) error {
 			return handler(srv, ss)
@@ -0,0 +1,103 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func recordUnaryInt(calls *[]string, name string) UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		*calls = append(*calls, name)
+		return handler(ctx, req)
+	}
+}
+
+func recordStreamInt(calls *[]string, name string) StreamServerInterceptor {
+	return func(srv interface{}, ss stream.ServerStream, info *StreamServerInfo, handler stream.StreamHandler) error {
+		*calls = append(*calls, name)
+		return handler(srv, ss)
+	}
+}
+
+func TestChainUnaryServer(t *testing.T) {
+	var calls []string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls = append(calls, "handler")
+		return req, nil
+	}
+
+	t.Run("order", func(t *testing.T) {
+		calls = nil
+		chain := ChainUnaryServer(recordUnaryInt(&calls, "a"), nil, recordUnaryInt(&calls, "b"), recordUnaryInt(&calls, "c"))
+		reply, err := chain(context.Background(), "req", &UnaryServerInfo{}, handler)
+		assert.NoError(t, err)
+		assert.Equal(t, "req", reply)
+		assert.Equal(t, []string{"a", "b", "c", "handler"}, calls)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		calls = nil
+		reply, err := ChainUnaryServer(nil, nil)(context.Background(), "req", &UnaryServerInfo{}, handler)
+		assert.NoError(t, err)
+		assert.Equal(t, "req", reply)
+		assert.Equal(t, []string{"handler"}, calls)
+	})
+
+	t.Run("short circuit", func(t *testing.T) {
+		calls = nil
+		deny := func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+			return nil, errors.New("denied")
+		}
+		_, err := ChainUnaryServer(recordUnaryInt(&calls, "a"), deny, recordUnaryInt(&calls, "b"))(
+			context.Background(), "req", &UnaryServerInfo{}, handler)
+		assert.EqualError(t, err, "denied")
+		assert.Equal(t, []string{"a"}, calls)
+	})
+}
+
+func TestChainStreamServer(t *testing.T) {
+	var calls []string
+	handler := func(srv interface{}, ss stream.ServerStream) error {
+		calls = append(calls, "handler")
+		return nil
+	}
+
+	t.Run("order", func(t *testing.T) {
+		calls = nil
+		chain := ChainStreamServer(nil, recordStreamInt(&calls, "a"), recordStreamInt(&calls, "b"), nil)
+		assert.NoError(t, chain(nil, nil, &StreamServerInfo{}, handler))
+		assert.Equal(t, []string{"a", "b", "handler"}, calls)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		calls = nil
+		assert.NoError(t, ChainStreamServer()(nil, nil, &StreamServerInfo{}, handler))
+		assert.Equal(t, []string{"handler"}, calls)
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		calls = nil
+		inner := ChainStreamServer(recordStreamInt(&calls, "b"), recordStreamInt(&calls, "c"))
+		chain := ChainStreamServer(recordStreamInt(&calls, "a"), inner)
+		assert.NoError(t, chain(nil, nil, &StreamServerInfo{}, handler))
+		assert.Equal(t, []string{"a", "b", "c", "handler"}, calls)
+	})
+}
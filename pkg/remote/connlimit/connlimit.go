@@ -0,0 +1,218 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package connlimit limits the connections accepted by the protocol servers.
+package connlimit
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// ReasonMaxConns is the reject reason when the connections exceed MaxConns.
+	ReasonMaxConns = "max_conns"
+	// ReasonMaxConnsPerIP is the reject reason when the connections from one ip exceed MaxConnsPerIP.
+	ReasonMaxConnsPerIP = "max_conns_per_ip"
+	// ReasonRate is the reject reason when the new connections exceed Rate.
+	ReasonRate = "rate"
+)
+
+// Config is the limits of the connections accepted by a listener, the zero value
+// means no limit.
+type Config struct {
+	// MaxConns is the max number of the concurrent connections.
+	MaxConns int
+	// MaxConnsPerIP is the max number of the concurrent connections from one remote ip.
+	MaxConnsPerIP int
+	// Rate is the max number of the new connections accepted per second.
+	Rate float64
+	// Burst is the max number of the new connections accepted at once, it is
+	// the integer part of Rate if it is less than that.
+	Burst int
+	// QueueTimeout is how long the connection exceeding MaxConns or Rate waits in
+	// the accept queue before it is rejected, the connection is rejected at once if
+	// it is zero. The connection exceeding MaxConnsPerIP is always rejected at once.
+	QueueTimeout time.Duration
+}
+
+// Enabled reports whether any limit is configured.
+func (c *Config) Enabled() bool {
+	return c.MaxConns > 0 || c.MaxConnsPerIP > 0 || c.Rate > 0
+}
+
+// RejectFunc is called before the rejected connection is closed.
+type RejectFunc func(conn net.Conn, reason string)
+
+type listener struct {
+	net.Listener
+	cfg      Config
+	onReject RejectFunc
+
+	slots   chan struct{} // nil if MaxConns is not limited
+	limiter *tokenBucket  // nil if Rate is not limited
+
+	mu     sync.Mutex
+	perIP  map[string]int
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewListener wraps lis with the limits in cfg. lis is returned as it is if there
+// is no limit configured.
+func NewListener(lis net.Listener, cfg Config, onReject RejectFunc) net.Listener {
+	if !cfg.Enabled() {
+		return lis
+	}
+	l := &listener{
+		Listener: lis,
+		cfg:      cfg,
+		onReject: onReject,
+		perIP:    map[string]int{},
+		closed:   make(chan struct{}),
+	}
+	if cfg.MaxConns > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConns)
+	}
+	if cfg.Rate > 0 {
+		l.limiter = newTokenBucket(cfg.Rate, cfg.Burst)
+	}
+	return l
+}
+
+// Accept waits for and returns the next connection within the limits, the
+// connections exceeding the limits are closed.
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		c, reason := l.admit(conn)
+		if c != nil {
+			return c, nil
+		}
+		l.reject(conn, reason)
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+func (l *listener) admit(conn net.Conn) (net.Conn, string) {
+	if l.limiter != nil && !l.limiter.wait(l.cfg.QueueTimeout, l.closed) {
+		return nil, ReasonRate
+	}
+	ip := remoteIP(conn)
+	if l.cfg.MaxConnsPerIP > 0 && ip != "" {
+		l.mu.Lock()
+		if l.perIP[ip] >= l.cfg.MaxConnsPerIP {
+			l.mu.Unlock()
+			return nil, ReasonMaxConnsPerIP
+		}
+		l.perIP[ip]++
+		l.mu.Unlock()
+	} else {
+		ip = ""
+	}
+	if l.slots != nil && !l.acquireSlot() {
+		l.releaseIP(ip)
+		return nil, ReasonMaxConns
+	}
+	return &limitedConn{Conn: conn, l: l, ip: ip}, ""
+}
+
+func (l *listener) acquireSlot() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.cfg.QueueTimeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(l.cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-l.closed:
+		return false
+	}
+}
+
+func (l *listener) release(ip string) {
+	if l.slots != nil {
+		<-l.slots
+	}
+	l.releaseIP(ip)
+}
+
+func (l *listener) releaseIP(ip string) {
+	if ip == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+		return
+	}
+	l.perIP[ip]--
+}
+
+func (l *listener) reject(conn net.Conn, reason string) {
+	if l.onReject != nil {
+		l.onReject(conn, reason)
+	}
+	_ = conn.Close()
+}
+
+// remoteIP returns the ip of the remote address, empty if the address has no ip,
+// e.g. unix domain socket.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UnixAddr:
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+type limitedConn struct {
+	net.Conn
+	l    *listener
+	ip   string
+	once sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.l.release(c.ip) })
+	return err
+}
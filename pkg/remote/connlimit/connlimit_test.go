@@ -0,0 +1,174 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connlimit
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rejects struct {
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (r *rejects) onReject(_ net.Conn, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reasons = append(r.reasons, reason)
+}
+
+func (r *rejects) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.reasons...)
+}
+
+func newTestListener(t *testing.T, cfg Config) (net.Listener, *rejects) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	r := &rejects{}
+	l := NewListener(lis, cfg, r.onReject)
+	t.Cleanup(func() { _ = l.Close() })
+	return l, r
+}
+
+func dial(t *testing.T, l net.Listener) net.Conn {
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func acceptAsync(l net.Listener) <-chan net.Conn {
+	ch := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(ch)
+			return
+		}
+		ch <- conn
+	}()
+	return ch
+}
+
+func TestNewListener_NoLimit(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	assert.Equal(t, lis, NewListener(lis, Config{}, nil))
+}
+
+func TestListener_MaxConns(t *testing.T) {
+	l, r := newTestListener(t, Config{MaxConns: 1})
+	dial(t, l)
+	conn, err := l.Accept()
+	require.NoError(t, err)
+
+	dial(t, l)
+	ch := acceptAsync(l)
+	require.Eventually(t, func() bool { return len(r.get()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{ReasonMaxConns}, r.get())
+
+	// The slot is released when the accepted connection is closed.
+	require.NoError(t, conn.Close())
+	dial(t, l)
+	select {
+	case c := <-ch:
+		require.NotNil(t, c)
+		_ = c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("connection is not accepted after the slot released")
+	}
+}
+
+func TestListener_MaxConnsQueue(t *testing.T) {
+	l, r := newTestListener(t, Config{MaxConns: 1, QueueTimeout: time.Second})
+	dial(t, l)
+	conn, err := l.Accept()
+	require.NoError(t, err)
+
+	dial(t, l)
+	ch := acceptAsync(l)
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, conn.Close())
+	select {
+	case c := <-ch:
+		require.NotNil(t, c)
+		_ = c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("queued connection is not accepted")
+	}
+	assert.Empty(t, r.get())
+}
+
+func TestListener_MaxConnsPerIP(t *testing.T) {
+	l, r := newTestListener(t, Config{MaxConnsPerIP: 2})
+	for i := 0; i < 2; i++ {
+		dial(t, l)
+		_, err := l.Accept()
+		require.NoError(t, err)
+	}
+	dial(t, l)
+	_ = acceptAsync(l)
+	require.Eventually(t, func() bool { return len(r.get()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{ReasonMaxConnsPerIP}, r.get())
+}
+
+func TestListener_CloseWhileQueued(t *testing.T) {
+	l, _ := newTestListener(t, Config{MaxConns: 1, QueueTimeout: time.Minute})
+	dial(t, l)
+	_, err := l.Accept()
+	require.NoError(t, err)
+	dial(t, l)
+	ch := acceptAsync(l)
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, l.Close())
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("accept is not interrupted by close")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 0)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	// burst is the integer part of rate
+	_, ok := b.reserve(0)
+	assert.True(t, ok)
+	_, ok = b.reserve(0)
+	assert.True(t, ok)
+	_, ok = b.reserve(0)
+	assert.False(t, ok)
+
+	wait, ok := b.reserve(time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	now = now.Add(time.Second)
+	_, ok = b.reserve(0)
+	assert.True(t, ok)
+}
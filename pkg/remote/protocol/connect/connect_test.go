@@ -0,0 +1,242 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type noopStats struct{}
+
+func (noopStats) TagRPC(ctx context.Context, _ stats.RPCTagInfo) context.Context { return ctx }
+
+func (noopStats) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (noopStats) TagChannel(ctx context.Context, _ stats.ChanTagInfo) context.Context { return ctx }
+
+func (noopStats) HandleChannel(context.Context, stats.ChanStats) {}
+
+func testHandle(ss remote.ServerStream) {
+	switch ss.Method() {
+	case "/test/Unary":
+		req := &wrapperspb.StringValue{}
+		if err := ss.Start(false, false); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		if err := ss.RecvMsg(req); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		md, _ := metadata.FromInContext(ss.Context())
+		_ = ss.SetHeader(metadata.Pairs("echo", md.Get("key")[0]))
+		ss.SetTrailer(metadata.Pairs("trailer", "value"))
+		ss.Finish(wrapperspb.String(req.Value), nil)
+	case "/test/ServerStream":
+		req := &wrapperspb.StringValue{}
+		if err := ss.Start(false, true); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		if err := ss.RecvMsg(req); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		for i := 0; i < 3; i++ {
+			_ = ss.SendMsg(req)
+		}
+		ss.SetTrailer(metadata.Pairs("trailer", "value"))
+		ss.Finish(nil, status.Errorf(code.Code_ABORTED, "done"))
+	default:
+		ss.Finish(nil, status.Errorf(code.Code_NOT_FOUND, "not found"))
+	}
+}
+
+func newTestServer() *server {
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = lis.Close()
+	return &server{
+		lis:          lis,
+		opts:         serverOptions{MaxReceiveMessageSize: defaultMaxReceiveMessageSize},
+		handle:       testHandle,
+		statsHandler: noopStats{},
+	}
+}
+
+func TestUnaryJSON(t *testing.T) {
+	s := newTestServer()
+	req := httptest.NewRequest(http.MethodPost, "/test/Unary", bytes.NewBufferString(`"hello"`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Key", "value")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "value", rec.Header().Get("Echo"))
+	assert.Equal(t, "value", rec.Header().Get("Trailer-Trailer"))
+	assert.Equal(t, `"hello"`, rec.Body.String())
+}
+
+func TestUnaryProtoError(t *testing.T) {
+	s := newTestServer()
+	data, _ := proto.Marshal(wrapperspb.String("hello"))
+	req := httptest.NewRequest(http.MethodPost, "/test/Unknown", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/proto")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	we := &wireError{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), we))
+	assert.Equal(t, "not_found", we.Code)
+	assert.Equal(t, "not found", we.Message)
+}
+
+func TestUnsupportedContentType(t *testing.T) {
+	s := newTestServer()
+	req := httptest.NewRequest(http.MethodPost, "/test/Unary", bytes.NewBufferString(`hello`))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+}
+
+func TestServerStream(t *testing.T) {
+	s := newTestServer()
+	body := &bytes.Buffer{}
+	data, _ := proto.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, writeEnvelope(body, 0, data))
+	req := httptest.NewRequest(http.MethodPost, "/test/ServerStream", body)
+	req.Header.Set("Content-Type", "application/connect+proto")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/connect+proto", rec.Header().Get("Content-Type"))
+
+	for i := 0; i < 3; i++ {
+		flags, data, err := readEnvelope(rec.Body, defaultMaxReceiveMessageSize)
+		require.NoError(t, err)
+		assert.Equal(t, byte(0), flags)
+		msg := &wrapperspb.StringValue{}
+		require.NoError(t, proto.Unmarshal(data, msg))
+		assert.Equal(t, "hello", msg.Value)
+	}
+	flags, data, err := readEnvelope(rec.Body, defaultMaxReceiveMessageSize)
+	require.NoError(t, err)
+	assert.Equal(t, byte(flagEnvelopeEndStream), flags)
+	end := &endStreamMessage{}
+	require.NoError(t, json.Unmarshal(data, end))
+	assert.Equal(t, "aborted", end.Error.Code)
+	assert.Equal(t, []string{"value"}, end.Metadata["trailer"])
+	_, _, err = readEnvelope(rec.Body, defaultMaxReceiveMessageSize)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestStreamingRequiresEnvelope(t *testing.T) {
+	s := newTestServer()
+	req := httptest.NewRequest(http.MethodPost, "/test/ServerStream", bytes.NewBufferString(`"hello"`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func startDrainServer(t *testing.T, name string, started chan struct{}) *server {
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyServerListenerProtoCfg, name), map[string]interface{}{
+		"address": "127.0.0.1:0",
+	}))
+	svr, err := newServer(name, func(ss remote.ServerStream) {
+		if ss.Method() != "/test/Block" {
+			testHandle(ss)
+			return
+		}
+		_ = ss.Start(false, false)
+		close(started)
+		<-ss.Context().Done()
+		ss.Finish(nil, ss.Context().Err())
+	})
+	require.NoError(t, err)
+	require.NoError(t, svr.Start())
+	go func() { _ = svr.Handle() }()
+	return svr.(*server)
+}
+
+func TestServer_DrainGraceful(t *testing.T) {
+	svr := startDrainServer(t, "connect-graceful", make(chan struct{}))
+	req, err := http.NewRequest(http.MethodPost, "http://"+svr.Info().Address+"/test/Unary", bytes.NewBufferString(`"hello"`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Key", "value")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, svr.Stop(ctx))
+	assert.NoError(t, ctx.Err())
+}
+
+func TestServer_DrainTimeout(t *testing.T) {
+	started := make(chan struct{})
+	svr := startDrainServer(t, "connect-timeout", started)
+	errCh := make(chan error, 1)
+	go func() {
+		resp, err := http.Post("http://"+svr.Info().Address+"/test/Block", "application/json", bytes.NewBufferString(`"block"`))
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}
+		errCh <- err
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rpc is not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_ = svr.Stop(ctx)
+	assert.GreaterOrEqual(t, time.Since(begin), 100*time.Millisecond)
+	select {
+	case err := <-errCh:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the active connection is not closed")
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	headerContentType           = "Content-Type"
+	headerContentEncoding       = "Content-Encoding"
+	headerAcceptEncoding        = "Accept-Encoding"
+	headerStreamContentEncoding = "Connect-Content-Encoding"
+	headerStreamAcceptEncoding  = "Connect-Accept-Encoding"
+	headerTimeout               = "Connect-Timeout-Ms"
+	headerTrailerPrefix         = "Trailer-"
+
+	contentTypeUnaryPrefix  = "application/"
+	contentTypeStreamPrefix = "application/connect+"
+	contentTypeJSON         = "application/json"
+
+	compressionIdentity = "identity"
+	compressionGzip     = "gzip"
+
+	flagEnvelopeCompressed = 0b00000001
+	flagEnvelopeEndStream  = 0b00000010
+
+	binHeaderSuffix = "-bin"
+)
+
+var acceptPost = strings.Join([]string{
+	contentTypeUnaryPrefix + codecNameJSON,
+	contentTypeUnaryPrefix + codecNameProto,
+	contentTypeStreamPrefix + codecNameJSON,
+	contentTypeStreamPrefix + codecNameProto,
+}, ", ")
+
+func acceptEncodingHeader(streaming bool) string {
+	if streaming {
+		return headerStreamAcceptEncoding
+	}
+	return headerAcceptEncoding
+}
+
+const (
+	codecNameJSON  = "json"
+	codecNameProto = "proto"
+)
+
+type codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return codecNameProto }
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return codecNameJSON }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+	return protojson.Marshal(msg)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
+	}
+	if len(data) == 0 {
+		// an empty body is a valid zero message
+		return nil
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, msg)
+}
+
+// codecFromContentType returns the codec of the content type and whether it is
+// a streaming request. A nil codec is returned when the content type is unknown.
+func codecFromContentType(contentType string) (codec, bool) {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	streaming := strings.HasPrefix(contentType, contentTypeStreamPrefix)
+	var name string
+	if streaming {
+		name = contentType[len(contentTypeStreamPrefix):]
+	} else if strings.HasPrefix(contentType, contentTypeUnaryPrefix) {
+		name = contentType[len(contentTypeUnaryPrefix):]
+	}
+	switch name {
+	case codecNameJSON:
+		return jsonCodec{}, streaming
+	case codecNameProto:
+		return protoCodec{}, streaming
+	default:
+		return nil, streaming
+	}
+}
+
+func isReservedHeader(key string) bool {
+	switch key {
+	case "content-type", "content-length", "content-encoding", "accept-encoding",
+		"te", "trailer", "transfer-encoding", "connection", "host":
+		return true
+	default:
+		return strings.HasPrefix(key, "connect-")
+	}
+}
+
+func extractInMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for key, vals := range header {
+		key = strings.ToLower(key)
+		if isReservedHeader(key) {
+			continue
+		}
+		if !strings.HasSuffix(key, binHeaderSuffix) {
+			md.Append(key, vals...)
+			continue
+		}
+		for _, v := range vals {
+			if b, err := decodeBinHeader(v); err == nil {
+				md.Append(key, string(b))
+			}
+		}
+	}
+	return md
+}
+
+func setMetadataHeader(header http.Header, prefix string, md metadata.MD) {
+	for key, vals := range md {
+		for _, v := range vals {
+			if strings.HasSuffix(key, binHeaderSuffix) {
+				v = base64.RawStdEncoding.EncodeToString([]byte(v))
+			}
+			header.Add(prefix+key, v)
+		}
+	}
+}
+
+func decodeBinHeader(v string) ([]byte, error) {
+	if len(v)%4 == 0 {
+		// Input was padded, or padding was not necessary.
+		return base64.StdEncoding.DecodeString(v)
+	}
+	return base64.RawStdEncoding.DecodeString(v)
+}
+
+func gzipCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gzipDecompress(data []byte, maxSize int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("connect: failed to decompress: %v", err))
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, int64(maxSize)+1))
+	if err != nil {
+		return nil, status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("connect: failed to decompress: %v", err))
+	}
+	if len(out) > maxSize {
+		return nil, status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("connect: received message larger than max (%d)", maxSize))
+	}
+	return out, nil
+}
+
+// writeEnvelope writes a length-prefixed message used by the streaming rpc.
+func writeEnvelope(w io.Writer, flags byte, data []byte) error {
+	var hdr [5]byte
+	hdr[0] = flags
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(data)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readEnvelope reads a length-prefixed message, io.EOF is returned when there
+// is no more messages.
+func readEnvelope(r io.Reader, maxSize int) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("connect: failed to read envelope: %v", err))
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if int64(size) > int64(maxSize) {
+		return 0, nil, status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("connect: received message larger than max (%d vs. %d)", size, maxSize))
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("connect: failed to read envelope: %v", err))
+	}
+	return hdr[0], data, nil
+}
+
+type errorDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type wireError struct {
+	Code    string        `json:"code"`
+	Message string        `json:"message,omitempty"`
+	Details []errorDetail `json:"details,omitempty"`
+}
+
+type endStreamMessage struct {
+	Error    *wireError          `json:"error,omitempty"`
+	Metadata map[string][]string `json:"metadata,omitempty"`
+}
+
+func newWireError(st *status.Status) *wireError {
+	pb := st.Status()
+	we := &wireError{
+		Code:    codeToString(code.Code(pb.Code)),
+		Message: pb.Message,
+	}
+	for _, item := range pb.Details {
+		typeName := item.TypeUrl
+		if i := strings.LastIndexByte(typeName, '/'); i >= 0 {
+			typeName = typeName[i+1:]
+		}
+		we.Details = append(we.Details, errorDetail{
+			Type:  typeName,
+			Value: base64.RawStdEncoding.EncodeToString(item.Value),
+		})
+	}
+	return we
+}
+
+func marshalError(st *status.Status) []byte {
+	data, err := json.Marshal(newWireError(st))
+	if err != nil {
+		return []byte(`{"code":"internal","message":"failed to marshal error message"}`)
+	}
+	return data
+}
+
+func codeToString(c code.Code) string {
+	if c == code.Code_CANCELLED {
+		// connect uses the american spelling
+		return "canceled"
+	}
+	if name, ok := code.Code_name[int32(c)]; ok {
+		return strings.ToLower(name)
+	}
+	return "unknown"
+}
+
+// codeToHttpStatus maps the status code as the connect protocol requires.
+func codeToHttpStatus(c code.Code) int {
+	switch c {
+	case code.Code_CANCELLED:
+		return 499
+	case code.Code_INVALID_ARGUMENT, code.Code_FAILED_PRECONDITION, code.Code_OUT_OF_RANGE:
+		return http.StatusBadRequest
+	case code.Code_DEADLINE_EXCEEDED:
+		return http.StatusGatewayTimeout
+	case code.Code_NOT_FOUND:
+		return http.StatusNotFound
+	case code.Code_ALREADY_EXISTS, code.Code_ABORTED:
+		return http.StatusConflict
+	case code.Code_PERMISSION_DENIED:
+		return http.StatusForbidden
+	case code.Code_RESOURCE_EXHAUSTED:
+		return http.StatusTooManyRequests
+	case code.Code_UNIMPLEMENTED:
+		return http.StatusNotImplemented
+	case code.Code_UNAVAILABLE:
+		return http.StatusServiceUnavailable
+	case code.Code_UNAUTHENTICATED:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
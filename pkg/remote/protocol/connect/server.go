@@ -0,0 +1,271 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package connect implements the server side of the Connect protocol
+// (https://connectrpc.com/docs/protocol), so that every registered service can
+// be called with plain http by browsers, curl and mobile clients.
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata/peer"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote/connlimit"
+	remotelg "github.com/imkuqin-zw/yggdrasil/pkg/remote/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/imkuqin-zw/yggdrasil/pkg/utils/xnet"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+const scheme = "connect"
+
+const defaultMaxReceiveMessageSize = 1024 * 1024 * 4
+
+func init() {
+	remote.RegisterServerBuilder(scheme, newServer)
+}
+
+type serverOptions struct {
+	Network               string
+	Address               string
+	MaxReceiveMessageSize int
+	ReadHeaderTimeout     time.Duration
+	IdleTimeout           time.Duration
+	// ConnLimit limits the connections accepted by the server.
+	ConnLimit connlimit.Config
+
+	Attr map[string]string
+}
+
+func (opts *serverOptions) SetDefault() error {
+	var err error
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.Address == "" {
+		opts.Address, err = xnet.Extract(opts.Address)
+		if err != nil {
+			return err
+		}
+		opts.Address = fmt.Sprintf("%s:0", opts.Address)
+	}
+	if opts.MaxReceiveMessageSize == 0 {
+		opts.MaxReceiveMessageSize = defaultMaxReceiveMessageSize
+	}
+	return nil
+}
+
+type server struct {
+	mu           sync.Mutex
+	name         string
+	address      string
+	lis          net.Listener
+	svr          *http.Server
+	serve        bool
+	stopped      bool
+	opts         serverOptions
+	handle       remote.MethodHandle
+	statsHandler stats.Handler
+}
+
+func newServer(name string, handle remote.MethodHandle) (remote.Server, error) {
+	opts := serverOptions{}
+	if err := remote.ServerConfig(scheme, name).Scan(&opts); err != nil {
+		return nil, err
+	}
+	if err := opts.SetDefault(); err != nil {
+		return nil, err
+	}
+	return &server{
+		name:         name,
+		opts:         opts,
+		handle:       handle,
+		statsHandler: stats.GetServerHandler(),
+	}, nil
+}
+
+func (s *server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return errors.New("server had already stopped")
+	}
+	if s.serve {
+		return errors.New("server had already serve")
+	}
+	s.serve = true
+	lis, err := net.Listen(s.opts.Network, s.opts.Address)
+	if err != nil {
+		return err
+	}
+	s.address = lis.Addr().String()
+	s.lis = connlimit.NewListener(lis, s.opts.ConnLimit, s.rejectConn)
+	// The bidi streaming requires http2, h2c serves it without tls.
+	h2s := &http2.Server{}
+	s.svr = &http.Server{
+		Handler:           h2c.NewHandler(s, h2s),
+		ReadHeaderTimeout: s.opts.ReadHeaderTimeout,
+		IdleTimeout:       s.opts.IdleTimeout,
+	}
+	// Let the shutdown of http server send GOAWAY to the h2c connections.
+	if err = http2.ConfigureServer(s.svr, h2s); err != nil {
+		_ = lis.Close()
+		return err
+	}
+	return nil
+}
+
+// rejectConn reports the connection rejected by the connection limits.
+func (s *server) rejectConn(conn net.Conn, reason string) {
+	ctx := s.statsHandler.TagChannel(context.Background(), &stats.ChanTagInfoBase{
+		RemoteEndpoint: conn.RemoteAddr().String(),
+		LocalEndpoint:  conn.LocalAddr().String(),
+		Protocol:       scheme,
+	})
+	s.statsHandler.HandleChannel(ctx, &stats.ChanRejectBase{Reason: reason})
+}
+
+func (s *server) Handle() error {
+	if err := s.svr.Serve(s.lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped || !s.serve {
+		s.stopped = true
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	s.mu.Unlock()
+	if err := s.svr.Shutdown(ctx); err != nil {
+		remotelg.Logger.WarnField("connect: server drain timeout, force close the active connections",
+			logger.String("address", s.address), logger.Err(err))
+		return s.svr.Close()
+	}
+	return nil
+}
+
+func (s *server) Info() remote.ServerInfo {
+	return remote.ServerInfo{
+		Address:  s.address,
+		Protocol: scheme,
+		Name:     s.name,
+		Attr:     s.opts.Attr,
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	contentType := r.Header.Get(headerContentType)
+	cd, streaming := codecFromContentType(contentType)
+	if cd == nil {
+		w.Header().Set("Accept-Post", acceptPost)
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	ss := &serverStream{
+		w:         w,
+		r:         r,
+		svr:       s,
+		codec:     cd,
+		streaming: streaming,
+		method:    r.URL.Path,
+	}
+	if streaming {
+		ss.contentType = contentTypeStreamPrefix + cd.Name()
+	} else {
+		ss.contentType = contentTypeUnaryPrefix + cd.Name()
+	}
+	ss.ctx = r.Context()
+	if err := ss.prepare(); err != nil {
+		ss.Finish(nil, err)
+		return
+	}
+	defer ss.cancel()
+
+	md := extractInMetadata(r.Header)
+	ctx := metadata.WithInContext(ss.ctx, md)
+	ctx = peer.PeerWithContext(ctx, s.getPeer(r))
+	ctx = s.statsHandler.TagRPC(ctx, &stats.RPCTagInfoBase{FullMethod: ss.method})
+	s.statsHandler.HandleRPC(ctx, &stats.RPCServerInHeaderBase{
+		RPCInHeaderBase: stats.RPCInHeaderBase{
+			Header:   md,
+			Protocol: scheme,
+		},
+		FullMethod:     ss.method,
+		RemoteEndpoint: r.RemoteAddr,
+		LocalEndpoint:  s.address,
+	})
+	ss.ctx = ctx
+	s.handle(ss)
+}
+
+func (s *server) getPeer(r *http.Request) *peer.Peer {
+	p := &peer.Peer{
+		LocalAddr: s.lis.Addr(),
+		Protocol:  scheme,
+	}
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		p.Addr = addr
+		p.RemoteIp = addr.IP.String()
+	}
+	return p
+}
+
+// prepare applies the timeout and the compression of the request.
+func (ss *serverStream) prepare() error {
+	ss.cancel = func() {}
+	if v := ss.r.Header.Get(headerTimeout); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ms < 0 || len(v) > 10 {
+			return status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("connect: invalid timeout %q", v))
+		}
+		ss.ctx, ss.cancel = context.WithTimeout(ss.ctx, time.Duration(ms)*time.Millisecond)
+	}
+	encodingHeader := headerContentEncoding
+	if ss.streaming {
+		encodingHeader = headerStreamContentEncoding
+	}
+	switch enc := strings.TrimSpace(ss.r.Header.Get(encodingHeader)); enc {
+	case "", compressionIdentity:
+	case compressionGzip:
+		ss.compressed = true
+	default:
+		ss.w.Header().Set(acceptEncodingHeader(ss.streaming), compressionGzip)
+		return status.Errorf(code.Code_UNIMPLEMENTED, fmt.Sprintf("connect: unsupported compression %q", enc))
+	}
+	return nil
+}
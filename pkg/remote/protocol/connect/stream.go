@@ -0,0 +1,310 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	remotelg "github.com/imkuqin-zw/yggdrasil/pkg/remote/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+// serverStream implements remote.ServerStream on top of a http request.
+type serverStream struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	w           http.ResponseWriter
+	r           *http.Request
+	svr         *server
+	codec       codec
+	method      string
+	contentType string
+	streaming   bool
+	compressed  bool
+
+	mu          sync.Mutex
+	header      metadata.MD
+	trailer     metadata.MD
+	headerSent  bool
+	wroteHeader bool
+	recvDone    bool
+
+	beginTime      time.Time
+	isClientStream bool
+	isServerStream bool
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *serverStream) SetHeader(md metadata.MD) error {
+	if md.Len() == 0 {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.headerSent {
+		return status.Errorf(code.Code_INTERNAL, "connect: the header has been sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	return nil
+}
+
+func (ss *serverStream) SendHeader(md metadata.MD) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.headerSent {
+		return status.Errorf(code.Code_INTERNAL, "connect: the header has been sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	if ss.streaming {
+		ss.writeStreamHeader()
+		return nil
+	}
+	// The unary response carries the header along with the body.
+	ss.headerSent = true
+	return nil
+}
+
+func (ss *serverStream) SetTrailer(md metadata.MD) {
+	if md.Len() == 0 {
+		return
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.trailer = metadata.Join(ss.trailer, md)
+}
+
+// writeStreamHeader must be called with ss.mu held.
+func (ss *serverStream) writeStreamHeader() {
+	if ss.wroteHeader {
+		return
+	}
+	ss.headerSent, ss.wroteHeader = true, true
+	setMetadataHeader(ss.w.Header(), "", ss.header)
+	ss.w.Header().Set(headerContentType, ss.contentType)
+	ss.w.WriteHeader(http.StatusOK)
+	ss.flush()
+}
+
+func (ss *serverStream) flush() {
+	if f, ok := ss.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (ss *serverStream) SendMsg(m any) error {
+	data, err := ss.codec.Marshal(m)
+	if err != nil {
+		return status.Errorf(code.Code_INTERNAL, fmt.Sprintf("connect: failed to marshal: %v", err))
+	}
+	ss.mu.Lock()
+	if ss.streaming {
+		ss.writeStreamHeader()
+		err = writeEnvelope(ss.w, 0, data)
+		ss.flush()
+	} else {
+		err = ss.writeUnary(data)
+	}
+	ss.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	ss.svr.statsHandler.HandleRPC(ss.ctx, &stats.RPCOutPayloadBase{
+		Payload:       m,
+		Data:          data,
+		TransportSize: len(data),
+		SendTime:      time.Now(),
+		Protocol:      scheme,
+	})
+	return nil
+}
+
+// writeUnary must be called with ss.mu held.
+func (ss *serverStream) writeUnary(data []byte) error {
+	if ss.wroteHeader {
+		return status.Errorf(code.Code_INTERNAL, "connect: unary rpc can only send one message")
+	}
+	ss.headerSent, ss.wroteHeader = true, true
+	header := ss.w.Header()
+	setMetadataHeader(header, "", ss.header)
+	setMetadataHeader(header, headerTrailerPrefix, ss.trailer)
+	header.Set(headerContentType, ss.contentType)
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	ss.w.WriteHeader(http.StatusOK)
+	_, err := ss.w.Write(data)
+	return err
+}
+
+func (ss *serverStream) RecvMsg(m any) error {
+	var (
+		data []byte
+		err  error
+	)
+	if ss.streaming {
+		data, err = ss.recvEnvelope()
+	} else {
+		data, err = ss.recvUnary()
+	}
+	if err != nil {
+		return err
+	}
+	if err = ss.codec.Unmarshal(data, m); err != nil {
+		return status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("connect: failed to unmarshal: %v", err))
+	}
+	ss.svr.statsHandler.HandleRPC(ss.ctx, &stats.RPCInPayloadBase{
+		Payload:       m,
+		Data:          data,
+		TransportSize: len(data),
+		RecvTime:      time.Now(),
+		Protocol:      scheme,
+	})
+	return nil
+}
+
+func (ss *serverStream) recvUnary() ([]byte, error) {
+	if ss.recvDone {
+		return nil, io.EOF
+	}
+	ss.recvDone = true
+	maxSize := ss.svr.opts.MaxReceiveMessageSize
+	data, err := io.ReadAll(io.LimitReader(ss.r.Body, int64(maxSize)+1))
+	if err != nil {
+		return nil, status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("connect: failed to read request: %v", err))
+	}
+	if ss.compressed {
+		return gzipDecompress(data, maxSize)
+	}
+	if len(data) > maxSize {
+		return nil, status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("connect: received message larger than max (%d)", maxSize))
+	}
+	return data, nil
+}
+
+func (ss *serverStream) recvEnvelope() ([]byte, error) {
+	maxSize := ss.svr.opts.MaxReceiveMessageSize
+	flags, data, err := readEnvelope(ss.r.Body, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagEnvelopeEndStream != 0 {
+		return nil, io.EOF
+	}
+	if flags&flagEnvelopeCompressed != 0 {
+		if !ss.compressed {
+			return nil, status.Errorf(code.Code_INTERNAL, "connect: received compressed message without Connect-Content-Encoding")
+		}
+		return gzipDecompress(data, maxSize)
+	}
+	return data, nil
+}
+
+func (ss *serverStream) Method() string {
+	return ss.method
+}
+
+func (ss *serverStream) Start(isClientStream, isServerStream bool) error {
+	begin := &stats.RPCBeginBase{
+		BeginTime:    time.Now(),
+		ClientStream: isClientStream,
+		ServerStream: isServerStream,
+		Protocol:     scheme,
+	}
+	ss.svr.statsHandler.HandleRPC(ss.ctx, begin)
+	ss.beginTime = begin.BeginTime
+	ss.isClientStream = isClientStream
+	ss.isServerStream = isServerStream
+	if !ss.streaming && (isClientStream || isServerStream) {
+		return status.Errorf(code.Code_UNIMPLEMENTED, fmt.Sprintf("connect: streaming rpc requires %s content type", contentTypeStreamPrefix+ss.codec.Name()))
+	}
+	return nil
+}
+
+func (ss *serverStream) Finish(reply any, err error) {
+	if !ss.beginTime.IsZero() {
+		defer func() {
+			ss.svr.statsHandler.HandleRPC(ss.ctx, &stats.RPCEndBase{
+				BeginTime: ss.beginTime,
+				EndTime:   time.Now(),
+				Err:       err,
+				Protocol:  scheme,
+			})
+		}()
+	}
+	if err == nil && !ss.isClientStream && !ss.isServerStream {
+		err = ss.SendMsg(reply)
+	}
+	var st *status.Status
+	if err != nil {
+		var ok bool
+		if st, ok = status.CoverError(err); !ok {
+			st = status.FromContextError(err)
+		}
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.streaming {
+		ss.writeEndStream(st)
+		return
+	}
+	if st == nil {
+		return
+	}
+	if ss.wroteHeader {
+		remotelg.Logger.WarnField("connect: unary response has been sent, drop the error",
+			logger.String("method", ss.method), logger.Err(err))
+		return
+	}
+	ss.wroteHeader = true
+	header := ss.w.Header()
+	setMetadataHeader(header, "", ss.header)
+	setMetadataHeader(header, headerTrailerPrefix, ss.trailer)
+	header.Set(headerContentType, contentTypeJSON)
+	ss.w.WriteHeader(codeToHttpStatus(code.Code(st.Code())))
+	_, _ = ss.w.Write(marshalError(st))
+}
+
+// writeEndStream must be called with ss.mu held.
+func (ss *serverStream) writeEndStream(st *status.Status) {
+	ss.writeStreamHeader()
+	end := endStreamMessage{}
+	if st != nil {
+		end.Error = newWireError(st)
+	}
+	if ss.trailer.Len() > 0 {
+		end.Metadata = map[string][]string{}
+		for k, v := range ss.trailer {
+			end.Metadata[k] = v
+		}
+	}
+	data, err := json.Marshal(&end)
+	if err != nil {
+		data = []byte(`{"error":{"code":"internal","message":"failed to marshal end stream message"}}`)
+	}
+	_ = writeEnvelope(ss.w, flagEnvelopeEndStream, data)
+	ss.flush()
+}
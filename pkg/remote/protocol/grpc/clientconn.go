@@ -21,7 +21,9 @@ import (
 	"io"
 	"math"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/imkuqin-zw/yggdrasil/internal/backoff"
@@ -29,6 +31,7 @@ import (
 	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
 	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote/credentials"
 	remotelg "github.com/imkuqin-zw/yggdrasil/pkg/remote/logger"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/consts"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding"
@@ -46,6 +49,7 @@ const (
 	minConnectTimeout                  = 20 * time.Second
 	defaultClientMaxReceiveMessageSize = 1024 * 1024 * 4
 	defaultClientMaxSendMessageSize    = math.MaxInt32
+	defaultPoolScaleRatio              = 0.8
 	// http2IOBufSize specifies the buffer size for sending frames.
 	defaultWriteBufSize = 32 * 1024
 	defaultReadBufSize  = 32 * 1024
@@ -71,6 +75,23 @@ type Config struct {
 	BackOffMaxDelay   time.Duration `default:"5s"`
 	MinConnectTimeout time.Duration `default:"1s"`
 	Network           string        `default:"tcp"`
+	CredsProto        string
+	// CodeProto is the name of the codec used to encode messages, the proto codec
+	// is used if it is empty. The codec must be registered.
+	CodeProto string
+	// CompressMinSize is the minimum size in bytes of the message to be compressed,
+	// the smaller messages are sent uncompressed.
+	CompressMinSize int
+	// PoolSize is the number of the connections created to each endpoint.
+	PoolSize int `default:"1"`
+	// MaxPoolSize is the max number of the connections to each endpoint, a new
+	// connection is created when the active streams of all the connections reach
+	// PoolScaleRatio of the max concurrent streams allowed by the server. It is
+	// PoolSize if less than that.
+	MaxPoolSize int
+	// PoolScaleRatio is the ratio of the active streams to the max concurrent
+	// streams at which the connection is considered busy, it must be in (0, 1].
+	PoolScaleRatio float64 `default:"0.8"`
 
 	DisableRecvBufferPool bool
 
@@ -88,9 +109,21 @@ func (cfg *Config) setDefault() {
 		cfg.Transport.WriteBufferSize = defaultWriteBufSize
 		cfg.Transport.ReadBufferSize = defaultReadBufSize
 	}
+	if cfg.PoolSize < 1 {
+		cfg.PoolSize = 1
+	}
+	if cfg.MaxPoolSize < cfg.PoolSize {
+		cfg.MaxPoolSize = cfg.PoolSize
+	}
+	if cfg.PoolScaleRatio <= 0 || cfg.PoolScaleRatio > 1 {
+		cfg.PoolScaleRatio = defaultPoolScaleRatio
+	}
 }
 
 type clientConn struct {
+	// streams is the number of the streams created on the connection and not
+	// finished yet. It is the first field to be 64-bit aligned for atomic access.
+	streams     int64
 	ctx         context.Context
 	cancel      context.CancelFunc
 	mu          sync.RWMutex
@@ -117,17 +150,53 @@ func newClient(ctx context.Context, serviceName string, endpoint resolver.Endpoi
 	}
 	cfg.setDefault()
 	cfg.Transport.Authority = serviceName
-	addr, err := transport.NewNetAddr(cfg.Network, endpoint.GetAddress())
+	addr, err := newNetAddr(cfg, endpoint.GetAddress())
 	if err != nil {
 		remotelg.Logger.ErrorField("fault to new client", logger.Err(err))
 		return nil
 	}
+	if cfg.CredsProto != "" {
+		builder := credentials.GetBuilder(cfg.CredsProto)
+		if builder == nil {
+			remotelg.Logger.ErrorField("not found credentials builder", logger.String("creds", cfg.CredsProto))
+			return nil
+		}
+		cfg.Transport.TransportCredentials = builder(serviceName, true)
+	}
+	if cfg.CodeProto != "" && encoding.GetCodec(cfg.CodeProto) == nil {
+		remotelg.Logger.ErrorField("not found codec", logger.String("codec", cfg.CodeProto))
+		return nil
+	}
 	cfg.Transport.StatsHandler = statsHandler
 	if cfg.DisableRecvBufferPool {
 		cfg.recvBufferPool = nopBufferPool{}
 	} else {
 		cfg.recvBufferPool = getShareBufferPool()
 	}
+	if cfg.MaxPoolSize > 1 {
+		return newConnPool(ctx, serviceName, endpoint, addr, cfg, statsHandler)
+	}
+	return newClientConn(ctx, serviceName, endpoint, addr, cfg, statsHandler)
+}
+
+// newNetAddr resolves the address of the endpoint. The host is left to the proxy
+// to resolve if the connection is dialed through a proxy.
+func newNetAddr(cfg *Config, address string) (net.Addr, error) {
+	if cfg.Transport.ProxyURL != "" {
+		if _, err := transport.ParseProxyURL(cfg.Transport.ProxyURL); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Transport.Dialer == nil && (cfg.Transport.UseProxy || cfg.Transport.ProxyURL != "") {
+		if network, _ := transport.ParseDialTarget(address); network == "tcp" && cfg.Network == "tcp" {
+			return transport.UnresolvedAddr(address), nil
+		}
+	}
+	return transport.NewNetAddr(cfg.Network, address)
+}
+
+func newClientConn(ctx context.Context, serviceName string, endpoint resolver.Endpoint, addr net.Addr,
+	cfg *Config, statsHandler stats.Handler) *clientConn {
 	cc := &clientConn{
 		cfg:          cfg,
 		endpoint:     endpoint,
@@ -320,9 +389,16 @@ func (cc *clientConn) NewStream(ctx context.Context, desc *stream.StreamDesc, me
 			return nil, status.Errorf(code.Code_UNAVAILABLE, "transport unavailable")
 		}
 	}
+	atomic.AddInt64(&cc.streams, 1)
+	defer func() {
+		if err != nil {
+			atomic.AddInt64(&cc.streams, -1)
+		}
+	}()
 	c := defaultCallInfo()
 	c.maxSendMessageSize = &cc.cfg.MaxSendMsgSize
 	c.maxReceiveMessageSize = &cc.cfg.MaxRecvMsgSize
+	c.contentSubtype = strings.ToLower(cc.cfg.CodeProto)
 	if err = setCallInfoCodec(c); err != nil {
 		return nil, err
 	}
@@ -347,15 +423,17 @@ func (cc *clientConn) NewStream(ctx context.Context, desc *stream.StreamDesc, me
 		return nil, err
 	}
 	st := &clientStream{
-		s:            s,
-		callInfo:     c,
-		t:            t,
-		desc:         desc,
-		codec:        c.codec,
-		comp:         comp,
-		p:            &parser{r: s, recvBufferPool: cc.cfg.recvBufferPool},
-		beginTime:    begin.BeginTime,
-		statsHandler: cc.statsHandler,
+		s:               s,
+		callInfo:        c,
+		t:               t,
+		desc:            desc,
+		codec:           c.codec,
+		comp:            comp,
+		compressMinSize: cc.cfg.CompressMinSize,
+		p:               &parser{r: s, recvBufferPool: cc.cfg.recvBufferPool},
+		beginTime:       begin.BeginTime,
+		statsHandler:    cc.statsHandler,
+		onFinish:        func() { atomic.AddInt64(&cc.streams, -1) },
 	}
 	st.ctx, st.cancel = context.WithCancel(ctx)
 	if desc.ClientStreams || desc.ServerStreams {
@@ -374,6 +452,30 @@ func (cc *clientConn) NewStream(ctx context.Context, desc *stream.StreamDesc, me
 	return st, nil
 }
 
+// closed reports whether the connection is closed by Close.
+func (cc *clientConn) closed() bool {
+	return cc.closeEvent.HasFired()
+}
+
+// broken reports whether the connection is lost and not reconnecting.
+func (cc *clientConn) broken() bool {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return cc.state == connStateClosed && !cc.closeEvent.HasFired()
+}
+
+// load returns the number of the active streams and the max concurrent streams
+// allowed by the server, ready is false if the connection is not established.
+func (cc *clientConn) load() (streams int64, maxStreams uint32, ready bool) {
+	cc.mu.RLock()
+	t := cc.transport
+	cc.mu.RUnlock()
+	if t == nil {
+		return 0, 0, false
+	}
+	return atomic.LoadInt64(&cc.streams), t.MaxConcurrentStreams(), true
+}
+
 func (cc *clientConn) Close() error {
 	if !cc.closeEvent.Fire() {
 		return errors.New("remote client closed")
@@ -403,22 +505,24 @@ func (cc *clientConn) Scheme() string {
 }
 
 type clientStream struct {
-	ctx          context.Context
-	cancel       context.CancelFunc
-	s            *transport.Stream
-	t            transport.ClientTransport
-	callInfo     *callInfo
-	sentLast     bool
-	desc         *stream.StreamDesc
-	codec        encoding.Codec
-	comp         encoding.Compressor
-	decompSet    bool
-	decomp       encoding.Compressor
-	p            *parser
-	mu           sync.Mutex
-	finished     bool
-	beginTime    time.Time
-	statsHandler stats.Handler
+	ctx             context.Context
+	cancel          context.CancelFunc
+	s               *transport.Stream
+	t               transport.ClientTransport
+	callInfo        *callInfo
+	sentLast        bool
+	desc            *stream.StreamDesc
+	codec           encoding.Codec
+	comp            encoding.Compressor
+	compressMinSize int
+	decompSet       bool
+	decomp          encoding.Compressor
+	p               *parser
+	mu              sync.Mutex
+	finished        bool
+	beginTime       time.Time
+	statsHandler    stats.Handler
+	onFinish        func()
 }
 
 func (as *clientStream) Header() (metadata.MD, error) {
@@ -471,17 +575,18 @@ func (as *clientStream) SendMsg(m interface{}) (err error) {
 	}
 
 	// load hdr, payload, data
-	hdr, payld, data, err := prepareMsg(m, as.codec, as.comp)
+	hdr, payld, data, em, err := prepareMsg(m, as.codec, as.comp, as.compressMinSize)
 	if err != nil {
 		return err
 	}
+	defer em.release()
 
 	// TODO(dfawley): should we be checking len(data) instead?
 	if len(payld) > *as.callInfo.maxSendMessageSize {
 		return status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("trying to send message larger than max (%d vs. %d)", len(payld), *as.callInfo.maxSendMessageSize))
 	}
 
-	if err := as.t.Write(as.s, hdr, payld, &transport.Options{Last: !as.desc.ClientStreams}); err != nil {
+	if err := as.t.Write(as.s, hdr, payld, &transport.Options{Last: !as.desc.ClientStreams, OnWritten: em.retainForWrite()}); err != nil {
 		if !as.desc.ClientStreams {
 			// For non-client-streaming RPCs, we return nil instead of EOF on reason
 			// because the generated code requires it.  finish is not called; RecvMsg()
@@ -580,26 +685,35 @@ func (as *clientStream) finish(err error) {
 	}
 	as.statsHandler.HandleRPC(as.ctx, end)
 	as.cancel()
+	if as.onFinish != nil {
+		as.onFinish()
+	}
 	as.mu.Unlock()
 }
 
 // prepareMsg returns the hdr, payload and data
 // using the compressors passed or using the
-// passed preparedmsg
-func prepareMsg(m interface{}, codec encoding.Codec, comp encoding.Compressor) (hdr, payload, data []byte, err error) {
+// passed preparedmsg. The encodedMsg is non-nil
+// if data is in a pooled buffer, and it must be
+// released once data is not used.
+func prepareMsg(m interface{}, codec encoding.Codec, comp encoding.Compressor, compressMinSize int) (hdr, payload, data []byte, em *encodedMsg, err error) {
 	if preparedMsg, ok := m.(*PreparedMsg); ok {
-		return preparedMsg.hdr, preparedMsg.payload, preparedMsg.encodedData, nil
+		return preparedMsg.hdr, preparedMsg.payload, preparedMsg.encodedData, nil, nil
 	}
 	// The input interface is not a prepared msg.
 	// Marshal and Compress the data at this point
-	data, err = encode(codec, m)
+	data, em, err = encodeBuffer(codec, m)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
-	compData, err := compress(data, comp)
+	compData, err := compress(data, comp, compressMinSize)
 	if err != nil {
-		return nil, nil, nil, err
+		em.release()
+		return nil, nil, nil, nil, err
+	}
+	if em != nil && compData == nil {
+		em.payload = true
 	}
 	hdr, payload = msgHeader(data, compData)
-	return hdr, payload, data, nil
+	return hdr, payload, data, em, nil
 }
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2022 The imkuqin-zw Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package deflate implements and registers the deflate compressor
+// during the initialization. The data is in the zlib format (RFC 1950),
+// which is the "deflate" content-coding of HTTP.
+package deflate
+
+import (
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding"
+)
+
+// Name is the name registered for the deflate compressor.
+const Name = "deflate"
+
+func init() {
+	c := &compressor{}
+	c.poolCompressor.New = func() interface{} {
+		return &writer{Writer: zlib.NewWriter(io.Discard), pool: &c.poolCompressor}
+	}
+	encoding.RegisterCompressor(c)
+}
+
+type writer struct {
+	*zlib.Writer
+	pool *sync.Pool
+}
+
+// SetLevel updates the registered deflate compressor to use the compression level specified (zlib.HuffmanOnly is not supported).
+// NOTE: this function must only be called during initialization time (i.e. in an init() function),
+// and is not thread-safe.
+func SetLevel(level int) error {
+	if level < zlib.DefaultCompression || level > zlib.BestCompression {
+		return fmt.Errorf("grpc: invalid deflate compression level: %d", level)
+	}
+	c := encoding.GetCompressor(Name).(*compressor)
+	c.poolCompressor.New = func() interface{} {
+		w, err := zlib.NewWriterLevel(io.Discard, level)
+		if err != nil {
+			panic(err)
+		}
+		return &writer{Writer: w, pool: &c.poolCompressor}
+	}
+	return nil
+}
+
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	z := c.poolCompressor.Get().(*writer)
+	z.Writer.Reset(w)
+	return z, nil
+}
+
+func (z *writer) Close() error {
+	defer z.pool.Put(z)
+	return z.Writer.Close()
+}
+
+type reader struct {
+	io.ReadCloser
+	pool *sync.Pool
+}
+
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	z, inPool := c.poolDecompressor.Get().(*reader)
+	if !inPool {
+		newZ, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &reader{ReadCloser: newZ, pool: &c.poolDecompressor}, nil
+	}
+	if err := z.ReadCloser.(zlib.Resetter).Reset(r, nil); err != nil {
+		c.poolDecompressor.Put(z)
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *reader) Read(p []byte) (n int, err error) {
+	n, err = z.ReadCloser.Read(p)
+	if err == io.EOF {
+		z.pool.Put(z)
+	}
+	return n, err
+}
+
+func (c *compressor) Name() string {
+	return Name
+}
+
+type compressor struct {
+	poolCompressor   sync.Pool
+	poolDecompressor sync.Pool
+}
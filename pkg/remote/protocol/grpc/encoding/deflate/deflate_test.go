@@ -0,0 +1,49 @@
+/*
+ * Copyright 2022 The imkuqin-zw Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deflate
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressor(t *testing.T) {
+	c := encoding.GetCompressor(Name)
+	require.NotNil(t, c)
+	for _, data := range [][]byte{[]byte("hello"), bytes.Repeat([]byte("yggdrasil"), 1024)} {
+		// Run twice to cover the pooled writers and readers.
+		for i := 0; i < 2; i++ {
+			buf := &bytes.Buffer{}
+			w, err := c.Compress(buf)
+			require.NoError(t, err)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			r, err := c.Decompress(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		}
+	}
+}
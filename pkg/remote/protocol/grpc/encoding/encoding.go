@@ -28,6 +28,8 @@ package encoding
 import (
 	"io"
 	"strings"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/transport/grpcutil"
 )
 
 // Identity specifies the optional encoding for uncompressed streams.
@@ -73,6 +75,9 @@ var registeredCompressor = make(map[string]Compressor)
 // registered with the same name, the one registered last will take effect.
 func RegisterCompressor(c Compressor) {
 	registeredCompressor[c.Name()] = c
+	if !grpcutil.IsCompressorNameRegistered(c.Name()) {
+		grpcutil.RegisteredCompressorNames = append(grpcutil.RegisteredCompressorNames, c.Name())
+	}
 }
 
 // GetCompressor returns Compressor for the given compressor name.
@@ -94,6 +99,19 @@ type Codec interface {
 	Name() string
 }
 
+// BufferCodec is a Codec marshaling the messages into the reusable buffers. The
+// buffer is returned to the codec after the frame carrying it is written, instead
+// of allocating a new slice per message. The buffer not returned is collected by
+// the garbage collector as usual.
+type BufferCodec interface {
+	Codec
+	// MarshalBuffer returns the wire format of v in a buffer taken from the pool.
+	MarshalBuffer(v interface{}) (*[]byte, error)
+	// ReleaseBuffer returns the buffer got from MarshalBuffer to the pool, the
+	// buffer must not be used after that.
+	ReleaseBuffer(buf *[]byte)
+}
+
 var registeredCodecs = make(map[string]Codec)
 
 // RegisterCodec registers the provided Codec for use with all gRPC clients and
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2022 The imkuqin-zw Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package json defines the protojson codec. Importing this package will
+// register the codec, then the requests with content-type
+// "application/grpc+json" can be handled.
+//
+// The codec shares the options of the rest jsonpb marshaler, which are
+// configured under "yggdrasil.rest.marshaler.config.jsonpb".
+package json
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding"
+	"github.com/imkuqin-zw/yggdrasil/pkg/rest/marshaler"
+	"google.golang.org/protobuf/proto"
+)
+
+// Name is the name registered for the json codec.
+const Name = "json"
+
+func init() {
+	encoding.RegisterCodec(&codec{})
+}
+
+// codec is a Codec implementation with protojson.
+type codec struct {
+	once sync.Once
+	pb   *marshaler.JSONPb
+}
+
+// jsonPb lazily builds the marshaler, since the config is not ready yet
+// while the package is initializing.
+func (c *codec) jsonPb() *marshaler.JSONPb {
+	c.once.Do(func() {
+		c.pb = marshaler.NewJSONPb(marshaler.LoadJSONPbConfig())
+	})
+	return c.pb
+}
+
+func (c *codec) Marshal(v interface{}) ([]byte, error) {
+	vv, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+	return c.jsonPb().MarshalOptions.Marshal(vv)
+}
+
+func (c *codec) Unmarshal(data []byte, v interface{}) error {
+	vv, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
+	}
+	return c.jsonPb().UnmarshalOptions.Unmarshal(data, vv)
+}
+
+func (*codec) Name() string {
+	return Name
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright 2022 The imkuqin-zw Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package json
+
+import (
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	c := encoding.GetCodec(Name)
+	require.NotNil(t, c)
+	assert.Equal(t, Name, c.Name())
+
+	in := wrapperspb.String("hello")
+	data, err := c.Marshal(in)
+	require.NoError(t, err)
+	assert.JSONEq(t, `"hello"`, string(data))
+
+	out := &wrapperspb.StringValue{}
+	require.NoError(t, c.Unmarshal(data, out))
+	assert.True(t, proto.Equal(in, out))
+
+	st, err := structpb.NewStruct(map[string]interface{}{"a": 1.0})
+	require.NoError(t, err)
+	data, err = c.Marshal(st)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":1}`, string(data))
+}
+
+func TestCodec_NotProtoMessage(t *testing.T) {
+	c := encoding.GetCodec(Name)
+	_, err := c.Marshal(struct{}{})
+	assert.Error(t, err)
+	assert.Error(t, c.Unmarshal([]byte("{}"), &struct{}{}))
+}
@@ -0,0 +1,75 @@
+/*
+ *
+ * Copyright 2022 The imkuqin-zw Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package proto
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	// minPoolClass is the smallest buffer pooled, 256 B.
+	minPoolClass = 8
+	// maxPoolClass is the largest buffer pooled, 1 MB. The larger buffers are
+	// allocated and collected by the garbage collector.
+	maxPoolClass = 20
+)
+
+// bufferPools holds the buffers by the power of two capacity.
+var bufferPools [maxPoolClass - minPoolClass + 1]sync.Pool
+
+// poolClass returns the power of two class of the buffer with size bytes.
+func poolClass(size int) int {
+	if size <= 1<<minPoolClass {
+		return minPoolClass
+	}
+	return bits.Len(uint(size - 1))
+}
+
+// getBuffer returns a buffer with the length size, the content of the buffer is
+// not zeroed.
+func getBuffer(size int) *[]byte {
+	class := poolClass(size)
+	if class > maxPoolClass {
+		buf := make([]byte, size)
+		return &buf
+	}
+	if v := bufferPools[class-minPoolClass].Get(); v != nil {
+		buf := v.(*[]byte)
+		*buf = (*buf)[:size]
+		return buf
+	}
+	buf := make([]byte, size, 1<<class)
+	return &buf
+}
+
+// putBuffer returns the buffer to the pool, the buffer not got from getBuffer is
+// dropped.
+func putBuffer(buf *[]byte) {
+	c := cap(*buf)
+	if c == 0 || c&(c-1) != 0 {
+		return
+	}
+	class := bits.Len(uint(c)) - 1
+	if class < minPoolClass || class > maxPoolClass {
+		return
+	}
+	*buf = (*buf)[:0]
+	bufferPools[class-minPoolClass].Put(buf)
+}
@@ -35,10 +35,26 @@ func init() {
 	encoding.RegisterCodec(codec{})
 }
 
+// vtprotoMessage is the message generated by vtprotobuf, the generated fast
+// path is used instead of the reflection based one.
+type vtprotoMessage interface {
+	SizeVT() int
+	MarshalVT() ([]byte, error)
+	MarshalToSizedBufferVT(data []byte) (int, error)
+	UnmarshalVT(data []byte) error
+}
+
 // codec is a Codec implementation with protobuf. It is the default codec for gRPC.
+// It marshals the messages into the pooled buffers when it is used as a
+// BufferCodec.
 type codec struct{}
 
+var _ encoding.BufferCodec = codec{}
+
 func (codec) Marshal(v interface{}) ([]byte, error) {
+	if vv, ok := v.(vtprotoMessage); ok {
+		return vv.MarshalVT()
+	}
 	vv, ok := v.(proto.Message)
 	if !ok {
 		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
@@ -46,11 +62,48 @@ func (codec) Marshal(v interface{}) ([]byte, error) {
 	return proto.Marshal(vv)
 }
 
+func (codec) MarshalBuffer(v interface{}) (*[]byte, error) {
+	if vv, ok := v.(vtprotoMessage); ok {
+		size := vv.SizeVT()
+		buf := getBuffer(size)
+		n, err := vv.MarshalToSizedBufferVT(*buf)
+		if err != nil {
+			putBuffer(buf)
+			return nil, err
+		}
+		*buf = (*buf)[size-n:]
+		return buf, nil
+	}
+	vv, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+	size := proto.Size(vv)
+	buf := getBuffer(size)
+	// The size is cached by proto.Size above.
+	data, err := proto.MarshalOptions{UseCachedSize: true}.MarshalAppend((*buf)[:0], vv)
+	if err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+	*buf = data
+	return buf, nil
+}
+
+func (codec) ReleaseBuffer(buf *[]byte) {
+	putBuffer(buf)
+}
+
 func (codec) Unmarshal(data []byte, v interface{}) error {
 	vv, ok := v.(proto.Message)
 	if !ok {
 		return fmt.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
 	}
+	if vt, ok := v.(vtprotoMessage); ok {
+		// UnmarshalVT merges into the message like proto.Merge.
+		proto.Reset(vv)
+		return vt.UnmarshalVT(data)
+	}
 	return proto.Unmarshal(data, vv)
 }
 
@@ -97,3 +97,31 @@ func fastMarshalAndUnmarshal(codec encoding.Codec, protoStruct proto.Message, b
 		b.Errorf("codec.Unmarshal(_) returned an reason")
 	}
 }
+
+// BenchmarkProtoCodecMarshalBuffer compares the allocations of Marshal and
+// MarshalBuffer, the buffer is released like it is after the frame is written.
+// Example run: go test -run=^$ -bench=BenchmarkProtoCodecMarshalBuffer
+func BenchmarkProtoCodecMarshalBuffer(b *testing.B) {
+	for _, s := range []uint32{1 << 4, 1 << 10, 1 << 16} {
+		protoStructs := setupBenchmarkProtoCodecInputs(s)
+		c := codec{}
+		b.Run(fmt.Sprintf("Marshal/MinPayloadSize:%v", s), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Marshal(protoStructs[i%len(protoStructs)]); err != nil {
+					b.Fatalf("codec.Marshal(_) returned an reason: %v", err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("MarshalBuffer/MinPayloadSize:%v", s), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf, err := c.MarshalBuffer(protoStructs[i%len(protoStructs)])
+				if err != nil {
+					b.Fatalf("codec.MarshalBuffer(_) returned an reason: %v", err)
+				}
+				c.ReleaseBuffer(buf)
+			}
+		})
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding/proto/codec_perf"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/transport/grpctest"
+	"google.golang.org/protobuf/proto"
 )
 
 func marshalAndUnmarshal(t *testing.T, codec encoding.Codec, expectedBody []byte) {
@@ -136,3 +137,94 @@ func (s) TestStaggeredMarshalAndUnmarshalUsingSamePool(t *testing.T) {
 		}
 	}
 }
+
+func (s) TestMarshalBuffer(t *testing.T) {
+	c := codec{}
+	for _, size := range []int{0, 3, 1 << 10, 2 << 20} {
+		expectedBody := bytes.Repeat([]byte{1}, size)
+		buf, err := c.MarshalBuffer(&codec_perf.Buffer{Body: expectedBody})
+		if err != nil {
+			t.Fatalf("codec.MarshalBuffer(_) returned an reason: %v", err)
+		}
+		marshaled, _ := c.Marshal(&codec_perf.Buffer{Body: expectedBody})
+		if !bytes.Equal(*buf, marshaled) {
+			t.Fatalf("codec.MarshalBuffer(_) = %v, want %v", *buf, marshaled)
+		}
+		c.ReleaseBuffer(buf)
+	}
+	// The released buffer is reused and overwritten.
+	for _, body := range [][]byte{[]byte("one"), []byte("two")} {
+		buf, err := c.MarshalBuffer(&codec_perf.Buffer{Body: body})
+		if err != nil {
+			t.Fatalf("codec.MarshalBuffer(_) returned an reason: %v", err)
+		}
+		p := &codec_perf.Buffer{}
+		if err := c.Unmarshal(*buf, p); err != nil {
+			t.Fatalf("codec.Unmarshal(_) returned an reason: %v", err)
+		}
+		if !bytes.Equal(p.GetBody(), body) {
+			t.Fatalf("Unexpected body; got %v; want %v", p.GetBody(), body)
+		}
+		c.ReleaseBuffer(buf)
+	}
+}
+
+// vtBuffer implements the vtprotobuf generated methods, it counts the calls.
+type vtBuffer struct {
+	*codec_perf.Buffer
+	calls int
+}
+
+func (b *vtBuffer) SizeVT() int {
+	b.calls++
+	return proto.Size(b.Buffer)
+}
+
+func (b *vtBuffer) MarshalVT() ([]byte, error) {
+	b.calls++
+	return proto.Marshal(b.Buffer)
+}
+
+func (b *vtBuffer) MarshalToSizedBufferVT(data []byte) (int, error) {
+	b.calls++
+	out, err := proto.Marshal(b.Buffer)
+	if err != nil {
+		return 0, err
+	}
+	return copy(data[len(data)-len(out):], out), nil
+}
+
+func (b *vtBuffer) UnmarshalVT(data []byte) error {
+	b.calls++
+	return proto.UnmarshalOptions{Merge: true}.Unmarshal(data, b.Buffer)
+}
+
+func (s) TestVTProtoFastPath(t *testing.T) {
+	c := codec{}
+	expectedBody := []byte{1, 2, 3}
+	m := &vtBuffer{Buffer: &codec_perf.Buffer{Body: expectedBody}}
+
+	marshaled, err := c.Marshal(m)
+	if err != nil {
+		t.Fatalf("codec.Marshal(_) returned an reason: %v", err)
+	}
+	buf, err := c.MarshalBuffer(m)
+	if err != nil {
+		t.Fatalf("codec.MarshalBuffer(_) returned an reason: %v", err)
+	}
+	if !bytes.Equal(*buf, marshaled) {
+		t.Fatalf("codec.MarshalBuffer(_) = %v, want %v", *buf, marshaled)
+	}
+	c.ReleaseBuffer(buf)
+
+	got := &vtBuffer{Buffer: &codec_perf.Buffer{Body: []byte{4, 5, 6, 7}}}
+	if err := c.Unmarshal(marshaled, got); err != nil {
+		t.Fatalf("codec.Unmarshal(_) returned an reason: %v", err)
+	}
+	if !bytes.Equal(got.GetBody(), expectedBody) {
+		t.Fatalf("Unexpected body; got %v; want %v", got.GetBody(), expectedBody)
+	}
+	if m.calls != 3 || got.calls != 1 {
+		t.Fatalf("vtproto methods called %d and %d times, want 3 and 1", m.calls, got.calls)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+	remotelg "github.com/imkuqin-zw/yggdrasil/pkg/remote/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/resolver"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"go.uber.org/multierr"
+)
+
+// connPool keeps several connections to one endpoint, the streams are created on
+// the connection with the least active streams. A new connection is created when
+// all the connections are busy, up to MaxPoolSize.
+type connPool struct {
+	ctx          context.Context
+	serviceName  string
+	endpoint     resolver.Endpoint
+	addr         net.Addr
+	cfg          *Config
+	statsHandler stats.Handler
+
+	mu     sync.Mutex
+	conns  []*clientConn
+	closed bool
+}
+
+func newConnPool(ctx context.Context, serviceName string, endpoint resolver.Endpoint, addr net.Addr,
+	cfg *Config, statsHandler stats.Handler) *connPool {
+	p := &connPool{
+		ctx:          ctx,
+		serviceName:  serviceName,
+		endpoint:     endpoint,
+		addr:         addr,
+		cfg:          cfg,
+		statsHandler: statsHandler,
+		conns:        make([]*clientConn, 0, cfg.MaxPoolSize),
+	}
+	for i := 0; i < cfg.PoolSize; i++ {
+		p.conns = append(p.conns, p.newConn())
+	}
+	return p
+}
+
+// newConn creates a connection with its own copy of the config, the keepalive
+// params in the config are changed by the connection on GOAWAY.
+func (p *connPool) newConn() *clientConn {
+	cfg := *p.cfg
+	return newClientConn(p.ctx, p.serviceName, p.endpoint, p.addr, &cfg, p.statsHandler)
+}
+
+func (p *connPool) NewStream(ctx context.Context, desc *stream.StreamDesc, method string) (stream.ClientStream, error) {
+	cc, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+	return cc.NewStream(ctx, desc, method)
+}
+
+// pick returns the ready connection with the least active streams, and scales up
+// the pool if all the connections are ready and busy. A connecting connection is
+// returned if no ready connection has the stream quota left, the stream waits for
+// it to connect. The closed connections and the broken ones scaled up beyond
+// PoolSize are removed from the pool, other broken ones reconnect.
+func (p *connPool) pick() (*clientConn, error) {
+	picked, reconnect, removed, err := p.pickLocked()
+	// The connections are reset and closed out of the lock, they take their own
+	// locks and may block on the transport.
+	for _, cc := range reconnect {
+		cc.resetTransport()
+	}
+	for _, cc := range removed {
+		_ = cc.Close()
+	}
+	return picked, err
+}
+
+func (p *connPool) pickLocked() (picked *clientConn, reconnect, removed []*clientConn, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, nil, nil, errors.New("remote client closed")
+	}
+	var (
+		pickedStreams int64
+		pickedMax     uint32
+		pending       *clientConn
+		busy          = true
+	)
+	conns := p.conns[:0]
+	for _, cc := range p.conns {
+		streams, maxStreams, ready := cc.load()
+		if !ready {
+			if cc.closed() || (cc.broken() && len(conns) >= p.cfg.PoolSize) {
+				removed = append(removed, cc)
+				continue
+			}
+			// the connection is connecting, or it is broken and should reconnect.
+			if cc.broken() {
+				reconnect = append(reconnect, cc)
+			}
+			if pending == nil {
+				pending = cc
+			}
+			busy = false
+			conns = append(conns, cc)
+			continue
+		}
+		conns = append(conns, cc)
+		if float64(streams) < p.cfg.PoolScaleRatio*float64(maxStreams) {
+			busy = false
+		}
+		if picked == nil || streams < pickedStreams {
+			picked, pickedStreams, pickedMax = cc, streams, maxStreams
+		}
+	}
+	for i := len(conns); i < len(p.conns); i++ {
+		p.conns[i] = nil
+	}
+	p.conns = conns
+	if len(removed) > 0 {
+		remotelg.Logger.InfoField("remove connections from connection pool",
+			logger.String("service", p.serviceName),
+			logger.String("endpoint", p.endpoint.GetAddress()),
+			logger.Int("removed", len(removed)),
+			logger.Int("size", len(p.conns)))
+	}
+	if (busy || len(p.conns) == 0) && len(p.conns) < p.cfg.MaxPoolSize {
+		pending = p.newConn()
+		p.conns = append(p.conns, pending)
+		remotelg.Logger.InfoField("scale up connection pool",
+			logger.String("service", p.serviceName),
+			logger.String("endpoint", p.endpoint.GetAddress()),
+			logger.Int("size", len(p.conns)))
+	}
+	if picked == nil || (pickedStreams >= int64(pickedMax) && pending != nil) {
+		picked = pending
+	}
+	return picked, reconnect, removed, nil
+}
+
+func (p *connPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errors.New("remote client closed")
+	}
+	p.closed = true
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+	var err error
+	for _, cc := range conns {
+		err = multierr.Append(err, cc.Close())
+	}
+	return err
+}
+
+func (p *connPool) Scheme() string {
+	return "grpc"
+}
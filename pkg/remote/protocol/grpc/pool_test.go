@@ -0,0 +1,189 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newTestPool creates the connection pool of the service to the address.
+func newTestPool(t *testing.T, serviceName, address string, cfg map[string]interface{}) *connPool {
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyClientProtocolCfg, serviceName, scheme), cfg))
+	cli := newClient(context.Background(), serviceName, endpoint{address: address}, stats.GetClientHandler())
+	require.IsType(t, &connPool{}, cli)
+	t.Cleanup(func() { _ = cli.Close() })
+	return cli.(*connPool)
+}
+
+// waitPoolReady waits all the connections of the pool to be ready and to know
+// the max concurrent streams of the server.
+func waitPoolReady(t *testing.T, p *connPool, maxStreams uint32) {
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, cc := range p.conns {
+			if _, max, ready := cc.load(); !ready || max != maxStreams {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// openBlockStream opens a stream held by the server until the test ends.
+func openBlockStream(t *testing.T, cc *clientConn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cs, err := cc.NewStream(ctx, &stream.StreamDesc{ClientStreams: true, ServerStreams: true}, "/test/Block")
+	require.NoError(t, err)
+	require.NoError(t, cs.SendMsg(wrapperspb.String("block")))
+}
+
+func (p *connPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+func TestConnPool_PickLeastLoaded(t *testing.T) {
+	svr := newTestServer(t, "pool-least", map[string]interface{}{
+		"address":              "127.0.0.1:0",
+		"maxConcurrentStreams": 10,
+	}, testHandle)
+	p := newTestPool(t, "pool-least", svr.Info().Address, map[string]interface{}{
+		"poolSize":    2,
+		"maxPoolSize": 2,
+	})
+	waitPoolReady(t, p, 10)
+
+	first, err := p.pick()
+	require.NoError(t, err)
+	openBlockStream(t, first)
+	second, err := p.pick()
+	require.NoError(t, err)
+	assert.NotSame(t, first, second)
+	openBlockStream(t, second)
+	openBlockStream(t, second)
+
+	picked, err := p.pick()
+	require.NoError(t, err)
+	assert.Same(t, first, picked)
+	assert.Equal(t, 2, p.size())
+}
+
+func TestConnPool_ScaleUp(t *testing.T) {
+	svr := newTestServer(t, "pool-scale", map[string]interface{}{
+		"address":              "127.0.0.1:0",
+		"maxConcurrentStreams": 4,
+	}, testHandle)
+	p := newTestPool(t, "pool-scale", svr.Info().Address, map[string]interface{}{
+		"poolSize":       1,
+		"maxPoolSize":    2,
+		"poolScaleRatio": 0.5,
+	})
+	assert.Equal(t, 0.5, p.cfg.PoolScaleRatio)
+	waitPoolReady(t, p, 4)
+
+	first, err := p.pick()
+	require.NoError(t, err)
+	openBlockStream(t, first)
+	// 1 of 4 streams is under the ratio.
+	_, err = p.pick()
+	require.NoError(t, err)
+	assert.Equal(t, 1, p.size())
+
+	openBlockStream(t, first)
+	// 2 of 4 streams reaches the ratio, the pool is scaled up.
+	picked, err := p.pick()
+	require.NoError(t, err)
+	assert.Same(t, first, picked)
+	assert.Equal(t, 2, p.size())
+	waitPoolReady(t, p, 4)
+
+	picked, err = p.pick()
+	require.NoError(t, err)
+	assert.NotSame(t, first, picked)
+	for i := 0; i < 3; i++ {
+		openBlockStream(t, picked)
+	}
+	// All the connections are busy, but the pool reaches the max size.
+	_, err = p.pick()
+	require.NoError(t, err)
+	assert.Equal(t, 2, p.size())
+}
+
+func TestConnPool_RemoveClosed(t *testing.T) {
+	svr := newTestServer(t, "pool-remove", map[string]interface{}{
+		"address":              "127.0.0.1:0",
+		"maxConcurrentStreams": 2,
+	}, testHandle)
+	p := newTestPool(t, "pool-remove", svr.Info().Address, map[string]interface{}{
+		"poolSize":       1,
+		"maxPoolSize":    3,
+		"poolScaleRatio": 0.5,
+	})
+	waitPoolReady(t, p, 2)
+	first, err := p.pick()
+	require.NoError(t, err)
+	openBlockStream(t, first)
+	_, err = p.pick()
+	require.NoError(t, err)
+	require.Equal(t, 2, p.size())
+	waitPoolReady(t, p, 2)
+
+	p.mu.Lock()
+	scaled := p.conns[1]
+	p.mu.Unlock()
+	require.NoError(t, scaled.Close())
+	picked, err := p.pick()
+	require.NoError(t, err)
+	assert.NotSame(t, scaled, picked)
+	p.mu.Lock()
+	assert.NotContains(t, p.conns, scaled)
+	p.mu.Unlock()
+
+	// The connections are broken when the server stops, the ones scaled up
+	// beyond PoolSize are removed while the others reconnect.
+	_, err = p.pick()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.NoError(t, svr.Stop(ctx))
+	require.Eventually(t, func() bool {
+		_, _ = p.pick()
+		return p.size() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConfig_PoolScaleRatioDefault(t *testing.T) {
+	for _, ratio := range []float64{0, -1, 1.5} {
+		cfg := &Config{PoolScaleRatio: ratio}
+		cfg.setDefault()
+		assert.Equal(t, defaultPoolScaleRatio, cfg.PoolScaleRatio)
+	}
+	cfg := &Config{}
+	require.NoError(t, config.Get("yggdrasil.remote.protocol.{none}").Scan(cfg))
+	assert.Equal(t, defaultPoolScaleRatio, cfg.PoolScaleRatio)
+}
@@ -59,7 +59,7 @@ func (p *PreparedMsg) Encode(s *transport.Stream, msg interface{}) error {
 		return err
 	}
 	p.encodedData = data
-	compData, err := compress(data, rpcInfo.preloaderInfo.comp)
+	compData, err := compress(data, rpcInfo.preloaderInfo.comp, 0)
 	if err != nil {
 		return err
 	}
@@ -29,6 +29,7 @@ import (
 	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/consts"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding"
@@ -262,12 +263,63 @@ func encode(c encoding.Codec, msg interface{}) ([]byte, error) {
 	return b, nil
 }
 
-// compress returns the input bytes compressed by compressor or cp.  If both
-// compressors are nil, returns nil.
-//
-// TODO(dfawley): eliminate cp parameter by wrapping Compressor in an encoding.Compressor.
-func compress(in []byte, compressor encoding.Compressor) ([]byte, error) {
-	if compressor == nil {
+// encodedMsg is the message marshaled into the pooled buffer of a BufferCodec.
+// The buffer is returned to the codec when all the references are released.
+type encodedMsg struct {
+	codec encoding.BufferCodec
+	buf   *[]byte
+	refs  int32
+	// payload indicates the buffer is written to the transport as the payload.
+	payload bool
+}
+
+// retainForWrite takes a reference for the transport if the buffer is written
+// as the payload, and returns the callback releasing it after the frame is
+// written out. It returns nil if the transport does not reference the buffer.
+func (m *encodedMsg) retainForWrite() func() {
+	if m == nil || !m.payload {
+		return nil
+	}
+	atomic.AddInt32(&m.refs, 1)
+	return m.release
+}
+
+// release drops a reference of the buffer, it is safe to call on nil.
+func (m *encodedMsg) release() {
+	if m == nil {
+		return
+	}
+	if atomic.AddInt32(&m.refs, -1) == 0 {
+		m.codec.ReleaseBuffer(m.buf)
+	}
+}
+
+// encodeBuffer is like encode, but marshals msg into a pooled buffer if the
+// codec is a BufferCodec. The returned encodedMsg holds one reference which
+// must be released by the caller once data is not used, it is nil if the
+// buffer is not pooled.
+func encodeBuffer(c encoding.Codec, msg interface{}) ([]byte, *encodedMsg, error) {
+	bc, ok := c.(encoding.BufferCodec)
+	if !ok || msg == nil {
+		data, err := encode(c, msg)
+		return data, nil, err
+	}
+	buf, err := bc.MarshalBuffer(msg)
+	if err != nil {
+		return nil, nil, status.Errorf(code.Code_INTERNAL, fmt.Sprintf("grpc: reason while marshaling: %v", err.Error()))
+	}
+	if uint(len(*buf)) > math.MaxUint32 {
+		bc.ReleaseBuffer(buf)
+		return nil, nil, status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("grpc: message too large (%d bytes)", len(*buf)))
+	}
+	return *buf, &encodedMsg{codec: bc, buf: buf, refs: 1}, nil
+}
+
+// compress returns the input bytes compressed by compressor. If the compressor
+// is nil or the input is smaller than minSize, returns nil, and the message
+// will be sent uncompressed.
+func compress(in []byte, compressor encoding.Compressor, minSize int) ([]byte, error) {
+	if compressor == nil || len(in) < minSize {
 		return nil, nil
 	}
 	wrapErr := func(err error) error {
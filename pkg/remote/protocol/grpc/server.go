@@ -21,15 +21,20 @@ import (
 	"io"
 	"math"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
 	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
 	"github.com/imkuqin-zw/yggdrasil/pkg/metadata/peer"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote/connlimit"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/credentials"
-	"github.com/imkuqin-zw/yggdrasil/pkg/remote/logger"
+	remotelg "github.com/imkuqin-zw/yggdrasil/pkg/remote/logger"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/consts"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding"
 	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding/proto"
@@ -69,20 +74,55 @@ type serverOptions struct {
 	MaxHeaderListSize     *uint32
 	HeaderTableSize       *uint32
 	DisableRecvBufferPool bool
+	// UnixSocketPerm is the permission of the socket file in octal form, e.g. "0660".
+	// It only takes effect when the server listens on a unix domain socket.
+	UnixSocketPerm string
+	// Compressor is the preferred compressor of the responses, it is used when the
+	// client advertises it in grpc-accept-encoding header. If it is not set, the
+	// first registered compressor advertised by the client is used.
+	Compressor string
+	// CompressMinSize is the minimum size in bytes of the message to be compressed,
+	// the smaller messages are sent uncompressed.
+	CompressMinSize int
+	// ConnLimit limits the connections accepted by the server.
+	ConnLimit connlimit.Config
+	// NumStreamWorkers is the number of the workers handling the streams, a new
+	// goroutine is spawned for each stream if it is zero.
+	NumStreamWorkers int
+	// StreamQueueSize is the number of the streams waiting for the workers, the
+	// stream is handled in a new goroutine if the queue is full. The stream is
+	// only handed to an idle worker if it is zero.
+	StreamQueueSize int
 
 	Attr map[string]string
 
-	creds credentials.TransportCredentials
-	codec encoding.Codec
+	unixSocketPerm os.FileMode
+	creds          credentials.TransportCredentials
+	codec          encoding.Codec
 
 	recvBufferPool SharedBufferPool
 }
 
 func (opts *serverOptions) SetDefault() error {
 	var err error
+	if network, address := transport2.ParseDialTarget(opts.Address); network == "unix" {
+		opts.Network, opts.Address = network, address
+	}
 	if opts.Network == "" {
 		opts.Network = "tcp"
 	}
+	if opts.Network == "unix" {
+		if opts.Address == "" {
+			return errors.New("unix socket path is required")
+		}
+		if opts.UnixSocketPerm != "" {
+			perm, err := strconv.ParseUint(opts.UnixSocketPerm, 8, 32)
+			if err != nil {
+				return fmt.Errorf("invalid unix socket perm %q: %w", opts.UnixSocketPerm, err)
+			}
+			opts.unixSocketPerm = os.FileMode(perm)
+		}
+	}
 	if opts.Address == "" {
 		opts.Address, err = xnet.Extract(opts.Address)
 		if err != nil {
@@ -108,6 +148,9 @@ func (opts *serverOptions) SetDefault() error {
 	if opts.ConnectionTimeout == 0 {
 		opts.ConnectionTimeout = 120 * time.Second
 	}
+	if opts.NumStreamWorkers < 0 || opts.StreamQueueSize < 0 {
+		return errors.New("the number of the stream workers and the stream queue size must not be negative")
+	}
 	return err
 }
 
@@ -115,6 +158,7 @@ type server struct {
 	ctx       context.Context
 	cancel    context.CancelFunc
 	mu        sync.Mutex
+	name      string
 	address   string
 	lis       net.Listener
 	serve     bool
@@ -129,25 +173,40 @@ type server struct {
 	opts         serverOptions
 	serveWG      sync.WaitGroup
 	handlersWG   sync.WaitGroup
+	streamQueue  chan *streamJob // nil if the stream workers are disabled
 	handle       remote.MethodHandle
 	statsHandler stats.Handler
 }
 
-func newServer(handle remote.MethodHandle) (remote.Server, error) {
+func newServer(name string, handle remote.MethodHandle) (remote.Server, error) {
 	opts := serverOptions{}
-	if err := config.Get(fmt.Sprintf(config.KeyRemoteProto, scheme)).Scan(&opts); err != nil {
+	if err := remote.ServerConfig(scheme, name).Scan(&opts); err != nil {
 		return nil, err
 	}
 	if err := opts.SetDefault(); err != nil {
 		return nil, err
 	}
 	opts.codec = encoding.GetCodec(opts.CodeProto)
+	if opts.codec == nil {
+		return nil, fmt.Errorf("not found codec %s", opts.CodeProto)
+	}
+	if opts.Compressor != "" && opts.Compressor != encoding.Identity && encoding.GetCompressor(opts.Compressor) == nil {
+		return nil, fmt.Errorf("not found compressor %s", opts.Compressor)
+	}
+	if opts.CredsProto != "" {
+		builder := credentials.GetBuilder(opts.CredsProto)
+		if builder == nil {
+			return nil, fmt.Errorf("not found credentials builder, creds: %s", opts.CredsProto)
+		}
+		opts.creds = builder("", false)
+	}
 	if opts.DisableRecvBufferPool {
 		opts.recvBufferPool = nopBufferPool{}
 	} else {
 		opts.recvBufferPool = getShareBufferPool()
 	}
 	s := &server{
+		name:         name,
 		stoppedCh:    make(chan struct{}),
 		conns:        make(map[transport2.ServerTransport]bool),
 		opts:         opts,
@@ -159,35 +218,72 @@ func newServer(handle remote.MethodHandle) (remote.Server, error) {
 	return s, nil
 }
 
+// getCodec returns the codec registered for the content-subtype of the request,
+// and falls back to the configured codec when the content-subtype is empty or unknown.
 func (s *server) getCodec(contentSubtype string) encoding.Codec {
+	if contentSubtype != "" {
+		if codec := encoding.GetCodec(contentSubtype); codec != nil {
+			return codec
+		}
+	}
 	if s.opts.codec != nil {
 		return s.opts.codec
 	}
-	if contentSubtype == "" {
-		return encoding.GetCodec(proto.Name)
+	return encoding.GetCodec(proto.Name)
+}
+
+// sendCompressor returns the name of the compressor used to compress the responses
+// of the stream, empty if the responses should not be compressed.
+func (s *server) sendCompressor(stream *transport2.Stream) string {
+	return selectSendCompressor(s.opts.Compressor, stream.RecvCompress(), stream.ClientAdvertisedCompressors())
+}
+
+// selectSendCompressor selects the compressor of the responses: the configured one
+// if the client advertises it, otherwise the one of the request, otherwise the
+// first compressor advertised by the client that is registered if no compressor
+// is configured.
+func selectSendCompressor(configured, recv string, advertised []string) string {
+	if configured != "" && configured != encoding.Identity {
+		for _, name := range advertised {
+			if name == configured {
+				return configured
+			}
+		}
+	}
+	if recv != "" && recv != encoding.Identity && encoding.GetCompressor(recv) != nil {
+		return recv
 	}
-	codec := encoding.GetCodec(contentSubtype)
-	if codec == nil {
-		return encoding.GetCodec(proto.Name)
+	if configured == "" {
+		for _, name := range advertised {
+			if name != encoding.Identity && encoding.GetCompressor(name) != nil {
+				return name
+			}
+		}
 	}
-	return codec
+	return ""
 }
 
 func (s *server) sendResponse(t transport2.ServerTransport, stream *transport2.Stream, msg interface{}, opts *transport2.Options, comp encoding.Compressor) error {
-	data, err := encode(s.getCodec(stream.ContentSubtype()), msg)
+	data, em, err := encodeBuffer(s.getCodec(stream.ContentSubtype()), msg)
 	if err != nil {
 		return err
 	}
-	compData, err := compress(data, comp)
+	defer em.release()
+	compData, err := compress(data, comp, s.opts.CompressMinSize)
 	if err != nil {
 		return err
 	}
+	if em != nil && compData == nil {
+		em.payload = true
+	}
 	hdr, payload := msgHeader(data, compData)
 	// TODO(dfawley): should we be checking len(data) instead?
 	if len(payload) > s.opts.MaxSendMessageSize {
 		return status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("grpc: trying to send message larger than max (%d vs. %d)", len(payload), s.opts.MaxSendMessageSize))
 	}
-	err = t.Write(stream, hdr, payload, opts)
+	writeOpts := *opts
+	writeOpts.OnWritten = em.retainForWrite()
+	err = t.Write(stream, hdr, payload, &writeOpts)
 	return err
 }
 
@@ -219,7 +315,7 @@ func (s *server) newTransport(c net.Conn) transport2.ServerTransport {
 	return st
 }
 
-func (s *server) Stop() error {
+func (s *server) Stop(ctx context.Context) error {
 	s.mu.Lock()
 	if !s.serve {
 		s.stopped = true
@@ -232,8 +328,8 @@ func (s *server) Stop() error {
 		return nil
 	}
 	s.stopped = true
-	s.mu.Unlock()
-	s.cancel()
+	// Stop accepting new connections, and send GOAWAY to the active connections
+	// so that the clients stop creating new streams on them.
 	if s.lis != nil {
 		_ = s.lis.Close()
 	}
@@ -243,14 +339,47 @@ func (s *server) Stop() error {
 		}
 		s.drain = true
 	}
+	remotelg.Logger.InfoField("grpc server draining",
+		logger.String("address", s.address), logger.Int("conns", len(s.conns)))
+	s.mu.Unlock()
 
-	// Wait for serving threads to be ready to exit.  Only then can we be sure no
-	// new conns will be created.
-	s.serveWG.Wait()
-	s.mu.Lock()
-	for len(s.conns) != 0 {
-		s.cv.Wait()
+	drained := make(chan struct{})
+	go func() {
+		// Wait for serving threads to be ready to exit.  Only then can we be sure no
+		// new conns will be created.
+		s.serveWG.Wait()
+		s.mu.Lock()
+		for len(s.conns) != 0 {
+			s.cv.Wait()
+		}
+		s.mu.Unlock()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		remotelg.Logger.InfoField("grpc server drained", logger.String("address", s.address))
+	case <-ctx.Done():
+		s.mu.Lock()
+		conns := make([]transport2.ServerTransport, 0, len(s.conns))
+		for st := range s.conns {
+			conns = append(conns, st)
+		}
+		s.mu.Unlock()
+		remotelg.Logger.WarnField("grpc server drain timeout, force close the active connections",
+			logger.String("address", s.address), logger.Int("conns", len(conns)))
+		// Cancel the active streams, and close the connections.
+		s.cancel()
+		for _, st := range conns {
+			st.Close()
+		}
+		<-drained
 	}
+	s.cancel()
+	if s.streamQueue != nil {
+		// All the connections are closed, no stream is dispatched any more.
+		close(s.streamQueue)
+	}
+	s.mu.Lock()
 	s.conns = nil
 	close(s.stoppedCh)
 	s.mu.Unlock()
@@ -261,6 +390,7 @@ func (s *server) Info() remote.ServerInfo {
 	return remote.ServerInfo{
 		Address:  s.address,
 		Protocol: scheme,
+		Name:     s.name,
 		Attr:     s.opts.Attr,
 	}
 }
@@ -275,15 +405,124 @@ func (s *server) Start() error {
 		return errors.New("server had already serve")
 	}
 	s.serve = true
-	lis, err := net.Listen(s.opts.Network, s.opts.Address)
+	lis, err := s.listen()
 	if err != nil {
 		return err
 	}
 	s.address = lis.Addr().String()
-	s.lis = lis
+	if s.opts.Network == "unix" {
+		s.address = unixTarget(s.address)
+	}
+	s.lis = connlimit.NewListener(lis, s.opts.ConnLimit, s.rejectConn)
+	if s.opts.NumStreamWorkers > 0 {
+		s.initStreamWorkers()
+	}
 	return nil
 }
 
+// streamJob is a stream waiting in the queue of the stream workers.
+type streamJob struct {
+	t        transport2.ServerTransport
+	stream   *transport2.Stream
+	enqueued time.Time
+}
+
+func (s *server) initStreamWorkers() {
+	s.streamQueue = make(chan *streamJob, s.opts.StreamQueueSize)
+	for i := 0; i < s.opts.NumStreamWorkers; i++ {
+		go s.streamWorker()
+	}
+}
+
+func (s *server) streamWorker() {
+	for job := range s.streamQueue {
+		s.handleStream(job.t, job.stream, time.Since(job.enqueued))
+		s.handlersWG.Done()
+	}
+}
+
+// dispatchStream hands the stream to the stream workers, or handles it in a new
+// goroutine if the workers are disabled or the queue is full.
+func (s *server) dispatchStream(st transport2.ServerTransport, stream *transport2.Stream) {
+	s.handlersWG.Add(1)
+	if s.streamQueue != nil {
+		select {
+		case s.streamQueue <- &streamJob{t: st, stream: stream, enqueued: time.Now()}:
+			return
+		default:
+		}
+	}
+	go func() {
+		defer s.handlersWG.Done()
+		s.handleStream(st, stream, 0)
+	}()
+}
+
+// rejectConn reports the connection rejected by the connection limits.
+func (s *server) rejectConn(conn net.Conn, reason string) {
+	ctx := s.statsHandler.TagChannel(context.Background(), &stats.ChanTagInfoBase{
+		RemoteEndpoint: conn.RemoteAddr().String(),
+		LocalEndpoint:  conn.LocalAddr().String(),
+		Protocol:       consts.Scheme,
+	})
+	s.statsHandler.HandleChannel(ctx, &stats.ChanRejectBase{Reason: reason})
+}
+
+func (s *server) listen() (net.Listener, error) {
+	if s.opts.Network != "unix" {
+		return net.Listen(s.opts.Network, s.opts.Address)
+	}
+	if err := removeStaleSocket(s.opts.Address); err != nil {
+		return nil, err
+	}
+	// The socket file is unlinked by the listener when it is closed.
+	lis, err := net.Listen(s.opts.Network, s.opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.unixSocketPerm != 0 {
+		if err = os.Chmod(s.opts.Address, s.opts.unixSocketPerm); err != nil {
+			_ = lis.Close()
+			return nil, err
+		}
+	}
+	return lis, nil
+}
+
+// removeStaleSocket removes the socket file left behind by a process that did not
+// exit cleanly. A socket that still accepts connections is considered in use.
+func removeStaleSocket(path string) error {
+	if strings.HasPrefix(path, "@") {
+		// abstract socket has no file on the filesystem
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("file %s already exists and is not a unix socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("unix socket %s is already in use", path)
+	}
+	remotelg.Logger.Warnf("remove stale unix socket %s", path)
+	return os.Remove(path)
+}
+
+// unixTarget formats the socket path as a dial target that NewNetAddr understands.
+func unixTarget(path string) string {
+	if filepath.IsAbs(path) {
+		return "unix://" + path
+	}
+	return "unix:" + path
+}
+
 func (s *server) Handle() error {
 	f := func() error {
 		var tempDelay time.Duration
@@ -299,7 +538,7 @@ func (s *server) Handle() error {
 					if max := 1 * time.Second; tempDelay > max {
 						tempDelay = max
 					}
-					logger.Logger.Warnf("Accept reason: %v; retrying in %v", acceptErr, tempDelay)
+					remotelg.Logger.Warnf("Accept reason: %v; retrying in %v", acceptErr, tempDelay)
 					timer := time.NewTimer(tempDelay)
 					select {
 					case <-timer.C:
@@ -315,7 +554,7 @@ func (s *server) Handle() error {
 					return nil
 				}
 				s.mu.Unlock()
-				logger.Logger.Errorf("done serving; Accept = %v", acceptErr)
+				remotelg.Logger.Errorf("done serving; Accept = %v", acceptErr)
 				return acceptErr
 			}
 			tempDelay = 0
@@ -371,15 +610,13 @@ func (s *server) serveStreams(ctx context.Context, st transport2.ServerTransport
 		s.statsHandler.HandleChannel(ctx, &stats.ChanEndBase{})
 	}()
 	st.HandleStreams(ctx, func(stream *transport2.Stream) {
-		s.handlersWG.Add(1)
-		go func() {
-			defer s.handlersWG.Done()
-			s.handleStream(st, stream)
-		}()
+		s.dispatchStream(st, stream)
 	})
 }
 
-func (s *server) handleStream(t transport2.ServerTransport, stream *transport2.Stream) {
+// handleStream handles the stream, queueWait is how long the stream waits in the
+// queue of the stream workers.
+func (s *server) handleStream(t transport2.ServerTransport, stream *transport2.Stream, queueWait time.Duration) {
 	ctx := stream.Context()
 	md, _ := metadata.FromInContext(ctx)
 
@@ -393,6 +630,9 @@ func (s *server) handleStream(t transport2.ServerTransport, stream *transport2.S
 	inHeader.LocalEndpoint = t.Peer().LocalAddr.String()
 	inHeader.Compression = stream.RecvCompress()
 	s.statsHandler.HandleRPC(ctx, inHeader)
+	if s.streamQueue != nil {
+		s.statsHandler.HandleRPC(ctx, &stats.RPCQueueBase{WaitTime: queueWait, Protocol: consts.Scheme})
+	}
 
 	ss := &serverStream{
 		ctx:                   ctx,
@@ -592,16 +832,17 @@ func (ss *serverStream) SetHeader(md metadata.MD) error {
 func (ss *serverStream) SendMsg(m interface{}) error {
 
 	// load hdr, payload, data
-	hdr, payload, data, err := prepareMsg(m, ss.codec, ss.comp)
+	hdr, payload, data, em, err := prepareMsg(m, ss.codec, ss.comp, ss.svr.opts.CompressMinSize)
 	if err != nil {
 		return err
 	}
+	defer em.release()
 
 	// TODO(dfawley): should we be checking len(data) instead?
 	if len(payload) > ss.maxSendMessageSize {
 		return status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("trying to send message larger than max (%d vs. %d)", len(payload), ss.maxSendMessageSize))
 	}
-	if err := ss.t.Write(ss.s, hdr, payload, &transport2.Options{Last: false}); err != nil {
+	if err := ss.t.Write(ss.s, hdr, payload, &transport2.Options{Last: false, OnWritten: em.retainForWrite()}); err != nil {
 		return toRPCErr(err)
 	}
 
@@ -675,16 +916,13 @@ func (ss *serverStream) Start(isClientStream, isServerStream bool) error {
 		}
 	}
 
-	// If cp is set, use it.  Otherwise, attempt to compress the response using
-	// the incoming message compression method.
+	// Use the preferred compressor if the client accepts it. Otherwise, attempt to
+	// compress the response using the incoming message compression method.
 	//
 	// NOTE: this needs to be ahead of all handling, https://github.com/grpc/grpc-go/issues/686.
-	if rc = ss.s.RecvCompress(); rc != "" && rc != encoding.Identity {
-		// Legacy compressor not specified; attempt to respond with same encoding.
-		ss.comp = encoding.GetCompressor(rc)
-		if ss.comp != nil {
-			ss.s.SetSendCompress(rc)
-		}
+	if sc := ss.svr.sendCompressor(ss.s); sc != "" {
+		ss.comp = encoding.GetCompressor(sc)
+		ss.s.SetSendCompress(sc)
 	}
 	return nil
 }
@@ -0,0 +1,428 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	_ "github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding/gzip"
+	_ "github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/encoding/zstd"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote/protocol/grpc/transport/grpctest"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type s struct {
+	grpctest.Tester
+}
+
+func Test(t *testing.T) {
+	grpctest.RunSubTests(t, s{})
+}
+
+type endpoint struct {
+	address string
+}
+
+func (e endpoint) GetAddress() string { return e.address }
+
+func (e endpoint) GetProtocol() string { return scheme }
+
+func (e endpoint) GetMetadata() map[string]interface{} { return nil }
+
+func testHandle(ss remote.ServerStream) {
+	switch ss.Method() {
+	case "/test/Unary":
+		req := &wrapperspb.StringValue{}
+		if err := ss.Start(false, false); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		if err := ss.RecvMsg(req); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		ss.Finish(wrapperspb.String(req.Value), nil)
+	case "/test/Block":
+		_ = ss.Start(false, true)
+		<-ss.Context().Done()
+		ss.Finish(nil, ss.Context().Err())
+	default:
+		ss.Finish(nil, status.Errorf(code.Code_UNIMPLEMENTED, "unknown method"))
+	}
+}
+
+// newTestServer starts the server of the listener name configured by cfg.
+func newTestServer(t *testing.T, name string, cfg map[string]interface{}, handle remote.MethodHandle) *server {
+	svr := buildTestServer(t, name, cfg, handle)
+	startTestServer(t, svr)
+	return svr
+}
+
+// buildTestServer builds the server of the listener name configured by cfg
+// without starting it.
+func buildTestServer(t *testing.T, name string, cfg map[string]interface{}, handle remote.MethodHandle) *server {
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyServerListenerProtoCfg, name), cfg))
+	svr, err := newServer(name, handle)
+	require.NoError(t, err)
+	return svr.(*server)
+}
+
+func startTestServer(t *testing.T, svr *server) {
+	require.NoError(t, svr.Start())
+	go func() { _ = svr.Handle() }()
+	t.Cleanup(func() { _ = svr.Stop(context.Background()) })
+}
+
+func newTestClient(t *testing.T, address string) remote.Client {
+	cli := newClient(context.Background(), "test", endpoint{address: address}, stats.GetClientHandler())
+	require.NotNil(t, cli)
+	t.Cleanup(func() { _ = cli.Close() })
+	return cli
+}
+
+func invokeUnary(cli remote.Client, value string) (string, error) {
+	cs, err := cli.NewStream(context.Background(), &stream.StreamDesc{}, "/test/Unary")
+	if err != nil {
+		return "", err
+	}
+	if err = cs.SendMsg(wrapperspb.String(value)); err != nil {
+		return "", err
+	}
+	reply := &wrapperspb.StringValue{}
+	if err = cs.RecvMsg(reply); err != nil {
+		return "", err
+	}
+	return reply.Value, nil
+}
+
+// shortTempDir returns a directory short enough for the socket path limit.
+func shortTempDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "ygg")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := shortTempDir(t)
+
+	t.Run("not exist", func(t *testing.T) {
+		assert.NoError(t, removeStaleSocket(filepath.Join(dir, "none.sock")))
+	})
+
+	t.Run("abstract", func(t *testing.T) {
+		assert.NoError(t, removeStaleSocket("@abstract"))
+	})
+
+	t.Run("regular file", func(t *testing.T) {
+		path := filepath.Join(dir, "file.sock")
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+		assert.Error(t, removeStaleSocket(path))
+		_, err := os.Stat(path)
+		assert.NoError(t, err)
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		path := filepath.Join(dir, "stale.sock")
+		lis, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		lis.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, lis.Close())
+		require.NoError(t, removeStaleSocket(path))
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("in use", func(t *testing.T) {
+		path := filepath.Join(dir, "live.sock")
+		lis, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer lis.Close()
+		assert.Error(t, removeStaleSocket(path))
+		_, err = os.Stat(path)
+		assert.NoError(t, err)
+	})
+}
+
+func TestServer_UnixSocket(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "grpc.sock")
+	svr := newTestServer(t, "unix", map[string]interface{}{
+		"address":        "unix://" + path,
+		"unixSocketPerm": "0600",
+	}, testHandle)
+	assert.Equal(t, "unix", svr.opts.Network)
+	assert.Equal(t, "unix://"+path, svr.Info().Address)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.NotZero(t, fi.Mode()&os.ModeSocket)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	reply, err := invokeUnary(newTestClient(t, svr.Info().Address), "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", reply)
+
+	require.NoError(t, svr.Stop(context.Background()))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServer_UnixSocketInvalidPerm(t *testing.T) {
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyServerListenerProtoCfg, "badperm"), map[string]interface{}{
+		"address":        "unix:///tmp/badperm.sock",
+		"unixSocketPerm": "0999",
+	}))
+	_, err := newServer("badperm", testHandle)
+	assert.Error(t, err)
+}
+
+func TestServer_DrainGraceful(t *testing.T) {
+	svr := newTestServer(t, "drain-graceful", map[string]interface{}{"address": "127.0.0.1:0"}, testHandle)
+	reply, err := invokeUnary(newTestClient(t, svr.Info().Address), "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", reply)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, svr.Stop(ctx))
+	assert.NoError(t, ctx.Err())
+}
+
+func TestServer_DrainTimeout(t *testing.T) {
+	started := make(chan struct{})
+	var once sync.Once
+	svr := newTestServer(t, "drain-timeout", map[string]interface{}{"address": "127.0.0.1:0"}, func(ss remote.ServerStream) {
+		if ss.Method() != "/test/Block" {
+			testHandle(ss)
+			return
+		}
+		once.Do(func() { close(started) })
+		testHandle(ss)
+	})
+	cli := newTestClient(t, svr.Info().Address)
+	streamCtx, streamCancel := context.WithCancel(context.Background())
+	defer streamCancel()
+	cs, err := cli.NewStream(streamCtx, &stream.StreamDesc{ClientStreams: true, ServerStreams: true}, "/test/Block")
+	require.NoError(t, err)
+	require.NoError(t, cs.SendMsg(wrapperspb.String("block")))
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream is not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	require.NoError(t, svr.Stop(ctx))
+	assert.GreaterOrEqual(t, time.Since(begin), 100*time.Millisecond)
+	assert.Less(t, time.Since(begin), 5*time.Second)
+	assert.Error(t, cs.RecvMsg(&wrapperspb.StringValue{}))
+}
+
+// queueStats records the queue wait of the rpc.
+type queueStats struct {
+	mu     sync.Mutex
+	queues []stats.RPCQueue
+}
+
+func (q *queueStats) TagRPC(ctx context.Context, _ stats.RPCTagInfo) context.Context { return ctx }
+
+func (q *queueStats) HandleRPC(_ context.Context, rs stats.RPCStats) {
+	if rq, ok := rs.(stats.RPCQueue); ok {
+		q.mu.Lock()
+		q.queues = append(q.queues, rq)
+		q.mu.Unlock()
+	}
+}
+
+func (q *queueStats) TagChannel(ctx context.Context, _ stats.ChanTagInfo) context.Context { return ctx }
+
+func (q *queueStats) HandleChannel(context.Context, stats.ChanStats) {}
+
+func (q *queueStats) get() []stats.RPCQueue {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]stats.RPCQueue(nil), q.queues...)
+}
+
+// blockHandle handles /test/Block like testHandle and reports the stream started.
+func blockHandle(started chan<- struct{}) remote.MethodHandle {
+	return func(ss remote.ServerStream) {
+		if ss.Method() == "/test/Block" {
+			started <- struct{}{}
+		}
+		testHandle(ss)
+	}
+}
+
+// openTestBlock opens a /test/Block stream, it is held until cancel is called.
+func openTestBlock(t *testing.T, cli remote.Client, started <-chan struct{}) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cs, err := cli.NewStream(ctx, &stream.StreamDesc{ClientStreams: true, ServerStreams: true}, "/test/Block")
+	require.NoError(t, err)
+	require.NoError(t, cs.SendMsg(wrapperspb.String("block")))
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream is not started")
+	}
+	return cancel
+}
+
+func waitGroupDone(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestServer_StreamWorkersQueueFull(t *testing.T) {
+	started := make(chan struct{}, 1)
+	svr := buildTestServer(t, "workers-full", map[string]interface{}{
+		"address":          "127.0.0.1:0",
+		"numStreamWorkers": 1,
+	}, blockHandle(started))
+	qs := &queueStats{}
+	svr.statsHandler = qs
+	startTestServer(t, svr)
+	cli := newTestClient(t, svr.Info().Address)
+
+	// The only worker is busy, the stream is handled in a new goroutine.
+	openTestBlock(t, cli, started)
+	reply, err := invokeUnary(cli, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", reply)
+	queues := qs.get()
+	require.Len(t, queues, 2)
+	assert.Equal(t, scheme, queues[1].GetProtocol())
+	assert.Zero(t, queues[1].GetWaitTime())
+}
+
+func TestServer_StreamWorkersQueueWait(t *testing.T) {
+	started := make(chan struct{}, 1)
+	svr := buildTestServer(t, "workers-wait", map[string]interface{}{
+		"address":          "127.0.0.1:0",
+		"numStreamWorkers": 1,
+		"streamQueueSize":  1,
+	}, blockHandle(started))
+	qs := &queueStats{}
+	svr.statsHandler = qs
+	startTestServer(t, svr)
+	cli := newTestClient(t, svr.Info().Address)
+
+	cancel := openTestBlock(t, cli, started)
+	replyCh := make(chan error, 1)
+	go func() {
+		_, err := invokeUnary(cli, "queued")
+		replyCh <- err
+	}()
+	// The stream waits in the queue until the worker is released.
+	require.Eventually(t, func() bool { return len(svr.streamQueue) == 1 }, 5*time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-replyCh:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued stream is not handled")
+	}
+	queues := qs.get()
+	require.Len(t, queues, 2)
+	assert.GreaterOrEqual(t, queues[1].GetWaitTime(), 50*time.Millisecond)
+}
+
+func TestServer_StreamWorkersHandlersWG(t *testing.T) {
+	started := make(chan struct{}, 2)
+	svr := newTestServer(t, "workers-wg", map[string]interface{}{
+		"address":          "127.0.0.1:0",
+		"numStreamWorkers": 1,
+	}, blockHandle(started))
+	cli := newTestClient(t, svr.Info().Address)
+
+	// One stream is handled by the worker, the other by a new goroutine.
+	cancel1 := openTestBlock(t, cli, started)
+	cancel2 := openTestBlock(t, cli, started)
+	assert.False(t, waitGroupDone(&svr.handlersWG, 50*time.Millisecond))
+	cancel1()
+	assert.False(t, waitGroupDone(&svr.handlersWG, 50*time.Millisecond))
+	cancel2()
+	assert.True(t, waitGroupDone(&svr.handlersWG, 5*time.Second))
+}
+
+func TestServer_StreamWorkersStop(t *testing.T) {
+	svr := newTestServer(t, "workers-stop", map[string]interface{}{
+		"address":          "127.0.0.1:0",
+		"numStreamWorkers": 2,
+	}, testHandle)
+	_, err := invokeUnary(newTestClient(t, svr.Info().Address), "hello")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, svr.Stop(ctx))
+	select {
+	case _, ok := <-svr.streamQueue:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("the stream queue is not closed")
+	}
+}
+
+func TestSelectSendCompressor(t *testing.T) {
+	cases := []struct {
+		name       string
+		configured string
+		recv       string
+		advertised []string
+		want       string
+	}{
+		{name: "configured advertised", configured: "gzip", recv: "zstd", advertised: []string{"zstd", "gzip"}, want: "gzip"},
+		{name: "configured not advertised", configured: "gzip", recv: "zstd", advertised: []string{"zstd"}, want: "zstd"},
+		{name: "configured not advertised uncompressed", configured: "gzip", advertised: []string{"zstd"}, want: ""},
+		{name: "identity", configured: "identity", advertised: []string{"zstd"}, want: ""},
+		{name: "request compressor", recv: "gzip", advertised: []string{"zstd", "gzip"}, want: "gzip"},
+		{name: "first advertised", advertised: []string{"identity", "unknown", "zstd", "gzip"}, want: "zstd"},
+		{name: "none advertised", want: ""},
+		{name: "unknown advertised", advertised: []string{"unknown"}, want: ""},
+	}
+	for _, item := range cases {
+		t.Run(item.name, func(t *testing.T) {
+			assert.Equal(t, item.want, selectSendCompressor(item.configured, item.recv, item.advertised))
+		})
+	}
+}
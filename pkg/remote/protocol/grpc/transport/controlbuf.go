@@ -152,6 +152,9 @@ type dataFrame struct {
 	// onEachWrite is called every time
 	// a part of d is written out.
 	onEachWrite func()
+	// onWritten is called once all of h and d are written out,
+	// the buffers are not referenced by the transport after that.
+	onWritten func()
 }
 
 func (*dataFrame) isTransportResponseFrame() bool { return false }
@@ -887,6 +890,9 @@ func (l *loopyWriter) processData() (bool, error) {
 			return false, err
 		}
 		str.itl.dequeue() // remove the empty data item from stream
+		if dataItem.onWritten != nil {
+			dataItem.onWritten()
+		}
 		if str.itl.isEmpty() {
 			str.state = empty
 		} else if trailer, ok := str.itl.peek().(*headerFrame); ok { // the next item is trailers.
@@ -955,6 +961,9 @@ func (l *loopyWriter) processData() (bool, error) {
 
 	if len(dataItem.h) == 0 && len(dataItem.d) == 0 { // All the data from that message was written out.
 		str.itl.dequeue()
+		if dataItem.onWritten != nil {
+			dataItem.onWritten()
+		}
 	}
 	if str.itl.isEmpty() {
 		str.state = empty
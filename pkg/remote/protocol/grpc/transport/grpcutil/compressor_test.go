@@ -0,0 +1,41 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpcutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"gzip", []string{"gzip"}},
+		{"gzip,zstd", []string{"gzip", "zstd"}},
+		{" gzip , ,zstd ", []string{"gzip", "zstd"}},
+	}
+	for _, tt := range tests {
+		if got := ParseAcceptEncoding(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseAcceptEncoding(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
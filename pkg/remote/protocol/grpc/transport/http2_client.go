@@ -135,7 +135,7 @@ type http2Client struct {
 	statsHandlers stats.Handler
 }
 
-func dial(ctx context.Context, fn func(context.Context, string) (net.Conn, error), addr net.Addr, useProxy bool, grpcUA string) (net.Conn, error) {
+func dial(ctx context.Context, fn func(context.Context, string) (net.Conn, error), addr net.Addr, useProxy bool, rawProxyURL string, grpcUA string) (net.Conn, error) {
 	address := addr.String()
 	networkType := addr.Network()
 	if fn != nil {
@@ -156,8 +156,15 @@ func dial(ctx context.Context, fn func(context.Context, string) (net.Conn, error
 		}
 		return fn(ctx, address)
 	}
+	if networkType == "tcp" && rawProxyURL != "" {
+		proxyURL, err := ParseProxyURL(rawProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		return proxyDial(ctx, address, proxyURL, grpcUA)
+	}
 	if networkType == "tcp" && useProxy {
-		return proxyDial(ctx, address, grpcUA)
+		return proxyDial(ctx, address, nil, grpcUA)
 	}
 	return (&net.Dialer{}).DialContext(ctx, addr.Network(), addr.String())
 }
@@ -189,7 +196,7 @@ func newHTTP2Client(connectCtx, ctx context.Context, addr net.Addr, opts Connect
 			cancel()
 		}
 	}()
-	conn, err := dial(connectCtx, opts.Dialer, addr, opts.UseProxy, opts.UserAgent)
+	conn, err := dial(connectCtx, opts.Dialer, addr, opts.UseProxy, opts.ProxyURL, opts.UserAgent)
 	if err != nil {
 		if opts.FailOnNonTempDialError {
 			return nil, connectionErrorf(isTemporary(err), err, "transport: reason while dialing: %v", err)
@@ -440,9 +447,20 @@ func (t *http2Client) createHeaderFields(ctx context.Context, callHdr *CallHdr)
 		headerFields = append(headerFields, hpack.HeaderField{Name: "grpc-previous-rpc-attempts", Value: strconv.Itoa(callHdr.PreviousAttempts)})
 	}
 
+	registeredCompressors := grpcutil2.RegisteredCompressors()
 	if callHdr.SendCompress != "" {
 		headerFields = append(headerFields, hpack.HeaderField{Name: "grpc-encoding", Value: callHdr.SendCompress})
-		headerFields = append(headerFields, hpack.HeaderField{Name: "grpc-accept-encoding", Value: callHdr.SendCompress})
+		// Include the outgoing compressor name when compressor is not registered
+		// via encoding.RegisterCompressor, e.g. identity.
+		if !grpcutil2.IsCompressorNameRegistered(callHdr.SendCompress) {
+			if registeredCompressors != "" {
+				registeredCompressors += ","
+			}
+			registeredCompressors += callHdr.SendCompress
+		}
+	}
+	if registeredCompressors != "" {
+		headerFields = append(headerFields, hpack.HeaderField{Name: "grpc-accept-encoding", Value: registeredCompressors})
 	}
 	if dl, ok := ctx.Deadline(); ok {
 		// Send out timeout regardless its value. The server can detect timeout context by itself.
@@ -791,6 +809,7 @@ func (t *http2Client) Write(s *Stream, hdr, data []byte, opts *Options) error {
 		endStream: opts.Last,
 		h:         hdr,
 		d:         data,
+		onWritten: opts.OnWritten,
 	}
 	if hdr != nil || data != nil { // If it's not an empty data frame, check quota.
 		if err := s.wq.get(int32(len(hdr) + len(data))); err != nil {
@@ -970,8 +989,8 @@ func (t *http2Client) handleSettings(f *http2.SettingsFrame, isFirst bool) {
 	}
 	if maxStreams != nil {
 		updateStreamQuota := func() {
-			delta := int64(*maxStreams) - int64(t.maxConcurrentStreams)
-			t.maxConcurrentStreams = *maxStreams
+			delta := int64(*maxStreams) - int64(atomic.LoadUint32(&t.maxConcurrentStreams))
+			atomic.StoreUint32(&t.maxConcurrentStreams, *maxStreams)
 			t.streamQuota += delta
 			if delta > 0 && t.waitingStreams > 0 {
 				close(t.streamsQuotaAvailable) // wake all of them up.
@@ -1084,6 +1103,12 @@ func (t *http2Client) setGoAwayReason(f *http2.GoAwayFrame) {
 	}
 }
 
+// MaxConcurrentStreams returns the max number of the concurrent streams allowed
+// by the server.
+func (t *http2Client) MaxConcurrentStreams() uint32 {
+	return atomic.LoadUint32(&t.maxConcurrentStreams)
+}
+
 func (t *http2Client) GetGoAwayReason() (GoAwayReason, string) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -378,6 +378,8 @@ func (t *http2Server) operateHeaders(ctx context.Context, frame *http2.MetaHeade
 			isGRPC = true
 		case "grpc-encoding":
 			s.recvCompress = hf.Value
+		case "grpc-accept-encoding":
+			s.clientAdvertisedCompressors = hf.Value
 		case ":method":
 			httpMethod = hf.Value
 		case ":path":
@@ -997,6 +999,7 @@ func (t *http2Server) Write(s *Stream, hdr []byte, data []byte, opts *Options) e
 		h:           hdr,
 		d:           data,
 		onEachWrite: t.setResetPingStrikes,
+		onWritten:   opts.OnWritten,
 	}
 	if err := s.wq.get(int32(len(hdr) + len(data))); err != nil {
 		select {
@@ -208,9 +208,28 @@ func (s) TestParseDialTarget(t *testing.T) {
 		{"dns:///google.com", "tcp", "dns:///google.com"},
 		{"/unix/socket/address", "tcp", "/unix/socket/address"},
 	} {
-		gotNet, gotAddr := parseDialTarget(test.target)
+		gotNet, gotAddr := ParseDialTarget(test.target)
 		if gotNet != test.wantNet || gotAddr != test.wantAddr {
-			t.Errorf("parseDialTarget(%q) = %s, %s want %s, %s", test.target, gotNet, gotAddr, test.wantNet, test.wantAddr)
+			t.Errorf("ParseDialTarget(%q) = %s, %s want %s, %s", test.target, gotNet, gotAddr, test.wantNet, test.wantAddr)
+		}
+	}
+}
+
+func (s) TestNewNetAddr(t *testing.T) {
+	for _, test := range []struct {
+		network, address, wantNet, wantAddr string
+	}{
+		{"tcp", "127.0.0.1:8080", "tcp", "127.0.0.1:8080"},
+		{"unix", "/tmp/yggdrasil.sock", "unix", "/tmp/yggdrasil.sock"},
+		{"tcp", "unix:///tmp/yggdrasil.sock", "unix", "/tmp/yggdrasil.sock"},
+		{"tcp", "unix:yggdrasil.sock", "unix", "yggdrasil.sock"},
+	} {
+		addr, err := NewNetAddr(test.network, test.address)
+		if err != nil {
+			t.Fatalf("NewNetAddr(%q, %q) failed: %v", test.network, test.address, err)
+		}
+		if addr.Network() != test.wantNet || addr.String() != test.wantAddr {
+			t.Errorf("NewNetAddr(%q, %q) = %s, %s want %s, %s", test.network, test.address, addr.Network(), addr.String(), test.wantNet, test.wantAddr)
 		}
 	}
 }
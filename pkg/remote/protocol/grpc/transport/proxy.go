@@ -28,6 +28,7 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 )
 
 const proxyAuthHeaderKey = "Proxy-Authorization"
@@ -37,6 +38,35 @@ var (
 	httpProxyFromEnvironment = http.ProxyFromEnvironment
 )
 
+// ParseProxyURL parses the url of the HTTP CONNECT proxy, the url without scheme
+// is treated as http. The user info of the url is sent to the proxy with the basic
+// authentication.
+func ParseProxyURL(rawURL string) (*url.URL, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %v", err)
+	}
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %q: missing host", rawURL)
+	}
+	return u, nil
+}
+
+// proxyAddr returns the address to dial the proxy, the port 80 is used if the
+// url has no port. The proxy is always dialed without TLS.
+func proxyAddr(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), "80")
+}
+
 func mapAddress(ctx context.Context, address string) (*url.URL, error) {
 	req := &http.Request{
 		URL: &url.URL{
@@ -48,6 +78,11 @@ func mapAddress(ctx context.Context, address string) (*url.URL, error) {
 	if err != nil {
 		return nil, err
 	}
+	// The proxy is dialed without TLS, the https proxy of the environment is
+	// refused rather than sent the CONNECT request in plaintext.
+	if url != nil && url.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q of the environment proxy %s", url.Scheme, url.Redacted())
+	}
 	return url, nil
 }
 
@@ -111,15 +146,17 @@ func doHTTPConnectHandshake(ctx context.Context, conn net.Conn, backendAddr stri
 
 // proxyDial dials, connecting to a proxy first if necessary. Checks if a proxy
 // is necessary, dials, does the HTTP CONNECT handshake, and returns the
-// connection.
-func proxyDial(ctx context.Context, addr string, grpcUA string) (conn net.Conn, err error) {
+// connection. The proxy is read from the environment if proxyURL is nil.
+func proxyDial(ctx context.Context, addr string, proxyURL *url.URL, grpcUA string) (conn net.Conn, err error) {
 	newAddr := addr
-	proxyURL, err := mapAddress(ctx, addr)
-	if err != nil {
-		return nil, err
+	if proxyURL == nil {
+		proxyURL, err = mapAddress(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if proxyURL != nil {
-		newAddr = proxyURL.Host
+		newAddr = proxyAddr(proxyURL)
 	}
 
 	conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", newAddr)
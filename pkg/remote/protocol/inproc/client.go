@@ -0,0 +1,96 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inproc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/resolver"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+type client struct {
+	serviceName  string
+	address      string
+	closed       atomic.Bool
+	statsHandler stats.Handler
+}
+
+func newClient(_ context.Context, serviceName string, endpoint resolver.Endpoint, statsHandler stats.Handler) remote.Client {
+	return &client{
+		serviceName:  serviceName,
+		address:      endpoint.GetAddress(),
+		statsHandler: statsHandler,
+	}
+}
+
+func (c *client) NewStream(ctx context.Context, desc *stream.StreamDesc, method string) (cs stream.ClientStream, err error) {
+	ctx = c.statsHandler.TagRPC(ctx, &stats.RPCTagInfoBase{FullMethod: method})
+	begin := &stats.RPCBeginBase{
+		Client:       true,
+		BeginTime:    time.Now(),
+		ClientStream: desc.ClientStreams,
+		ServerStream: desc.ServerStreams,
+		Protocol:     scheme,
+	}
+	c.statsHandler.HandleRPC(ctx, begin)
+	defer func() {
+		if err != nil {
+			c.statsHandler.HandleRPC(ctx, &stats.RPCEndBase{
+				Client:    true,
+				BeginTime: begin.BeginTime,
+				EndTime:   time.Now(),
+				Err:       err,
+				Protocol:  scheme,
+			})
+		}
+	}()
+	if c.closed.Load() {
+		return nil, status.Errorf(code.Code_UNAVAILABLE, "inproc: client closed")
+	}
+	svr := getServer(c.address)
+	if svr == nil {
+		return nil, status.Errorf(code.Code_UNAVAILABLE, "inproc: server not found, address: "+c.address)
+	}
+	st := &clientStream{
+		desc:         desc,
+		beginTime:    begin.BeginTime,
+		statsHandler: c.statsHandler,
+	}
+	st.ctx, st.cancel = context.WithCancel(ctx)
+	if st.c, err = svr.newStream(st.ctx, desc, method); err != nil {
+		st.cancel()
+		return nil, err
+	}
+	return st, nil
+}
+
+func (c *client) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return errors.New("remote client closed")
+	}
+	return nil
+}
+
+func (c *client) Scheme() string {
+	return scheme
+}
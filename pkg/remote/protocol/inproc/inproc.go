@@ -0,0 +1,97 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inproc implements a remote protocol that connects the client and the
+// server living in the same process through memory. The rpc still goes through
+// the interceptors, stats handlers and metadata of both sides, only the network
+// is skipped.
+package inproc
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/proto"
+)
+
+const scheme = "inproc"
+
+func init() {
+	remote.RegisterServerBuilder(scheme, newServer)
+	remote.RegisterClientBuilder(scheme, newClient)
+}
+
+var (
+	mu      sync.RWMutex
+	servers = map[string]*server{}
+)
+
+func registerServer(s *server) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := servers[s.opts.Address]; ok {
+		return fmt.Errorf("inproc address %s already in use", s.opts.Address)
+	}
+	servers[s.opts.Address] = s
+	return nil
+}
+
+func deregisterServer(s *server) {
+	mu.Lock()
+	defer mu.Unlock()
+	if servers[s.opts.Address] == s {
+		delete(servers, s.opts.Address)
+	}
+}
+
+func getServer(address string) *server {
+	mu.RLock()
+	defer mu.RUnlock()
+	return servers[address]
+}
+
+// addr implements net.Addr for the in-process endpoints.
+type addr string
+
+func (a addr) Network() string { return scheme }
+
+func (a addr) String() string { return string(a) }
+
+// encode snapshots the message, so that neither side can observe the changes
+// made by the other one after the message is sent.
+func encode(m any) ([]byte, error) {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return nil, status.Errorf(code.Code_INTERNAL, fmt.Sprintf("inproc: failed to marshal, message is %T, want proto.Message", m))
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, status.New(code.Code_INTERNAL, err)
+	}
+	return data, nil
+}
+
+func decode(data []byte, m any) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(code.Code_INTERNAL, fmt.Sprintf("inproc: failed to unmarshal, message is %T, want proto.Message", m))
+	}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return status.New(code.Code_INTERNAL, err)
+	}
+	return nil
+}
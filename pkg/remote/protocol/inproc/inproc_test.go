@@ -0,0 +1,199 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inproc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type endpoint struct {
+	address string
+}
+
+func (e endpoint) GetAddress() string { return e.address }
+
+func (e endpoint) GetProtocol() string { return scheme }
+
+func (e endpoint) GetMetadata() map[string]interface{} { return nil }
+
+type noopStats struct{}
+
+func (noopStats) TagRPC(ctx context.Context, _ stats.RPCTagInfo) context.Context { return ctx }
+
+func (noopStats) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (noopStats) TagChannel(ctx context.Context, _ stats.ChanTagInfo) context.Context { return ctx }
+
+func (noopStats) HandleChannel(context.Context, stats.ChanStats) {}
+
+func testHandle(ss remote.ServerStream) {
+	switch ss.Method() {
+	case "/test/Unary":
+		req := &wrapperspb.StringValue{}
+		if err := ss.Start(false, false); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		if err := ss.RecvMsg(req); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		md, _ := metadata.FromInContext(ss.Context())
+		_ = ss.SetHeader(metadata.Pairs("echo", md.Get("key")[0]))
+		ss.SetTrailer(metadata.Pairs("trailer", "value"))
+		ss.Finish(wrapperspb.String(req.Value), nil)
+	case "/test/Stream":
+		_ = ss.Start(true, true)
+		for {
+			req := &wrapperspb.StringValue{}
+			err := ss.RecvMsg(req)
+			if err == io.EOF {
+				ss.Finish(nil, nil)
+				return
+			}
+			if err != nil {
+				ss.Finish(nil, err)
+				return
+			}
+			if err = ss.SendMsg(req); err != nil {
+				ss.Finish(nil, err)
+				return
+			}
+		}
+	case "/test/Block":
+		_ = ss.Start(false, true)
+		<-ss.Context().Done()
+		ss.Finish(nil, ss.Context().Err())
+	default:
+		ss.Finish(nil, status.Errorf(code.Code_UNIMPLEMENTED, "unknown method"))
+	}
+}
+
+func newTestPair(t *testing.T, address string) (remote.Server, remote.Client) {
+	svr := &server{
+		quit:         make(chan struct{}),
+		opts:         serverOptions{Address: address},
+		handle:       testHandle,
+		statsHandler: noopStats{},
+	}
+	svr.ctx, svr.cancel = context.WithCancel(context.Background())
+	require.NoError(t, svr.Start())
+	go func() { _ = svr.Handle() }()
+	cli := newClient(context.Background(), "test", endpoint{address: address}, noopStats{})
+	t.Cleanup(func() {
+		_ = cli.Close()
+		_ = svr.Stop(context.Background())
+	})
+	return svr, cli
+}
+
+func TestUnary(t *testing.T) {
+	_, cli := newTestPair(t, "unary")
+	ctx := metadata.WithOutContext(context.Background(), metadata.Pairs("key", "value"))
+	cs, err := cli.NewStream(ctx, &stream.StreamDesc{}, "/test/Unary")
+	require.NoError(t, err)
+	require.NoError(t, cs.SendMsg(wrapperspb.String("hello")))
+	reply := &wrapperspb.StringValue{}
+	require.NoError(t, cs.RecvMsg(reply))
+	assert.Equal(t, "hello", reply.Value)
+	header, err := cs.Header()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"value"}, header.Get("echo"))
+	assert.Equal(t, []string{"value"}, cs.Trailer().Get("trailer"))
+}
+
+func TestBidiStream(t *testing.T) {
+	_, cli := newTestPair(t, "stream")
+	cs, err := cli.NewStream(context.Background(), &stream.StreamDesc{ClientStreams: true, ServerStreams: true}, "/test/Stream")
+	require.NoError(t, err)
+	for _, v := range []string{"a", "b", "c"} {
+		require.NoError(t, cs.SendMsg(wrapperspb.String(v)))
+		reply := &wrapperspb.StringValue{}
+		require.NoError(t, cs.RecvMsg(reply))
+		assert.Equal(t, v, reply.Value)
+	}
+	require.NoError(t, cs.CloseSend())
+	assert.Equal(t, io.EOF, cs.RecvMsg(&wrapperspb.StringValue{}))
+}
+
+func TestError(t *testing.T) {
+	_, cli := newTestPair(t, "error")
+	cs, err := cli.NewStream(context.Background(), &stream.StreamDesc{}, "/test/Unknown")
+	require.NoError(t, err)
+	_ = cs.SendMsg(wrapperspb.String("hello"))
+	err = cs.RecvMsg(&wrapperspb.StringValue{})
+	assert.True(t, status.IsCode(err, code.Code_UNIMPLEMENTED))
+}
+
+func TestCancel(t *testing.T) {
+	_, cli := newTestPair(t, "cancel")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cs, err := cli.NewStream(ctx, &stream.StreamDesc{ServerStreams: true}, "/test/Block")
+	require.NoError(t, err)
+	err = cs.RecvMsg(&wrapperspb.StringValue{})
+	assert.True(t, status.IsCode(err, code.Code_DEADLINE_EXCEEDED))
+}
+
+func TestServerNotFound(t *testing.T) {
+	cli := newClient(context.Background(), "test", endpoint{address: "not_found"}, noopStats{})
+	_, err := cli.NewStream(context.Background(), &stream.StreamDesc{}, "/test/Unary")
+	assert.True(t, status.IsCode(err, code.Code_UNAVAILABLE))
+}
+
+func TestStopTimeout(t *testing.T) {
+	svr, cli := newTestPair(t, "stop")
+	cs, err := cli.NewStream(context.Background(), &stream.StreamDesc{ServerStreams: true}, "/test/Block")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.NoError(t, svr.Stop(ctx))
+	assert.Error(t, cs.RecvMsg(&wrapperspb.StringValue{}))
+	_, err = cli.NewStream(context.Background(), &stream.StreamDesc{}, "/test/Unary")
+	assert.True(t, status.IsCode(err, code.Code_UNAVAILABLE))
+}
+
+func TestNewServer_Listener(t *testing.T) {
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyRemoteProto, scheme), map[string]interface{}{
+		"address": "common",
+		"attr":    map[string]string{"weight": "10"},
+	}))
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyServerListenerProtoCfg, "admin"), map[string]interface{}{
+		"address": "admin",
+	}))
+
+	svr, err := newServer("", testHandle)
+	require.NoError(t, err)
+	assert.Equal(t, remote.ServerInfo{Protocol: scheme, Address: "common", Attr: map[string]string{"weight": "10"}}, svr.Info())
+
+	svr, err = newServer("admin", testHandle)
+	require.NoError(t, err)
+	assert.Equal(t, remote.ServerInfo{Protocol: scheme, Name: "admin", Address: "admin", Attr: map[string]string{"weight": "10"}}, svr.Info())
+}
@@ -0,0 +1,183 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inproc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata/peer"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	remotelg "github.com/imkuqin-zw/yggdrasil/pkg/remote/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+type serverOptions struct {
+	// Address is the name the clients use to find the server in the process.
+	Address string `default:"default"`
+	Attr    map[string]string
+}
+
+type server struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	mu           sync.Mutex
+	name         string
+	serve        bool
+	stopped      bool
+	quit         chan struct{}
+	opts         serverOptions
+	handlersWG   sync.WaitGroup
+	handle       remote.MethodHandle
+	statsHandler stats.Handler
+}
+
+func newServer(name string, handle remote.MethodHandle) (remote.Server, error) {
+	opts := serverOptions{}
+	if err := remote.ServerConfig(scheme, name).Scan(&opts); err != nil {
+		return nil, err
+	}
+	s := &server{
+		name:         name,
+		quit:         make(chan struct{}),
+		opts:         opts,
+		handle:       handle,
+		statsHandler: stats.GetServerHandler(),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s, nil
+}
+
+func (s *server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return errors.New("server had already stopped")
+	}
+	if s.serve {
+		return errors.New("server had already serve")
+	}
+	if err := registerServer(s); err != nil {
+		return err
+	}
+	s.serve = true
+	return nil
+}
+
+// Handle blocks until the server is stopped, the rpc are dispatched by the clients.
+func (s *server) Handle() error {
+	<-s.quit
+	return nil
+}
+
+func (s *server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	if s.serve {
+		deregisterServer(s)
+	}
+	close(s.quit)
+	s.mu.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		s.handlersWG.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		remotelg.Logger.WarnField("inproc: server drain timeout, cancel the active rpc",
+			logger.String("address", s.opts.Address))
+		s.cancel()
+		<-drained
+	}
+	s.cancel()
+	return nil
+}
+
+func (s *server) Info() remote.ServerInfo {
+	return remote.ServerInfo{
+		Address:  s.opts.Address,
+		Protocol: scheme,
+		Name:     s.name,
+		Attr:     s.opts.Attr,
+	}
+}
+
+// newStream dispatches a new rpc from the client to the method handle.
+func (s *server) newStream(ctx context.Context, desc *stream.StreamDesc, method string) (*call, error) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil, status.Errorf(code.Code_UNAVAILABLE, "inproc: server stopped")
+	}
+	s.handlersWG.Add(1)
+	s.mu.Unlock()
+
+	c := newCall(method)
+	var (
+		sctx   context.Context
+		cancel context.CancelFunc
+	)
+	if deadline, ok := ctx.Deadline(); ok {
+		sctx, cancel = context.WithDeadline(s.ctx, deadline)
+	} else {
+		sctx, cancel = context.WithCancel(s.ctx)
+	}
+	stop := context.AfterFunc(ctx, cancel)
+	md, _ := metadata.FromOutContext(ctx)
+	sctx = metadata.WithInContext(sctx, md)
+	sctx = peer.PeerWithContext(sctx, &peer.Peer{
+		Addr:      addr(scheme),
+		LocalAddr: addr(s.opts.Address),
+		Protocol:  scheme,
+	})
+	sctx = s.statsHandler.TagRPC(sctx, &stats.RPCTagInfoBase{FullMethod: method})
+	inHeader := &stats.RPCServerInHeaderBase{
+		RPCInHeaderBase: stats.RPCInHeaderBase{
+			Header:   md,
+			Protocol: scheme,
+		},
+		FullMethod:     method,
+		RemoteEndpoint: scheme,
+		LocalEndpoint:  s.opts.Address,
+	}
+	s.statsHandler.HandleRPC(sctx, inHeader)
+
+	ss := &serverStream{
+		ctx: sctx,
+		cancel: func() {
+			stop()
+			cancel()
+		},
+		c:   c,
+		svr: s,
+	}
+	go func() {
+		defer s.handlersWG.Done()
+		s.handle(ss)
+	}()
+	return c, nil
+}
@@ -0,0 +1,384 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inproc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+// call is the in-memory channel shared by the two sides of a rpc.
+type call struct {
+	method string
+
+	reqCh       chan []byte
+	reqDone     chan struct{} // closed when the client half-closed the stream
+	reqDoneOnce sync.Once
+
+	respCh chan []byte
+
+	mu         sync.Mutex
+	header     metadata.MD
+	headerSent bool
+	headerCh   chan struct{} // closed when the header has been sent
+	trailer    metadata.MD
+	err        error
+	done       chan struct{} // closed when the server finished the rpc
+}
+
+func newCall(method string) *call {
+	return &call{
+		method:   method,
+		reqCh:    make(chan []byte),
+		reqDone:  make(chan struct{}),
+		respCh:   make(chan []byte),
+		headerCh: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *call) closeSend() {
+	c.reqDoneOnce.Do(func() {
+		close(c.reqDone)
+	})
+}
+
+// sendHeader must be called with c.mu held.
+func (c *call) sendHeader() {
+	if c.headerSent {
+		return
+	}
+	c.headerSent = true
+	close(c.headerCh)
+}
+
+func (c *call) finish(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sendHeader()
+	c.err = err
+	close(c.done)
+}
+
+// serverStream implements remote.ServerStream on top of the call.
+type serverStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	c      *call
+	svr    *server
+
+	beginTime      time.Time
+	isClientStream bool
+	isServerStream bool
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *serverStream) SetHeader(md metadata.MD) error {
+	if md.Len() == 0 {
+		return nil
+	}
+	ss.c.mu.Lock()
+	defer ss.c.mu.Unlock()
+	if ss.c.headerSent {
+		return status.Errorf(code.Code_INTERNAL, "inproc: the header has been sent")
+	}
+	ss.c.header = metadata.Join(ss.c.header, md)
+	return nil
+}
+
+func (ss *serverStream) SendHeader(md metadata.MD) error {
+	ss.c.mu.Lock()
+	defer ss.c.mu.Unlock()
+	if ss.c.headerSent {
+		return status.Errorf(code.Code_INTERNAL, "inproc: the header has been sent")
+	}
+	ss.c.header = metadata.Join(ss.c.header, md)
+	ss.c.sendHeader()
+	return nil
+}
+
+func (ss *serverStream) SetTrailer(md metadata.MD) {
+	if md.Len() == 0 {
+		return
+	}
+	ss.c.mu.Lock()
+	defer ss.c.mu.Unlock()
+	ss.c.trailer = metadata.Join(ss.c.trailer, md)
+}
+
+func (ss *serverStream) SendMsg(m any) error {
+	data, err := encode(m)
+	if err != nil {
+		return err
+	}
+	ss.c.mu.Lock()
+	ss.c.sendHeader()
+	ss.c.mu.Unlock()
+	select {
+	case ss.c.respCh <- data:
+	case <-ss.ctx.Done():
+		return status.FromContextError(ss.ctx.Err())
+	}
+	ss.svr.statsHandler.HandleRPC(ss.ctx, &stats.RPCOutPayloadBase{
+		Payload:       m,
+		Data:          data,
+		TransportSize: len(data),
+		SendTime:      time.Now(),
+		Protocol:      scheme,
+	})
+	return nil
+}
+
+func (ss *serverStream) RecvMsg(m any) error {
+	var data []byte
+	select {
+	case data = <-ss.c.reqCh:
+	case <-ss.c.reqDone:
+		return io.EOF
+	case <-ss.ctx.Done():
+		return status.FromContextError(ss.ctx.Err())
+	}
+	if err := decode(data, m); err != nil {
+		return err
+	}
+	ss.svr.statsHandler.HandleRPC(ss.ctx, &stats.RPCInPayloadBase{
+		Payload:       m,
+		Data:          data,
+		TransportSize: len(data),
+		RecvTime:      time.Now(),
+		Protocol:      scheme,
+	})
+	return nil
+}
+
+func (ss *serverStream) Method() string {
+	return ss.c.method
+}
+
+func (ss *serverStream) Start(isClientStream, isServerStream bool) error {
+	begin := &stats.RPCBeginBase{
+		BeginTime:    time.Now(),
+		ClientStream: isClientStream,
+		ServerStream: isServerStream,
+		Protocol:     scheme,
+	}
+	ss.svr.statsHandler.HandleRPC(ss.ctx, begin)
+	ss.beginTime = begin.BeginTime
+	ss.isClientStream = isClientStream
+	ss.isServerStream = isServerStream
+	return nil
+}
+
+func (ss *serverStream) Finish(reply any, err error) {
+	defer ss.cancel()
+	if !ss.beginTime.IsZero() {
+		defer func() {
+			ss.svr.statsHandler.HandleRPC(ss.ctx, &stats.RPCEndBase{
+				BeginTime: ss.beginTime,
+				EndTime:   time.Now(),
+				Err:       err,
+				Protocol:  scheme,
+			})
+		}()
+	}
+	if err == nil && !ss.isClientStream && !ss.isServerStream {
+		err = ss.SendMsg(reply)
+	}
+	if err != nil {
+		st, ok := status.CoverError(err)
+		if !ok {
+			st = status.FromContextError(err)
+		}
+		ss.c.finish(st)
+		return
+	}
+	ss.c.finish(nil)
+}
+
+// clientStream implements stream.ClientStream on top of the call.
+type clientStream struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	c            *call
+	desc         *stream.StreamDesc
+	sentLast     bool
+	mu           sync.Mutex
+	finished     bool
+	beginTime    time.Time
+	statsHandler stats.Handler
+}
+
+func (cs *clientStream) Header() (metadata.MD, error) {
+	select {
+	case <-cs.c.headerCh:
+	default:
+		select {
+		case <-cs.c.headerCh:
+		case <-cs.ctx.Done():
+			err := status.FromContextError(cs.ctx.Err())
+			cs.finish(err)
+			return nil, err
+		}
+	}
+	cs.c.mu.Lock()
+	defer cs.c.mu.Unlock()
+	return cs.c.header.Copy(), nil
+}
+
+func (cs *clientStream) Trailer() metadata.MD {
+	select {
+	case <-cs.c.done:
+	default:
+		return nil
+	}
+	cs.c.mu.Lock()
+	defer cs.c.mu.Unlock()
+	return cs.c.trailer.Copy()
+}
+
+func (cs *clientStream) CloseSend() error {
+	if cs.sentLast {
+		return nil
+	}
+	cs.sentLast = true
+	cs.c.closeSend()
+	return nil
+}
+
+func (cs *clientStream) Context() context.Context {
+	return cs.ctx
+}
+
+func (cs *clientStream) SendMsg(m interface{}) (err error) {
+	defer func() {
+		if err != nil && err != io.EOF {
+			cs.finish(err)
+		}
+	}()
+	if cs.sentLast {
+		return status.Errorf(code.Code_INTERNAL, "SendMsg called after CloseSend")
+	}
+	data, err := encode(m)
+	if err != nil {
+		return err
+	}
+	select {
+	case cs.c.reqCh <- data:
+	case <-cs.c.done:
+		// The server has finished the rpc, the status is discovered by RecvMsg.
+		return io.EOF
+	case <-cs.ctx.Done():
+		return status.FromContextError(cs.ctx.Err())
+	}
+	if !cs.desc.ClientStreams {
+		_ = cs.CloseSend()
+	}
+	cs.statsHandler.HandleRPC(cs.ctx, &stats.RPCOutPayloadBase{
+		Client:        true,
+		Payload:       m,
+		Data:          data,
+		TransportSize: len(data),
+		SendTime:      time.Now(),
+		Protocol:      scheme,
+	})
+	return nil
+}
+
+func (cs *clientStream) RecvMsg(m interface{}) (err error) {
+	defer func() {
+		if err != nil || !cs.desc.ServerStreams {
+			// err != nil or non-server-streaming indicates end of stream.
+			cs.finish(err)
+		}
+	}()
+	data, err := cs.recv()
+	if err != nil {
+		if err == io.EOF && !cs.desc.ServerStreams {
+			return status.Errorf(code.Code_INTERNAL, "inproc: no response message received")
+		}
+		return err
+	}
+	if err = decode(data, m); err != nil {
+		return err
+	}
+	cs.statsHandler.HandleRPC(cs.ctx, &stats.RPCInPayloadBase{
+		Client:        true,
+		Payload:       m,
+		Data:          data,
+		TransportSize: len(data),
+		RecvTime:      time.Now(),
+		Protocol:      scheme,
+	})
+	if cs.desc.ServerStreams {
+		return nil
+	}
+	// Special handling for non-server-stream rpcs, wait for the status.
+	if _, err = cs.recv(); err == nil {
+		return status.Errorf(code.Code_INTERNAL, "inproc: client streaming protocol violation: get <nil>, want <EOF>")
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// recv returns the next response message, or io.EOF and the status of the rpc
+// once the server has finished it.
+func (cs *clientStream) recv() ([]byte, error) {
+	select {
+	case data := <-cs.c.respCh:
+		return data, nil
+	case <-cs.c.done:
+		if cs.c.err != nil {
+			return nil, cs.c.err
+		}
+		return nil, io.EOF
+	case <-cs.ctx.Done():
+		return nil, status.FromContextError(cs.ctx.Err())
+	}
+}
+
+func (cs *clientStream) finish(err error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.finished {
+		return
+	}
+	cs.finished = true
+	if errors.Is(err, io.EOF) {
+		// Ending a stream with EOF indicates a success.
+		err = nil
+	}
+	cs.statsHandler.HandleRPC(cs.ctx, &stats.RPCEndBase{
+		Client:    true,
+		BeginTime: cs.beginTime,
+		EndTime:   time.Now(),
+		Err:       err,
+		Protocol:  scheme,
+	})
+	cs.cancel()
+}
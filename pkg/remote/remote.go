@@ -16,21 +16,28 @@ package remote
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
 	"github.com/imkuqin-zw/yggdrasil/pkg/resolver"
 	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/utils/xmap"
 )
 
 type ClientBuilder func(context.Context, string, resolver.Endpoint, stats.Handler) Client
 
 type ServerInfo struct {
 	Protocol string
-	Address  string
-	Attr     map[string]string
+	// Name is the name of the listener, empty for the default server of the protocol.
+	Name    string
+	Address string
+	Attr    map[string]string
 }
 
-type ServerBuilder func(handle MethodHandle) (Server, error)
+// ServerBuilder builds the protocol server. name is the name of the listener,
+// empty for the default server of the protocol.
+type ServerBuilder func(name string, handle MethodHandle) (Server, error)
 
 var (
 	mu            sync.RWMutex
@@ -69,3 +76,29 @@ func GetServerBuilder(scheme string) ServerBuilder {
 	}
 	return builder
 }
+
+// ServerConfig returns the config of the protocol server, the protocolConfig of the
+// named listener is merged over the protocol config. The address is not
+// inherited, each listener sets its own one. A null value of the listener clears
+// the inherited one, e.g. credsProto: ~ serves the listener without credentials.
+func ServerConfig(scheme, name string) config.Value {
+	key := fmt.Sprintf(config.KeyRemoteProto, scheme)
+	if name == "" {
+		return config.Get(key)
+	}
+	values := config.ValueToValues(config.Get(key))
+	_ = values.Del("address")
+	for k, v := range config.Get(fmt.Sprintf(config.KeyServerListenerProtoCfg, name)).Map() {
+		if v == nil {
+			_ = values.Del(k)
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			merged := values.Get(k).Map()
+			xmap.MergeStringMap(merged, sub)
+			v = merged
+		}
+		_ = values.Set(k, v)
+	}
+	return values.Get("")
+}
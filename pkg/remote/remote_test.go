@@ -0,0 +1,108 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testServerConfig struct {
+	Address    string            `yaml:"address"`
+	CredsProto string            `yaml:"credsProto"`
+	ConnLimit  int               `yaml:"connLimit"`
+	Attr       map[string]string `yaml:"attr"`
+}
+
+func TestServerConfig(t *testing.T) {
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyRemoteProto, "test"), map[string]interface{}{
+		"address":    "127.0.0.1:3000",
+		"credsProto": "tls",
+		"connLimit":  100,
+		"attr":       map[string]interface{}{"weight": "10", "zone": "a"},
+	}))
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyServerListenerProtoCfg, "admin"), map[string]interface{}{
+		"address": "127.0.0.1:3001",
+		"attr":    map[string]interface{}{"zone": "b"},
+	}))
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyServerListenerProtoCfg, "noAddress"), map[string]interface{}{
+		"connLimit": 10,
+	}))
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyServerListenerProtoCfg, "insecure"), map[string]interface{}{
+		"address":    "127.0.0.1:3002",
+		"credsProto": nil,
+	}))
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyServerListenerProtoCfg, "empty"), map[string]interface{}{
+		"address":    "127.0.0.1:3003",
+		"credsProto": "",
+	}))
+
+	tests := []struct {
+		name string
+		want testServerConfig
+	}{
+		{
+			name: "",
+			want: testServerConfig{
+				Address: "127.0.0.1:3000", CredsProto: "tls", ConnLimit: 100,
+				Attr: map[string]string{"weight": "10", "zone": "a"},
+			},
+		},
+		{
+			name: "admin",
+			want: testServerConfig{
+				Address: "127.0.0.1:3001", CredsProto: "tls", ConnLimit: 100,
+				Attr: map[string]string{"weight": "10", "zone": "b"},
+			},
+		},
+		{
+			name: "noAddress",
+			want: testServerConfig{
+				CredsProto: "tls", ConnLimit: 10,
+				Attr: map[string]string{"weight": "10", "zone": "a"},
+			},
+		},
+		{
+			name: "insecure",
+			want: testServerConfig{
+				Address: "127.0.0.1:3002", ConnLimit: 100,
+				Attr: map[string]string{"weight": "10", "zone": "a"},
+			},
+		},
+		{
+			name: "empty",
+			want: testServerConfig{
+				Address: "127.0.0.1:3003", ConnLimit: 100,
+				Attr: map[string]string{"weight": "10", "zone": "a"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := testServerConfig{}
+			require.NoError(t, ServerConfig("test", tt.name).Scan(&got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// The protocol config is not modified by the listeners.
+	got := testServerConfig{}
+	require.NoError(t, config.Get(fmt.Sprintf(config.KeyRemoteProto, "test")).Scan(&got))
+	assert.Equal(t, tests[0].want, got)
+}
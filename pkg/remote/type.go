@@ -15,7 +15,9 @@ type MethodHandle func(ServerStream)
 type Server interface {
 	Start() error
 	Handle() error
-	Stop() error
+	// Stop stops accepting new connections and rpc, then waits for the active rpc
+	// to finish. The rpc still active when ctx is done are force closed.
+	Stop(ctx context.Context) error
 	Info() ServerInfo
 }
 
@@ -0,0 +1,57 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// passThroughHeaders are the outgoing header metadata sent as the response
+// headers without the prefix, so the handlers are able to control the caching.
+var passThroughHeaders = map[string]struct{}{
+	"cache-control": {},
+	"etag":          {},
+	"expires":       {},
+	"last-modified": {},
+}
+
+// computeETag returns the strong entity tag of the marshaled response.
+func computeETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatch reports whether the If-None-Match header matches the etag, the weak
+// comparison is used.
+func etagMatch(r *http.Request, etag string) bool {
+	for _, header := range r.Header.Values("If-None-Match") {
+		for _, item := range strings.Split(header, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.TrimPrefix(item, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag of the GET rpc response if the handler does not set
+// one, and responds 304 if the If-None-Match header matches. It reports whether
+// the response is written.
+func (s *ServeMux) notModified(w http.ResponseWriter, r *http.Request, buf []byte) bool {
+	if !s.svrCfg.ETag || r.Method != http.MethodGet {
+		return false
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		etag = computeETag(buf)
+		w.Header().Set("ETag", etag)
+	}
+	if !etagMatch(r, etag) {
+		return false
+	}
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
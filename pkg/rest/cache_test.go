@@ -0,0 +1,49 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeMux_ETag(t *testing.T) {
+	_, url := newRpcServer(t, &Config{ETag: true})
+	resp, body := doRequest(t, http.MethodGet, url+"/v1/ok", nil, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.Equal(t, computeETag(body), etag)
+
+	for _, item := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		resp, body = doRequest(t, http.MethodGet, url+"/v1/ok", nil, map[string]string{"If-None-Match": item})
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode, item)
+		assert.Empty(t, body)
+		assert.Empty(t, resp.Header.Get("Content-Type"))
+		assert.Equal(t, etag, resp.Header.Get("ETag"))
+	}
+
+	resp, _ = doRequest(t, http.MethodGet, url+"/v1/ok", nil, map[string]string{"If-None-Match": `"other"`})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Only the GET responses are tagged.
+	resp, _ = doRequest(t, http.MethodPost, url+"/v1/ok", nil, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("ETag"))
+}
+
+func TestServeMux_ETagError(t *testing.T) {
+	_, url := newRpcServer(t, &Config{ETag: true})
+	for _, item := range []string{"", "*"} {
+		resp, _ := doRequest(t, http.MethodGet, url+"/v1/fail", nil, map[string]string{"If-None-Match": item})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("ETag"))
+	}
+}
+
+func TestServeMux_ETagDisabled(t *testing.T) {
+	_, url := newRpcServer(t, &Config{})
+	resp, _ := doRequest(t, http.MethodGet, url+"/v1/ok", nil, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("ETag"))
+}
@@ -0,0 +1,231 @@
+package rest
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/rest/marshaler"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+const (
+	statusErrorRenderer  = "status"
+	problemErrorRenderer = "problem"
+
+	problemContentType = "application/problem+json"
+)
+
+// ErrorRenderer renders the error status as the http response body.
+type ErrorRenderer interface {
+	// MediaType is matched with the Accept header to select the renderer, the
+	// renderer is only selected by the name if empty.
+	MediaType() string
+	// Render returns the content type and the body of the error response.
+	Render(r *http.Request, st *status.Status) (string, []byte, error)
+}
+
+var errorRenderers = map[string]ErrorRenderer{
+	statusErrorRenderer:  statusRenderer{},
+	problemErrorRenderer: problemRenderer{},
+}
+
+// RegisterErrorRenderer registers the error renderer with the name, it should
+// be called before the rest server is created.
+func RegisterErrorRenderer(name string, r ErrorRenderer) {
+	errorRenderers[name] = r
+}
+
+func getErrorRenderer(name string) ErrorRenderer {
+	r, ok := errorRenderers[name]
+	if !ok {
+		logger.FatalField("rest error renderer not found", logger.String("name", name))
+	}
+	return r
+}
+
+// ErrorConfig selects the error renderers, status renders google.rpc.Status by
+// the outbound marshaler and problem renders application/problem+json.
+type ErrorConfig struct {
+	// Renderer is the renderer of the routes not configured, status if empty.
+	Renderer string
+	// Routes configures the renderers of the routes.
+	Routes []ErrorRouteConfig
+}
+
+// ErrorRouteConfig configures the error renderer of the route, the path is the
+// pattern registered, such as /v1/shelves/{params0} of the generated routes.
+type ErrorRouteConfig struct {
+	Method   string
+	Path     string
+	Renderer string
+}
+
+// mediaErrorRenderers returns the renderers with the media type, ordered by the
+// name so the renderers of the same media type are selected in a fixed order.
+func mediaErrorRenderers() []ErrorRenderer {
+	names := make([]string, 0, len(errorRenderers))
+	for name, renderer := range errorRenderers {
+		if renderer.MediaType() != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	renderers := make([]ErrorRenderer, 0, len(names))
+	for _, name := range names {
+		renderers = append(renderers, errorRenderers[name])
+	}
+	return renderers
+}
+
+// errorRenderer selects the renderer by the Accept header. The renderer of the
+// route, or the default one, is the fallback. The media ranges are matched in
+// the order of the quality, the first range matching the fallback or the media
+// type of a renderer wins. The wildcard ranges only match the fallback.
+func (s *ServeMux) errorRenderer(r *http.Request) ErrorRenderer {
+	fallback := s.defaultRenderer
+	if len(s.routeRenderers) > 0 {
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if renderer, ok := s.routeRenderers[r.Method+" "+rctx.RoutePattern()]; ok {
+				fallback = renderer
+			}
+		}
+	}
+	ranges := parseAccept(r.Header.Values("Accept"))
+	if len(ranges) == 0 {
+		return fallback
+	}
+	fallbackType := fallback.MediaType()
+	if fallbackType == "" {
+		fallbackType = marshaler.OutboundFromContext(r.Context()).ContentType(nil)
+	}
+	for _, item := range ranges {
+		if matchMediaRange(item.mediaType, fallbackType) {
+			return fallback
+		}
+		for _, renderer := range s.mediaRenderers {
+			if renderer.MediaType() == item.mediaType {
+				return renderer
+			}
+		}
+	}
+	return fallback
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept returns the media ranges of the Accept headers ordered by the
+// quality, the ranges of the same quality keep the order of the header. The
+// ranges of zero or invalid quality are dropped.
+func parseAccept(values []string) []acceptRange {
+	var ranges []acceptRange
+	for _, accept := range values {
+		for _, item := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if val, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(val, 64); err != nil || q <= 0 {
+					continue
+				}
+			}
+			ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// matchMediaRange reports whether the media range, such as */* or
+// application/*, matches the media type.
+func matchMediaRange(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1])
+	}
+	return mediaRange == mediaType
+}
+
+// statusRenderer renders google.rpc.Status by the outbound marshaler.
+type statusRenderer struct{}
+
+func (statusRenderer) MediaType() string {
+	return ""
+}
+
+func (statusRenderer) Render(r *http.Request, st *status.Status) (string, []byte, error) {
+	outbound := marshaler.OutboundFromContext(r.Context())
+	pb := st.Status()
+	data, err := outbound.Marshal(pb)
+	return outbound.ContentType(pb), data, err
+}
+
+// problem is the problem details of RFC 7807, with the status code name as the
+// code extension.
+type problem struct {
+	Type   string         `json:"type"`
+	Title  string         `json:"title"`
+	Status int32          `json:"status"`
+	Detail string         `json:"detail,omitempty"`
+	Code   string         `json:"code,omitempty"`
+	Errors []problemField `json:"errors,omitempty"`
+}
+
+type problemField struct {
+	Field   string `json:"field"`
+	Message string `json:"message,omitempty"`
+}
+
+// problemRenderer renders application/problem+json. The type is the domain and
+// the reason of ErrorInfo, and the errors are the field violations of
+// BadRequest.
+type problemRenderer struct{}
+
+func (problemRenderer) MediaType() string {
+	return problemContentType
+}
+
+func (problemRenderer) Render(_ *http.Request, st *status.Status) (string, []byte, error) {
+	res := &problem{
+		Type:   "about:blank",
+		Status: st.HttpCode(),
+		Detail: st.Message(),
+		Code:   code.Code(st.Code()).String(),
+	}
+	res.Title = http.StatusText(int(res.Status))
+	if res.Title == "" {
+		res.Title = res.Code
+	}
+	if reason := st.Reason(); reason != nil && reason.Reason != "" {
+		res.Type = reason.Reason
+		if reason.Domain != "" {
+			res.Type = reason.Domain + "/" + reason.Reason
+		}
+		res.Title = reason.Reason
+	}
+	for _, detail := range st.Status().GetDetails() {
+		badRequest := &errdetails.BadRequest{}
+		if !detail.MessageIs(badRequest) || detail.UnmarshalTo(badRequest) != nil {
+			continue
+		}
+		for _, item := range badRequest.FieldViolations {
+			res.Errors = append(res.Errors, problemField{Field: item.Field, Message: item.Description})
+		}
+	}
+	data, err := json.Marshal(res)
+	return problemContentType, data, err
+}
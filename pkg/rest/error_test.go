@@ -0,0 +1,119 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+func TestParseAccept(t *testing.T) {
+	ranges := parseAccept([]string{
+		"text/html;q=0.2, application/problem+json;q=0.9, application/json",
+		"application/xml;q=0, */*;q=0.2, invalid;;, application/yaml;q=x",
+	})
+	types := make([]string, 0, len(ranges))
+	for _, item := range ranges {
+		types = append(types, item.mediaType)
+	}
+	assert.Equal(t, []string{"application/json", "application/problem+json", "text/html", "*/*"}, types)
+	assert.Empty(t, parseAccept(nil))
+}
+
+func TestMatchMediaRange(t *testing.T) {
+	assert.True(t, matchMediaRange("*/*", "application/json"))
+	assert.True(t, matchMediaRange("application/*", "application/json"))
+	assert.False(t, matchMediaRange("text/*", "application/json"))
+	assert.True(t, matchMediaRange("application/json", "application/json"))
+	assert.False(t, matchMediaRange("application/problem+json", "application/json"))
+}
+
+func TestServeMux_ErrorRenderer(t *testing.T) {
+	mux, url := newRpcServer(t, &Config{Error: ErrorConfig{
+		Routes: []ErrorRouteConfig{{Method: "get", Path: "/v1/route", Renderer: problemErrorRenderer}},
+	}})
+	mux.RpcHandle(http.MethodGet, "/v1/route", rpcNotFound)
+
+	cases := []struct {
+		name    string
+		path    string
+		accept  string
+		problem bool
+	}{
+		{name: "default", path: "/v1/fail"},
+		{name: "problem", path: "/v1/fail", accept: "application/problem+json", problem: true},
+		{name: "prefer json", path: "/v1/fail", accept: "application/json, application/problem+json;q=0.5"},
+		{name: "prefer problem", path: "/v1/fail", accept: "application/json;q=0.5, application/problem+json", problem: true},
+		{name: "wildcard", path: "/v1/fail", accept: "*/*, application/problem+json;q=0.1"},
+		{name: "refused problem", path: "/v1/fail", accept: "application/problem+json;q=0"},
+		{name: "route", path: "/v1/route", problem: true},
+		{name: "route wildcard", path: "/v1/route", accept: "*/*", problem: true},
+		{name: "route prefer json", path: "/v1/route", accept: "application/problem+json;q=0.5, application/json", problem: true},
+	}
+	for _, item := range cases {
+		t.Run(item.name, func(t *testing.T) {
+			resp, body := doRequest(t, http.MethodGet, url+item.path, nil, map[string]string{"Accept": item.accept})
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			if !item.problem {
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+				res := map[string]interface{}{}
+				require.NoError(t, json.Unmarshal(body, &res))
+				assert.EqualValues(t, code.Code_NOT_FOUND, res["code"])
+				return
+			}
+			assert.Equal(t, problemContentType, resp.Header.Get("Content-Type"))
+			assert.JSONEq(t, `{
+				"type": "library/BOOK_MISSING",
+				"title": "BOOK_MISSING",
+				"status": 404,
+				"detail": "EOF",
+				"code": "NOT_FOUND",
+				"errors": [{"field": "name", "message": "not exist"}]
+			}`, string(body))
+		})
+	}
+}
+
+func TestServeMux_ProblemDefault(t *testing.T) {
+	_, url := newRpcServer(t, &Config{Error: ErrorConfig{Renderer: problemErrorRenderer}})
+	resp, _ := doRequest(t, http.MethodGet, url+"/v1/fail", nil, nil)
+	assert.Equal(t, problemContentType, resp.Header.Get("Content-Type"))
+	// The status renderer has no media type, so it is not selected by Accept.
+	resp, _ = doRequest(t, http.MethodGet, url+"/v1/fail", nil, map[string]string{"Accept": "application/json"})
+	assert.Equal(t, problemContentType, resp.Header.Get("Content-Type"))
+
+	resp, body := doRequest(t, http.MethodGet, url+"/v1/ok", nil, map[string]string{"Accept": "application/problem+json"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `"ok"`, string(body))
+}
+
+type testMediaRenderer struct {
+	name string
+}
+
+func (r testMediaRenderer) MediaType() string {
+	return "application/vnd.test+json"
+}
+
+func (r testMediaRenderer) Render(*http.Request, *status.Status) (string, []byte, error) {
+	return r.MediaType(), []byte(`"` + r.name + `"`), nil
+}
+
+func TestServeMux_ErrorRendererOrder(t *testing.T) {
+	RegisterErrorRenderer("test-b", testMediaRenderer{name: "b"})
+	RegisterErrorRenderer("test-a", testMediaRenderer{name: "a"})
+	t.Cleanup(func() {
+		delete(errorRenderers, "test-a")
+		delete(errorRenderers, "test-b")
+	})
+	_, url := newRpcServer(t, &Config{})
+	// The renderers of the same media type are selected by the name.
+	for i := 0; i < 10; i++ {
+		_, body := doRequest(t, http.MethodGet, url+"/v1/fail", nil, map[string]string{"Accept": "application/vnd.test+json"})
+		assert.Equal(t, `"a"`, string(body))
+	}
+}
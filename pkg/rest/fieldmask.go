@@ -0,0 +1,112 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// updateMaskField is the field name of the update mask in the patch requests.
+const updateMaskField = "update_mask"
+
+// DecodeBodyFieldMask decodes the request body into v like DecodeBody, and
+// returns the field mask of the keys present in the json body. The nested
+// objects are expanded unless they are maps or well known types. The mask is nil
+// if the body is not a json object.
+func DecodeBodyFieldMask(r *http.Request, v proto.Message) (*fieldmaskpb.FieldMask, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == formContentType || mediaType == multipartContentType {
+		return nil, DecodeBody(r, v)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, status.New(code.Code_INVALID_ARGUMENT, err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if err = DecodeBody(r, v); err != nil {
+		return nil, err
+	}
+	var obj map[string]json.RawMessage
+	if err = json.Unmarshal(data, &obj); err != nil || obj == nil {
+		return nil, nil
+	}
+	fm := &fieldmaskpb.FieldMask{}
+	fieldMaskFromJSON(fm, "", obj, v.ProtoReflect().Descriptor())
+	return fm, nil
+}
+
+func fieldMaskFromJSON(fm *fieldmaskpb.FieldMask, prefix string, obj map[string]json.RawMessage, md protoreflect.MessageDescriptor) {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fields := md.Fields()
+	for _, key := range keys {
+		fd := fields.ByJSONName(key)
+		if fd == nil {
+			if fd = fields.ByName(protoreflect.Name(key)); fd == nil {
+				continue
+			}
+		}
+		path := prefix + string(fd.Name())
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() &&
+			!strings.HasPrefix(string(fd.Message().FullName()), "google.protobuf.") {
+			var sub map[string]json.RawMessage
+			if err := json.Unmarshal(obj[key], &sub); err == nil && len(sub) > 0 {
+				fieldMaskFromJSON(fm, path+".", sub, fd.Message())
+				continue
+			}
+		}
+		fm.Paths = append(fm.Paths, path)
+	}
+}
+
+// ValidateFieldMask checks the paths of the update mask are the fields of v, the
+// path * is the full replacement. Only the last field of the path can be a
+// repeated or map field.
+func ValidateFieldMask(fm *fieldmaskpb.FieldMask, v proto.Message) error {
+	if len(fm.GetPaths()) == 1 && fm.GetPaths()[0] == "*" {
+		return nil
+	}
+	for _, path := range fm.GetPaths() {
+		if err := validateFieldPath(v.ProtoReflect().Descriptor(), path); err != nil {
+			return status.Errorf(code.Code_INVALID_ARGUMENT, err.Error(), &errdetails.BadRequest{
+				FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					{Field: updateMaskField, Description: err.Error()},
+				},
+			})
+		}
+	}
+	return nil
+}
+
+func validateFieldPath(md protoreflect.MessageDescriptor, path string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return fmt.Errorf("invalid update mask path %q: %q not found in %q", path, name, md.FullName())
+		}
+		if i == len(names)-1 {
+			break
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("invalid update mask path %q: %q is not a message", path, name)
+		}
+		md = fd.Message()
+	}
+	return nil
+}
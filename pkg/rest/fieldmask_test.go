@@ -0,0 +1,239 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// updateBookDesc is the descriptor of the message:
+//
+//	message UpdateBookRequest {
+//	  Book book = 1;
+//	  google.protobuf.FieldMask update_mask = 2;
+//	}
+//
+//	message Book {
+//	  string name = 1;
+//	  string display_name = 2;
+//	  Author author = 3;
+//	  repeated string tags = 4;
+//	  map<string, string> labels = 5;
+//	  google.protobuf.Timestamp update_time = 6;
+//	  repeated Author editors = 7;
+//	}
+//
+//	message Author {
+//	  string name = 1;
+//	  string email = 2;
+//	}
+var updateBookDesc = func() protoreflect.MessageDescriptor {
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		fd := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(num), Type: typ.Enum(), Label: label.Enum()}
+		if typeName != "" {
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+	const (
+		str = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		msg = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("rest/test/book.proto"),
+		Package:    proto.String("rest.test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/field_mask.proto", "google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("UpdateBookRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("book", 1, msg, ".rest.test.Book", false),
+					field("update_mask", 2, msg, ".google.protobuf.FieldMask", false),
+				},
+			},
+			{
+				Name: proto.String("Book"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, str, "", false),
+					field("display_name", 2, str, "", false),
+					field("author", 3, msg, ".rest.test.Author", false),
+					field("tags", 4, str, "", true),
+					field("labels", 5, msg, ".rest.test.Book.LabelsEntry", true),
+					field("update_time", 6, msg, ".google.protobuf.Timestamp", false),
+					field("editors", 7, msg, ".rest.test.Author", true),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("LabelsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, str, "", false),
+						field("value", 2, str, "", false),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+			{
+				Name: proto.String("Author"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, str, "", false),
+					field("email", 2, str, "", false),
+				},
+			},
+		},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	return fd.Messages().ByName("UpdateBookRequest")
+}()
+
+func newBook() *dynamicpb.Message {
+	return dynamicpb.NewMessage(updateBookDesc.Fields().ByName("book").Message())
+}
+
+func decodeFieldMask(t *testing.T, body string) *fieldmaskpb.FieldMask {
+	r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	fm, err := DecodeBodyFieldMask(r, newBook())
+	require.NoError(t, err)
+	return fm
+}
+
+func TestDecodeBodyFieldMask(t *testing.T) {
+	cases := []struct {
+		name  string
+		body  string
+		paths []string
+	}{
+		{name: "fields", body: `{"name": "b", "displayName": "B"}`, paths: []string{"display_name", "name"}},
+		{name: "proto names", body: `{"display_name": "B"}`, paths: []string{"display_name"}},
+		{name: "nested", body: `{"author": {"name": "x", "email": "e"}}`, paths: []string{"author.email", "author.name"}},
+		{name: "empty nested", body: `{"author": {}}`, paths: []string{"author"}},
+		{name: "null nested", body: `{"author": null}`, paths: []string{"author"}},
+		{name: "repeated and map", body: `{"tags": ["a"], "labels": {"k": "v"}, "editors": [{"name": "x"}]}`,
+			paths: []string{"editors", "labels", "tags"}},
+		{name: "well known", body: `{"updateTime": "2020-01-01T00:00:00Z"}`, paths: []string{"update_time"}},
+		{name: "unknown", body: `{"unknown": 1, "name": "b"}`, paths: []string{"name"}},
+		{name: "empty", body: `{}`, paths: nil},
+	}
+	for _, item := range cases {
+		t.Run(item.name, func(t *testing.T) {
+			fm := decodeFieldMask(t, item.body)
+			require.NotNil(t, fm)
+			assert.Equal(t, item.paths, fm.GetPaths())
+		})
+	}
+
+	// The body is still decoded into the message.
+	r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"author": {"name": "x"}}`))
+	book := newBook()
+	_, err := DecodeBodyFieldMask(r, book)
+	require.NoError(t, err)
+	author := book.Get(book.Descriptor().Fields().ByName("author")).Message()
+	assert.Equal(t, "x", author.Get(author.Descriptor().Fields().ByName("name")).String())
+
+	// The mask of the forms is not populated.
+	r = httptest.NewRequest(http.MethodPatch, "/", strings.NewReader("name=b"))
+	r.Header.Set("Content-Type", formContentType)
+	fm, err := DecodeBodyFieldMask(r, newBook())
+	require.NoError(t, err)
+	assert.Nil(t, fm)
+}
+
+func TestValidateFieldMask(t *testing.T) {
+	book := newBook()
+	for _, paths := range [][]string{
+		{"*"},
+		{"name", "author.name"},
+		{"tags", "labels", "editors", "update_time"},
+		nil,
+	} {
+		assert.NoError(t, ValidateFieldMask(&fieldmaskpb.FieldMask{Paths: paths}, book), paths)
+	}
+
+	for _, paths := range [][]string{
+		{"unknown"},
+		{"author.unknown"},
+		{"displayName"},
+		{"tags.name"},
+		{"labels.k"},
+		{"editors.name"},
+		{"name", "*"},
+	} {
+		err := ValidateFieldMask(&fieldmaskpb.FieldMask{Paths: paths}, book)
+		st, ok := status.CoverError(err)
+		require.True(t, ok, paths)
+		assert.True(t, st.IsCode(code.Code_INVALID_ARGUMENT), paths)
+		var badRequest *errdetails.BadRequest
+		for _, detail := range st.Status().GetDetails() {
+			item := &errdetails.BadRequest{}
+			if detail.UnmarshalTo(item) == nil {
+				badRequest = item
+			}
+		}
+		require.NotNil(t, badRequest, paths)
+		assert.Equal(t, updateMaskField, badRequest.FieldViolations[0].Field)
+	}
+}
+
+// decodeUpdateBook decodes the patch request as the generated handler does.
+func decodeUpdateBook(t *testing.T, target, body string) (*fieldmaskpb.FieldMask, error) {
+	r := httptest.NewRequest(http.MethodPatch, target, strings.NewReader(body))
+	req := dynamicpb.NewMessage(updateBookDesc)
+	fields := updateBookDesc.Fields()
+	book := newBook()
+	req.Set(fields.ByName("book"), protoreflect.ValueOfMessage(book))
+	require.NoError(t, PopulateQueryParameters(req, r.URL.Query()))
+	fm, err := DecodeBodyFieldMask(r, book)
+	if err != nil {
+		return nil, err
+	}
+	// The mask of the dynamic message is converted to fieldmaskpb.
+	mask := &fieldmaskpb.FieldMask{}
+	if req.Has(fields.ByName("update_mask")) {
+		require.NoError(t, proto.Unmarshal(mustMarshal(t, req.Get(fields.ByName("update_mask")).Message().Interface()), mask))
+	}
+	if len(mask.GetPaths()) == 0 {
+		mask = fm
+	}
+	return mask, ValidateFieldMask(mask, book)
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	data, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return data
+}
+
+func TestUpdateMask_ClientMask(t *testing.T) {
+	// The mask of the client is kept, the body keys are not merged.
+	mask, err := decodeUpdateBook(t, "/?update_mask=author.name", `{"name": "b", "author": {"name": "x"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"author.name"}, mask.GetPaths())
+
+	mask, err = decodeUpdateBook(t, "/", `{"name": "b", "author": {"name": "x"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"author.name", "name"}, mask.GetPaths())
+
+	_, err = decodeUpdateBook(t, "/?update_mask=unknown", `{"name": "b"}`)
+	assert.Error(t, err)
+}
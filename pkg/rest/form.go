@@ -0,0 +1,318 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/creasty/defaults"
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/rest/marshaler"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	formContentType      = "application/x-www-form-urlencoded"
+	multipartContentType = "multipart/form-data"
+)
+
+// FormConfig configures the binding of the url encoded and the multipart forms.
+type FormConfig struct {
+	// MaxBodySize is the max size of the form body.
+	MaxBodySize int64 `default:"33554432"`
+	// MaxFileSize is the max size of each file part, it is not limited if zero.
+	MaxFileSize int64 `default:"33554432"`
+	// TempDir is the directory of the files streamed, the default directory for
+	// temporary files if empty.
+	TempDir string
+	// FileFields are the string fields bound with the names of the temporary
+	// files streamed from the file parts. The file parts are not bound to other
+	// string fields, and the text values of the file fields are refused.
+	FileFields []FormFileField
+}
+
+// FormFileField is the string field receiving the streamed file.
+type FormFileField struct {
+	// Message is the full name of the message decoded from the body.
+	Message string
+	// Field is the path of the proto field names in the message, such as file
+	// or meta.file.
+	Field string
+}
+
+func (c *FormConfig) isFileField(md protoreflect.MessageDescriptor, path string) bool {
+	for _, item := range c.FileFields {
+		if item.Message == string(md.FullName()) && item.Field == path {
+			return true
+		}
+	}
+	return false
+}
+
+var defaultFormConfig = func() *FormConfig {
+	cfg := &FormConfig{}
+	_ = defaults.Set(cfg)
+	return cfg
+}()
+
+type formConfigKey struct{}
+
+// formFiles are the temporary files of the request, they are removed after the
+// request is handled.
+type formFiles struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (f *formFiles) add(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.names = append(f.names, name)
+}
+
+func (f *formFiles) remove() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range f.names {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.WarnField("fault to remove the upload file", logger.String("file", name), logger.Err(err))
+		}
+	}
+	f.names = nil
+}
+
+type formContext struct {
+	cfg   *FormConfig
+	files *formFiles
+}
+
+func withFormContext(ctx context.Context, cfg *FormConfig) (context.Context, *formFiles) {
+	files := &formFiles{}
+	return context.WithValue(ctx, formConfigKey{}, &formContext{cfg: cfg, files: files}), files
+}
+
+func formFromContext(ctx context.Context) *formContext {
+	if fc, ok := ctx.Value(formConfigKey{}).(*formContext); ok {
+		return fc
+	}
+	return &formContext{cfg: defaultFormConfig}
+}
+
+// DecodeBody decodes the request body into v. The url encoded forms are bound
+// as the query parameters. The multipart forms bind the values as the query
+// parameters, the file parts are read into the bytes fields or streamed into
+// temporary files whose names are bound to the file fields of FormConfig. Other
+// bodies are decoded by the inbound marshaler.
+//
+// The temporary files are removed after the rpc registered by RpcHandle
+// returns.
+func DecodeBody(r *http.Request, v interface{}) error {
+	if msg, ok := v.(proto.Message); ok {
+		mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case formContentType:
+			return decodeForm(r, msg)
+		case multipartContentType:
+			return decodeMultipart(r, msg, params["boundary"])
+		}
+	}
+	inbound := marshaler.InboundFromContext(r.Context())
+	if err := inbound.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return status.New(code.Code_INVALID_ARGUMENT, err)
+	}
+	return nil
+}
+
+func tooLargeError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("the form body exceeds %d bytes", maxErr.Limit))
+	}
+	return status.New(code.Code_INVALID_ARGUMENT, err)
+}
+
+func decodeForm(r *http.Request, msg proto.Message) error {
+	fc := formFromContext(r.Context())
+	data, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, fc.cfg.MaxBodySize))
+	if err != nil {
+		return tooLargeError(err)
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return status.New(code.Code_INVALID_ARGUMENT, err)
+	}
+	if err = checkFormValues(fc, msg.ProtoReflect().Descriptor(), values); err != nil {
+		return err
+	}
+	if err = PopulateQueryParameters(msg, values); err != nil {
+		return status.New(code.Code_INVALID_ARGUMENT, err)
+	}
+	return nil
+}
+
+func decodeMultipart(r *http.Request, msg proto.Message, boundary string) error {
+	if boundary == "" {
+		return status.Errorf(code.Code_INVALID_ARGUMENT, "the multipart boundary is missing")
+	}
+	fc := formFromContext(r.Context())
+	mr := multipart.NewReader(http.MaxBytesReader(nil, r.Body, fc.cfg.MaxBodySize), boundary)
+	values := url.Values{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return tooLargeError(err)
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if part.FileName() == "" {
+			data, err := io.ReadAll(part)
+			if err != nil {
+				return tooLargeError(err)
+			}
+			values.Add(name, string(data))
+			continue
+		}
+		if err = bindFilePart(fc, msg.ProtoReflect(), name, part); err != nil {
+			return err
+		}
+	}
+	if err := checkFormValues(fc, msg.ProtoReflect().Descriptor(), values); err != nil {
+		return err
+	}
+	if err := PopulateQueryParameters(msg, values); err != nil {
+		return status.New(code.Code_INVALID_ARGUMENT, err)
+	}
+	return nil
+}
+
+// formFieldPath returns the path of the proto field names of the form key, the
+// json names are accepted as PopulateQueryParameters does. The key is returned
+// as is if the field is not found.
+func formFieldPath(md protoreflect.MessageDescriptor, name string) string {
+	fieldPath := strings.Split(name, ".")
+	for i, fieldName := range fieldPath {
+		fields := md.Fields()
+		fd := fields.ByName(protoreflect.Name(fieldName))
+		if fd == nil {
+			if fd = fields.ByJSONName(fieldName); fd == nil {
+				return name
+			}
+		}
+		fieldPath[i] = string(fd.Name())
+		if i < len(fieldPath)-1 {
+			if fd.Message() == nil {
+				return name
+			}
+			md = fd.Message()
+		}
+	}
+	return strings.Join(fieldPath, ".")
+}
+
+// checkFormValues refuses the text values of the file fields, the names of the
+// temporary files are only bound from the file parts.
+func checkFormValues(fc *formContext, md protoreflect.MessageDescriptor, values url.Values) error {
+	if len(fc.cfg.FileFields) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if fc.cfg.isFileField(md, formFieldPath(md, key)) {
+			return status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("the file field %q does not accept text values", key))
+		}
+	}
+	return nil
+}
+
+// bindFilePart reads the file part into the bytes field, or streams it into a
+// temporary file and binds the file name to the file field.
+func bindFilePart(fc *formContext, v protoreflect.Message, name string, part *multipart.Part) error {
+	md := v.Descriptor()
+	fieldPath := strings.Split(name, ".")
+	var fd protoreflect.FieldDescriptor
+	for i, fieldName := range fieldPath {
+		fields := v.Descriptor().Fields()
+		if fd = fields.ByName(protoreflect.Name(fieldName)); fd == nil {
+			if fd = fields.ByJSONName(fieldName); fd == nil {
+				logger.Infof("field not found in %q: %q\n", v.Descriptor().FullName(), name)
+				return nil
+			}
+		}
+		if i == len(fieldPath)-1 {
+			break
+		}
+		if fd.Message() == nil || fd.Cardinality() == protoreflect.Repeated {
+			return status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("invalid path: %q is not a message", fieldName))
+		}
+		v = v.Mutable(fd).Message()
+	}
+	if fd.IsMap() || (fd.Kind() != protoreflect.BytesKind && fd.Kind() != protoreflect.StringKind) {
+		return status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("the file %q is not bound to a bytes or string field", name))
+	}
+	if fd.Kind() == protoreflect.StringKind && !fc.cfg.isFileField(md, formFieldPath(md, name)) {
+		return status.Errorf(code.Code_INVALID_ARGUMENT, fmt.Sprintf("the file %q is not bound to a file field", name))
+	}
+
+	src := io.Reader(part)
+	if fc.cfg.MaxFileSize > 0 {
+		src = io.LimitReader(part, fc.cfg.MaxFileSize+1)
+	}
+	var (
+		val protoreflect.Value
+		n   int64
+	)
+	if fd.Kind() == protoreflect.BytesKind {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return tooLargeError(err)
+		}
+		n = int64(len(data))
+		val = protoreflect.ValueOfBytes(data)
+	} else {
+		file, err := os.CreateTemp(fc.cfg.TempDir, "upload-*")
+		if err != nil {
+			return status.New(code.Code_INTERNAL, err)
+		}
+		if fc.files != nil {
+			fc.files.add(file.Name())
+		}
+		n, err = io.Copy(file, src)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return tooLargeError(err)
+		}
+		val = protoreflect.ValueOfString(file.Name())
+	}
+	if fc.cfg.MaxFileSize > 0 && n > fc.cfg.MaxFileSize {
+		return status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("the file %q exceeds %d bytes", name, fc.cfg.MaxFileSize))
+	}
+	if fd.IsList() {
+		v.Mutable(fd).List().Append(val)
+		return nil
+	}
+	v.Set(fd, val)
+	return nil
+}
@@ -0,0 +1,245 @@
+package rest
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// uploadDesc is the descriptor of the message:
+//
+//	message Upload {
+//	  string title = 1;
+//	  string file = 2;
+//	  repeated string files = 3;
+//	  bytes data = 4;
+//	  Meta meta = 5;
+//	  int32 size = 6;
+//	}
+//
+//	message Meta {
+//	  string file_name = 1;
+//	  string note = 2;
+//	}
+var uploadDesc = func() protoreflect.MessageDescriptor {
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, repeated bool) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		return &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(num), Type: typ.Enum(), Label: label.Enum()}
+	}
+	meta := field("meta", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, false)
+	meta.TypeName = proto.String(".rest.test.Meta")
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("rest/test/upload.proto"),
+		Package: proto.String("rest.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Upload"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("title", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+					field("file", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+					field("files", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, true),
+					field("data", 4, descriptorpb.FieldDescriptorProto_TYPE_BYTES, false),
+					meta,
+					field("size", 6, descriptorpb.FieldDescriptorProto_TYPE_INT32, false),
+				},
+			},
+			{
+				Name: proto.String("Meta"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("file_name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+					field("note", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+				},
+			},
+		},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return fd.Messages().ByName("Upload")
+}()
+
+type decodedUpload struct {
+	msg protoreflect.Message
+	// files are the contents of the temporary files, keyed by the file name.
+	files map[string]string
+}
+
+func (d *decodedUpload) get(path string) protoreflect.Value {
+	v := d.msg
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		v = v.Get(v.Descriptor().Fields().ByName(protoreflect.Name(name))).Message()
+	}
+	return v.Get(v.Descriptor().Fields().ByName(protoreflect.Name(names[len(names)-1])))
+}
+
+// newFormServer serves /upload decoding the body into Upload, the files streamed
+// are read before they are removed.
+func newFormServer(t *testing.T, fileFields ...string) (string, string, *decodedUpload) {
+	cfg := *defaultFormConfig
+	cfg.TempDir = t.TempDir()
+	for _, item := range fileFields {
+		cfg.FileFields = append(cfg.FileFields, FormFileField{Message: "rest.test.Upload", Field: item})
+	}
+	mux, ts := newTestServer(t, &Config{Form: cfg}, nil)
+	decoded := &decodedUpload{}
+	mux.RpcHandle(http.MethodPost, "/upload", func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		msg := dynamicpb.NewMessage(uploadDesc)
+		if err := DecodeBody(r, msg); err != nil {
+			return nil, err
+		}
+		decoded.msg = msg
+		decoded.files = map[string]string{}
+		entries, err := os.ReadDir(cfg.TempDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range entries {
+			data, err := os.ReadFile(cfg.TempDir + "/" + item.Name())
+			if err != nil {
+				return nil, err
+			}
+			decoded.files[cfg.TempDir+"/"+item.Name()] = string(data)
+		}
+		return wrapperspb.String("ok"), nil
+	})
+	return ts.URL + "/upload", cfg.TempDir, decoded
+}
+
+type formPart struct {
+	name     string
+	fileName string
+	data     string
+}
+
+func postMultipart(t *testing.T, url string, parts ...formPart) *http.Response {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for _, item := range parts {
+		var (
+			w   io.Writer
+			err error
+		)
+		if item.fileName != "" {
+			w, err = mw.CreateFormFile(item.name, item.fileName)
+		} else {
+			w, err = mw.CreateFormField(item.name)
+		}
+		require.NoError(t, err)
+		_, err = io.WriteString(w, item.data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+	resp, _ := doRequest(t, http.MethodPost, url, body, map[string]string{"Content-Type": mw.FormDataContentType()})
+	return resp
+}
+
+func TestDecodeBody_Form(t *testing.T) {
+	url, _, decoded := newFormServer(t, "file", "meta.file_name")
+	formHeader := map[string]string{"Content-Type": formContentType}
+	resp, _ := doRequest(t, http.MethodPost, url, strings.NewReader("title=hello&size=3&meta.note=n&files=a&files=b"), formHeader)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", decoded.get("title").String())
+	assert.Equal(t, int64(3), decoded.get("size").Int())
+	assert.Equal(t, "n", decoded.get("meta.note").String())
+	assert.Equal(t, 2, decoded.get("files").List().Len())
+
+	// The file fields are refused by both the proto and the json names.
+	for _, form := range []string{"file=/etc/passwd", "meta.file_name=/etc/passwd", "meta.fileName=/etc/passwd"} {
+		resp, _ = doRequest(t, http.MethodPost, url, strings.NewReader(form), formHeader)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, form)
+	}
+}
+
+func TestDecodeBody_Multipart(t *testing.T) {
+	url, dir, decoded := newFormServer(t, "file", "files", "meta.file_name")
+	resp := postMultipart(t, url,
+		formPart{name: "title", data: "hello"},
+		formPart{name: "file", fileName: "a.txt", data: "content a"},
+		formPart{name: "files", fileName: "b.txt", data: "content b"},
+		formPart{name: "files", fileName: "c.txt", data: "content c"},
+		formPart{name: "meta.fileName", fileName: "d.txt", data: "content d"},
+		formPart{name: "data", fileName: "e.bin", data: "bytes e"},
+	)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", decoded.get("title").String())
+	assert.Equal(t, "content a", decoded.files[decoded.get("file").String()])
+	files := decoded.get("files").List()
+	require.Equal(t, 2, files.Len())
+	assert.Equal(t, "content b", decoded.files[files.Get(0).String()])
+	assert.Equal(t, "content c", decoded.files[files.Get(1).String()])
+	assert.Equal(t, "content d", decoded.files[decoded.get("meta.file_name").String()])
+	assert.Equal(t, "bytes e", string(decoded.get("data").Bytes()))
+	assert.Len(t, decoded.files, 4)
+
+	// The temporary files are removed after the rpc returns.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDecodeBody_MultipartOverride(t *testing.T) {
+	url, dir, _ := newFormServer(t, "file")
+	cases := map[string][]formPart{
+		"text after file": {
+			{name: "file", fileName: "a.txt", data: "content"},
+			{name: "file", data: "/etc/passwd"},
+		},
+		"text before file": {
+			{name: "file", data: "/etc/passwd"},
+			{name: "file", fileName: "a.txt", data: "content"},
+		},
+		"file to other string field": {
+			{name: "title", fileName: "a.txt", data: "content"},
+		},
+		"file to non string field": {
+			{name: "size", fileName: "a.txt", data: "content"},
+		},
+	}
+	for name, parts := range cases {
+		resp := postMultipart(t, url, parts...)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, name)
+	}
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDecodeBody_MultipartLimit(t *testing.T) {
+	cfg := *defaultFormConfig
+	cfg.MaxFileSize = 4
+	cfg.FileFields = []FormFileField{{Message: "rest.test.Upload", Field: "file"}}
+	mux, ts := newTestServer(t, &Config{Form: cfg}, nil)
+	mux.RpcHandle(http.MethodPost, "/upload", func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		return wrapperspb.String("ok"), DecodeBody(r, dynamicpb.NewMessage(uploadDesc))
+	})
+	tooLarge := int(status.Errorf(code.Code_RESOURCE_EXHAUSTED, "").HttpCode())
+	resp := postMultipart(t, ts.URL+"/upload", formPart{name: "file", fileName: "a.txt", data: "12345"})
+	assert.Equal(t, tooLarge, resp.StatusCode)
+	resp = postMultipart(t, ts.URL+"/upload", formPart{name: "data", fileName: "a.bin", data: "12345"})
+	assert.Equal(t, tooLarge, resp.StatusCode)
+	resp = postMultipart(t, ts.URL+"/upload", formPart{name: "file", fileName: "a.txt", data: "1234"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/upload", strings.NewReader(""), map[string]string{"Content-Type": multipartContentType})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,595 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata/peer"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/rest/middleware"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	grpcWebProtocol = "grpc-web"
+
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	grpcWebFlagCompressed = 0x01
+	grpcWebFlagTrailer    = 0x80
+
+	defaultGrpcWebMaxReceiveMessageSize = 1024 * 1024 * 4
+)
+
+var grpcWebExposeHeaders = []string{"grpc-status", "grpc-message", "grpc-status-details-bin"}
+
+var grpcWebAllowHeaders = []string{
+	"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout", "grpc-accept-encoding",
+}
+
+// GrpcWebConfig configures the grpc-web protocol served by the rest server.
+type GrpcWebConfig struct {
+	Enable bool
+	// AllowedOrigins are the origins allowed by CORS, * allows all origins. Only
+	// the same origin is allowed if empty.
+	AllowedOrigins []string
+	// AllowedHeaders are the extra request headers allowed by CORS.
+	AllowedHeaders []string
+	// ExposedHeaders are the extra response headers exposed to the browser.
+	ExposedHeaders []string
+	// AllowCredentials allows the cross origin requests with credentials.
+	AllowCredentials      bool
+	MaxReceiveMessageSize int
+}
+
+func (s *ServeMux) StreamHandle(handle remote.MethodHandle) {
+	s.streamHandle = handle
+}
+
+// ServeHTTP dispatches the grpc-web requests to the registered services, other
+// requests are served by the router.
+func (s *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.grpcWebHandler != nil && s.streamHandle != nil {
+		if isGrpcWebRequest(r) || (len(s.grpcWeb.AllowedOrigins) > 0 && isGrpcWebPreflight(r)) {
+			s.grpcWebHandler.ServeHTTP(w, r)
+			return
+		}
+	}
+	s.Router.ServeHTTP(w, r)
+}
+
+func isGrpcWebRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost &&
+		strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+func isGrpcWebPreflight(r *http.Request) bool {
+	if r.Method != http.MethodOptions || r.Header.Get("Origin") == "" {
+		return false
+	}
+	for _, item := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if strings.EqualFold(strings.TrimSpace(item), "x-grpc-web") {
+			return true
+		}
+	}
+	return false
+}
+
+// newGrpcWebHandler returns the handler of the grpc-web requests, the cross
+// origin requests are served by the cors middleware if the origins are allowed.
+func (s *ServeMux) newGrpcWebHandler() http.Handler {
+	handler := http.Handler(http.HandlerFunc(s.serveGrpcWeb))
+	if len(s.grpcWeb.AllowedOrigins) == 0 {
+		return handler
+	}
+	return middleware.NewCors(middleware.CorsConfig{
+		AllowedOrigins:   s.grpcWeb.AllowedOrigins,
+		AllowedMethods:   []string{http.MethodPost},
+		AllowedHeaders:   append(append([]string{}, grpcWebAllowHeaders...), s.grpcWeb.AllowedHeaders...),
+		ExposedHeaders:   append(append([]string{}, grpcWebExposeHeaders...), s.grpcWeb.ExposedHeaders...),
+		AllowCredentials: s.grpcWeb.AllowCredentials,
+		MaxAge:           86400,
+	})(handler)
+}
+
+// grpcWebCodec marshals the messages of the grpc-web content subtype.
+type grpcWebCodec interface {
+	Marshal(m proto.Message) ([]byte, error)
+	Unmarshal(data []byte, m proto.Message) error
+}
+
+type grpcWebProtoCodec struct{}
+
+func (grpcWebProtoCodec) Marshal(m proto.Message) ([]byte, error) {
+	return proto.Marshal(m)
+}
+
+func (grpcWebProtoCodec) Unmarshal(data []byte, m proto.Message) error {
+	return proto.Unmarshal(data, m)
+}
+
+type grpcWebJSONCodec struct{}
+
+func (grpcWebJSONCodec) Marshal(m proto.Message) ([]byte, error) {
+	return protojson.Marshal(m)
+}
+
+func (grpcWebJSONCodec) Unmarshal(data []byte, m proto.Message) error {
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, m)
+}
+
+var grpcWebCodecs = map[string]grpcWebCodec{
+	"proto": grpcWebProtoCodec{},
+	"json":  grpcWebJSONCodec{},
+}
+
+// parseGrpcWebContentType returns whether the body is base64 encoded and the
+// content subtype, the subtype defaults to proto.
+func parseGrpcWebContentType(contentType string) (text bool, subtype string, ok bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, "", false
+	}
+	base, subtype, _ := strings.Cut(mediaType, "+")
+	switch base {
+	case grpcWebContentType:
+	case grpcWebTextContentType:
+		text = true
+	default:
+		return false, "", false
+	}
+	if subtype == "" {
+		subtype = "proto"
+	}
+	return text, subtype, true
+}
+
+func (s *ServeMux) serveGrpcWeb(w http.ResponseWriter, r *http.Request) {
+	text, subtype, ok := parseGrpcWebContentType(r.Header.Get("Content-Type"))
+	codec := grpcWebCodecs[subtype]
+	if !ok || codec == nil {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", r.Header.Get("Content-Type")), http.StatusUnsupportedMediaType)
+		return
+	}
+	ss := &grpcWebStream{
+		w:            w,
+		r:            r,
+		method:       r.URL.Path,
+		text:         text,
+		codec:        codec,
+		maxRecvSize:  s.grpcWeb.MaxReceiveMessageSize,
+		statsHandler: s.statsHandler,
+	}
+	if ss.maxRecvSize == 0 {
+		ss.maxRecvSize = defaultGrpcWebMaxReceiveMessageSize
+	}
+	if ss.text {
+		ss.contentType = grpcWebTextContentType + "+" + subtype
+		ss.body = newGrpcWebTextReader(r.Body)
+	} else {
+		ss.contentType = grpcWebContentType + "+" + subtype
+		ss.body = r.Body
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if v := r.Header.Get("grpc-timeout"); v != "" {
+		timeout, err := decodeGrpcTimeout(v)
+		if err != nil {
+			ss.ctx = ctx
+			ss.Finish(nil, status.Errorf(code.Code_INTERNAL, fmt.Sprintf("malformed grpc-timeout: %v", err)))
+			return
+		}
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	md := extractGrpcWebMetadata(r.Header)
+	p := s.getPeer(r)
+	p.Protocol = grpcWebProtocol
+	p.IsRest = false
+	ctx = metadata.WithInContext(ctx, md)
+	ctx = peer.PeerWithContext(ctx, p)
+	ctx = s.statsHandler.TagRPC(ctx, &stats.RPCTagInfoBase{FullMethod: ss.method})
+	s.statsHandler.HandleRPC(ctx, &stats.RPCServerInHeaderBase{
+		RPCInHeaderBase: stats.RPCInHeaderBase{
+			Header:   md,
+			Protocol: grpcWebProtocol,
+		},
+		FullMethod:     ss.method,
+		RemoteEndpoint: r.RemoteAddr,
+		LocalEndpoint:  s.info.address,
+	})
+	ss.ctx = ctx
+	switch enc := r.Header.Get("grpc-encoding"); enc {
+	case "", "identity":
+	case "gzip":
+		ss.compressed = true
+	default:
+		ss.Finish(nil, status.Errorf(code.Code_UNIMPLEMENTED, fmt.Sprintf("grpc: Decompressor is not installed for grpc-encoding %q", enc)))
+		return
+	}
+	s.streamHandle(ss)
+}
+
+func extractGrpcWebMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for key, vals := range header {
+		key = strings.ToLower(key)
+		switch key {
+		case "content-type", "content-length", "grpc-timeout", "grpc-encoding", "grpc-accept-encoding",
+			"x-grpc-web", "connection", "host", "origin", "te", "transfer-encoding":
+			continue
+		}
+		if !strings.HasSuffix(key, "-bin") {
+			md.Append(key, vals...)
+			continue
+		}
+		for _, v := range vals {
+			if b, err := decodeBinHeader(v); err == nil {
+				md.Append(key, string(b))
+			}
+		}
+	}
+	return md
+}
+
+// grpcWebStream implements remote.ServerStream for the grpc-web requests.
+type grpcWebStream struct {
+	ctx          context.Context
+	w            http.ResponseWriter
+	r            *http.Request
+	body         io.Reader
+	method       string
+	contentType  string
+	text         bool
+	codec        grpcWebCodec
+	compressed   bool
+	maxRecvSize  int
+	statsHandler stats.Handler
+
+	mu          sync.Mutex
+	header      metadata.MD
+	trailer     metadata.MD
+	wroteHeader bool
+
+	beginTime      time.Time
+	isClientStream bool
+	isServerStream bool
+}
+
+func (ss *grpcWebStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *grpcWebStream) SetHeader(md metadata.MD) error {
+	if md.Len() == 0 {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.wroteHeader {
+		return status.Errorf(code.Code_INTERNAL, "grpc-web: the header has been sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	return nil
+}
+
+func (ss *grpcWebStream) SendHeader(md metadata.MD) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.wroteHeader {
+		return status.Errorf(code.Code_INTERNAL, "grpc-web: the header has been sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	ss.writeHeader()
+	return nil
+}
+
+func (ss *grpcWebStream) SetTrailer(md metadata.MD) {
+	if md.Len() == 0 {
+		return
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.trailer = metadata.Join(ss.trailer, md)
+}
+
+// writeHeader must be called with ss.mu held.
+func (ss *grpcWebStream) writeHeader() {
+	if ss.wroteHeader {
+		return
+	}
+	ss.wroteHeader = true
+	h := ss.w.Header()
+	for k, vs := range ss.header {
+		for _, v := range vs {
+			h.Add(k, encodeMetadataValue(k, v))
+		}
+	}
+	h.Set("Content-Type", ss.contentType)
+	ss.w.WriteHeader(http.StatusOK)
+}
+
+// writeFrame must be called with ss.mu held.
+func (ss *grpcWebStream) writeFrame(flags byte, data []byte) error {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+	if ss.text {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+	if _, err := ss.w.Write(frame); err != nil {
+		return err
+	}
+	if f, ok := ss.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (ss *grpcWebStream) SendMsg(m any) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(code.Code_INTERNAL, fmt.Sprintf("grpc-web: failed to marshal, message is %T, want proto.Message", m))
+	}
+	data, err := ss.codec.Marshal(msg)
+	if err != nil {
+		return status.New(code.Code_INTERNAL, err)
+	}
+	ss.mu.Lock()
+	ss.writeHeader()
+	err = ss.writeFrame(0, data)
+	ss.mu.Unlock()
+	if err != nil {
+		return status.New(code.Code_UNAVAILABLE, err)
+	}
+	ss.statsHandler.HandleRPC(ss.ctx, &stats.RPCOutPayloadBase{
+		Payload:       m,
+		Data:          data,
+		TransportSize: len(data) + 5,
+		SendTime:      time.Now(),
+		Protocol:      grpcWebProtocol,
+	})
+	return nil
+}
+
+func (ss *grpcWebStream) RecvMsg(m any) error {
+	var hdr [5]byte
+	if _, err := io.ReadFull(ss.body, hdr[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return status.Errorf(code.Code_INTERNAL, fmt.Sprintf("grpc-web: failed to read message: %v", err))
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if int64(size) > int64(ss.maxRecvSize) {
+		return status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("grpc: received message larger than max (%d vs. %d)", size, ss.maxRecvSize))
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(ss.body, data); err != nil {
+		return status.Errorf(code.Code_INTERNAL, fmt.Sprintf("grpc-web: failed to read message: %v", err))
+	}
+	if hdr[0]&grpcWebFlagCompressed != 0 {
+		if !ss.compressed {
+			return status.Errorf(code.Code_INTERNAL, "grpc: compressed flag set with identity or empty encoding")
+		}
+		var err error
+		if data, err = gzipDecompress(data, ss.maxRecvSize); err != nil {
+			return err
+		}
+	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(code.Code_INTERNAL, fmt.Sprintf("grpc-web: failed to unmarshal, message is %T, want proto.Message", m))
+	}
+	if err := ss.codec.Unmarshal(data, msg); err != nil {
+		return status.Errorf(code.Code_INTERNAL, fmt.Sprintf("grpc: failed to unmarshal the received message: %v", err))
+	}
+	ss.statsHandler.HandleRPC(ss.ctx, &stats.RPCInPayloadBase{
+		Payload:       m,
+		Data:          data,
+		TransportSize: int(size) + 5,
+		RecvTime:      time.Now(),
+		Protocol:      grpcWebProtocol,
+	})
+	return nil
+}
+
+func (ss *grpcWebStream) Method() string {
+	return ss.method
+}
+
+func (ss *grpcWebStream) Start(isClientStream, isServerStream bool) error {
+	begin := &stats.RPCBeginBase{
+		BeginTime:    time.Now(),
+		ClientStream: isClientStream,
+		ServerStream: isServerStream,
+		Protocol:     grpcWebProtocol,
+	}
+	ss.statsHandler.HandleRPC(ss.ctx, begin)
+	ss.beginTime = begin.BeginTime
+	ss.isClientStream = isClientStream
+	ss.isServerStream = isServerStream
+	return nil
+}
+
+func (ss *grpcWebStream) Finish(reply any, err error) {
+	if !ss.beginTime.IsZero() {
+		defer func() {
+			ss.statsHandler.HandleRPC(ss.ctx, &stats.RPCEndBase{
+				BeginTime: ss.beginTime,
+				EndTime:   time.Now(),
+				Err:       err,
+				Protocol:  grpcWebProtocol,
+			})
+		}()
+	}
+	if err == nil && !ss.isClientStream && !ss.isServerStream {
+		err = ss.SendMsg(reply)
+	}
+	var st *status.Status
+	if err != nil {
+		var ok bool
+		if st, ok = status.CoverError(err); !ok {
+			st = status.FromContextError(err)
+		}
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.writeHeader()
+	if err := ss.writeFrame(grpcWebFlagTrailer, ss.encodeTrailer(st)); err != nil {
+		logger.WarnField("grpc-web: fault to write trailer", logger.String("method", ss.method), logger.Err(err))
+	}
+}
+
+// encodeTrailer encodes the status and the trailer metadata as a http/1 header block.
+func (ss *grpcWebStream) encodeTrailer(st *status.Status) []byte {
+	buf := &bytes.Buffer{}
+	if st == nil {
+		buf.WriteString("grpc-status: 0\r\n")
+	} else {
+		pb := st.Status()
+		buf.WriteString("grpc-status: " + strconv.Itoa(int(pb.Code)) + "\r\n")
+		if pb.Message != "" {
+			buf.WriteString("grpc-message: " + encodeGrpcMessage(pb.Message) + "\r\n")
+		}
+		if len(pb.Details) > 0 {
+			if data, err := proto.Marshal(pb); err == nil {
+				buf.WriteString("grpc-status-details-bin: " + base64.RawStdEncoding.EncodeToString(data) + "\r\n")
+			}
+		}
+	}
+	for k, vs := range ss.trailer {
+		for _, v := range vs {
+			buf.WriteString(strings.ToLower(k) + ": " + encodeMetadataValue(k, v) + "\r\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func encodeMetadataValue(k, v string) string {
+	if strings.HasSuffix(k, "-bin") {
+		return base64.RawStdEncoding.EncodeToString([]byte(v))
+	}
+	return v
+}
+
+func decodeBinHeader(v string) ([]byte, error) {
+	if len(v)%4 == 0 {
+		// Input was padded, or padding was not necessary.
+		return base64.StdEncoding.DecodeString(v)
+	}
+	return base64.RawStdEncoding.DecodeString(v)
+}
+
+// encodeGrpcMessage percent encodes the message as grpc-message requires.
+func encodeGrpcMessage(msg string) string {
+	var buf strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			buf.WriteByte(c)
+		} else {
+			buf.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return buf.String()
+}
+
+func gzipDecompress(data []byte, maxSize int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, status.Errorf(code.Code_INTERNAL, fmt.Sprintf("grpc: failed to decompress the received message: %v", err))
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, int64(maxSize)+1))
+	if err != nil {
+		return nil, status.Errorf(code.Code_INTERNAL, fmt.Sprintf("grpc: failed to decompress the received message: %v", err))
+	}
+	if len(out) > maxSize {
+		return nil, status.Errorf(code.Code_RESOURCE_EXHAUSTED, fmt.Sprintf("grpc: received message after decompression larger than max (%d)", maxSize))
+	}
+	return out, nil
+}
+
+func decodeGrpcTimeout(s string) (time.Duration, error) {
+	size := len(s)
+	if size < 2 || size > 9 {
+		return 0, fmt.Errorf("invalid timeout %q", s)
+	}
+	var unit time.Duration
+	switch s[size-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, fmt.Errorf("timeout unit is not recognized: %q", s)
+	}
+	t, err := strconv.ParseInt(s[:size-1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return unit * time.Duration(t), nil
+}
+
+// grpcWebTextReader decodes the grpc-web-text body. The clients may encode each
+// message separately, so the body is decoded by quantum to tolerate the padding
+// in the middle of the body.
+type grpcWebTextReader struct {
+	r   *bufio.Reader
+	dec [3]byte
+	out []byte
+}
+
+func newGrpcWebTextReader(r io.Reader) *grpcWebTextReader {
+	return &grpcWebTextReader{r: bufio.NewReader(r)}
+}
+
+func (t *grpcWebTextReader) Read(p []byte) (int, error) {
+	for len(t.out) == 0 {
+		var quantum [4]byte
+		if _, err := io.ReadFull(t.r, quantum[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return 0, errors.New("grpc-web: malformed base64 body")
+			}
+			return 0, err
+		}
+		n, err := base64.StdEncoding.Decode(t.dec[:], quantum[:])
+		if err != nil {
+			return 0, err
+		}
+		t.out = t.dec[:n]
+	}
+	n := copy(p, t.out)
+	t.out = t.out[n:]
+	return n, nil
+}
@@ -0,0 +1,205 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type grpcWebFrame struct {
+	flags byte
+	data  []byte
+}
+
+func encodeGrpcWebFrame(flags byte, data []byte) []byte {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+	return frame
+}
+
+func decodeGrpcWebFrames(t *testing.T, body []byte) []grpcWebFrame {
+	var frames []grpcWebFrame
+	for len(body) > 0 {
+		require.GreaterOrEqual(t, len(body), 5)
+		size := int(binary.BigEndian.Uint32(body[1:5]))
+		require.GreaterOrEqual(t, len(body), 5+size)
+		frames = append(frames, grpcWebFrame{flags: body[0], data: body[5 : 5+size]})
+		body = body[5+size:]
+	}
+	return frames
+}
+
+func decodeGrpcWebTrailer(t *testing.T, data []byte) http.Header {
+	tr := textproto.NewReader(bufio.NewReader(strings.NewReader(string(data) + "\r\n")))
+	h, err := tr.ReadMIMEHeader()
+	require.NoError(t, err)
+	return http.Header(h)
+}
+
+// grpcWebHeader returns the headers of the grpc-web request of the content type,
+// with the extra header pairs.
+func grpcWebHeader(contentType string, kv ...string) map[string]string {
+	header := map[string]string{"Content-Type": contentType, "X-Grpc-Web": "1"}
+	for i := 0; i+1 < len(kv); i += 2 {
+		header[kv[i]] = kv[i+1]
+	}
+	return header
+}
+
+func newGrpcWebServer(t *testing.T, cfg GrpcWebConfig) string {
+	cfg.Enable = true
+	_, ts := newTestServer(t, &Config{GrpcWeb: cfg}, testHandle)
+	return ts.URL
+}
+
+func TestGrpcWeb_Framing(t *testing.T) {
+	url := newGrpcWebServer(t, GrpcWebConfig{})
+	data, err := proto.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, err)
+
+	resp, body := doRequest(t, http.MethodPost, url+"/test/Unary", bytes.NewReader(encodeGrpcWebFrame(0, data)),
+		grpcWebHeader("application/grpc-web+proto"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/grpc-web+proto", resp.Header.Get("Content-Type"))
+
+	frames := decodeGrpcWebFrames(t, body)
+	require.Len(t, frames, 2)
+	assert.Equal(t, byte(0), frames[0].flags)
+	reply := &wrapperspb.StringValue{}
+	require.NoError(t, proto.Unmarshal(frames[0].data, reply))
+	assert.Equal(t, "hello", reply.Value)
+	assert.Equal(t, byte(grpcWebFlagTrailer), frames[1].flags)
+	assert.Equal(t, "0", decodeGrpcWebTrailer(t, frames[1].data).Get("grpc-status"))
+}
+
+func TestGrpcWeb_TextMode(t *testing.T) {
+	url := newGrpcWebServer(t, GrpcWebConfig{})
+	data, err := proto.Marshal(wrapperspb.String("text"))
+	require.NoError(t, err)
+	body := base64.StdEncoding.EncodeToString(encodeGrpcWebFrame(0, data))
+
+	resp, respBody := doRequest(t, http.MethodPost, url+"/test/Unary", strings.NewReader(body),
+		grpcWebHeader("application/grpc-web-text"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/grpc-web-text+proto", resp.Header.Get("Content-Type"))
+
+	// Each frame is encoded separately, so the body is decoded by the reader
+	// tolerating the padding in the middle.
+	decoded, err := io.ReadAll(newGrpcWebTextReader(bytes.NewReader(respBody)))
+	require.NoError(t, err)
+	frames := decodeGrpcWebFrames(t, decoded)
+	require.Len(t, frames, 2)
+	reply := &wrapperspb.StringValue{}
+	require.NoError(t, proto.Unmarshal(frames[0].data, reply))
+	assert.Equal(t, "text", reply.Value)
+	assert.Equal(t, "0", decodeGrpcWebTrailer(t, frames[1].data).Get("grpc-status"))
+}
+
+func TestGrpcWeb_TrailerFrame(t *testing.T) {
+	url := newGrpcWebServer(t, GrpcWebConfig{})
+	resp, body := doRequest(t, http.MethodPost, url+"/test/NotFound", nil, grpcWebHeader("application/grpc-web+proto"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	frames := decodeGrpcWebFrames(t, body)
+	require.Len(t, frames, 1)
+	assert.Equal(t, byte(grpcWebFlagTrailer), frames[0].flags)
+	trailer := decodeGrpcWebTrailer(t, frames[0].data)
+	assert.Equal(t, "5", trailer.Get("grpc-status"))
+	assert.Equal(t, "not found: 100%25", trailer.Get("grpc-message"))
+}
+
+func TestGrpcWeb_Timeout(t *testing.T) {
+	url := newGrpcWebServer(t, GrpcWebConfig{})
+
+	start := time.Now()
+	_, body := doRequest(t, http.MethodPost, url+"/test/Block", nil,
+		grpcWebHeader("application/grpc-web+proto", "Grpc-Timeout", "50m"))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	frames := decodeGrpcWebFrames(t, body)
+	require.Len(t, frames, 1)
+	assert.Equal(t, "4", decodeGrpcWebTrailer(t, frames[0].data).Get("grpc-status"))
+
+	_, body = doRequest(t, http.MethodPost, url+"/test/Block", nil,
+		grpcWebHeader("application/grpc-web+proto", "Grpc-Timeout", "1x"))
+	frames = decodeGrpcWebFrames(t, body)
+	require.Len(t, frames, 1)
+	assert.Equal(t, "13", decodeGrpcWebTrailer(t, frames[0].data).Get("grpc-status"))
+}
+
+func TestGrpcWeb_JSONSubtype(t *testing.T) {
+	url := newGrpcWebServer(t, GrpcWebConfig{})
+	data, err := protojson.Marshal(wrapperspb.String("json"))
+	require.NoError(t, err)
+
+	resp, body := doRequest(t, http.MethodPost, url+"/test/Unary", bytes.NewReader(encodeGrpcWebFrame(0, data)),
+		grpcWebHeader("application/grpc-web+json"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/grpc-web+json", resp.Header.Get("Content-Type"))
+	frames := decodeGrpcWebFrames(t, body)
+	require.Len(t, frames, 2)
+	assert.JSONEq(t, `"json"`, string(frames[0].data))
+
+	resp, _ = doRequest(t, http.MethodPost, url+"/test/Unary", bytes.NewReader(encodeGrpcWebFrame(0, data)),
+		grpcWebHeader("application/grpc-web+thrift"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
+func TestGrpcWeb_Cors(t *testing.T) {
+	preflight := func(url, origin string) *http.Response {
+		resp, _ := doRequest(t, http.MethodOptions, url+"/test/Unary", nil, map[string]string{
+			"Origin":                         origin,
+			"Access-Control-Request-Method":  http.MethodPost,
+			"Access-Control-Request-Headers": "content-type,x-grpc-web",
+		})
+		return resp
+	}
+
+	t.Run("same origin by default", func(t *testing.T) {
+		url := newGrpcWebServer(t, GrpcWebConfig{})
+		resp := preflight(url, "http://evil.example.com")
+		assert.NotEqual(t, http.StatusNoContent, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+
+		resp, _ = doRequest(t, http.MethodPost, url+"/test/NotFound", nil,
+			grpcWebHeader("application/grpc-web+proto", "Origin", "http://evil.example.com"))
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("allowed origins", func(t *testing.T) {
+		url := newGrpcWebServer(t, GrpcWebConfig{
+			AllowedOrigins: []string{"http://app.example.com"},
+			ExposedHeaders: []string{"x-custom"},
+		})
+		resp := preflight(url, "http://app.example.com")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "http://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, http.MethodPost, resp.Header.Get("Access-Control-Allow-Methods"))
+		assert.Contains(t, resp.Header.Get("Access-Control-Allow-Headers"), "x-grpc-web")
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+
+		resp = preflight(url, "http://evil.example.com")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp, _ = doRequest(t, http.MethodPost, url+"/test/NotFound", nil,
+			grpcWebHeader("application/grpc-web+proto", "Origin", "http://app.example.com"))
+		assert.Equal(t, "http://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		exposed := resp.Header.Get("Access-Control-Expose-Headers")
+		assert.True(t, strings.Contains(exposed, "grpc-status") && strings.Contains(exposed, "x-custom"))
+	})
+}
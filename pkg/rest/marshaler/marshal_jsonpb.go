@@ -20,11 +20,21 @@ func init() {
 }
 
 func NewJsonPbMarshaler() Marshaler {
+	return NewJSONPb(LoadJSONPbConfig())
+}
+
+// LoadJSONPbConfig loads the jsonpb marshaler config.
+func LoadJSONPbConfig() *JSONPbConfig {
 	cfg := &JSONPbConfig{}
 	err := config.Get(fmt.Sprintf(config.KeyRestMarshalerCfg, "jsonpb")).Scan(&cfg)
 	if err != nil {
 		logger.Fatalf("fault to load jsonpb marshaler config")
 	}
+	return cfg
+}
+
+// NewJSONPb creates a JSONPb with the options in cfg.
+func NewJSONPb(cfg *JSONPbConfig) *JSONPb {
 	return &JSONPb{
 		MarshalOptions: protojson.MarshalOptions{
 			Multiline:       cfg.MarshalOptions.Multiline,
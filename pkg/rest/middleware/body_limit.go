@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+)
+
+func init() {
+	RegisterBuilder("body_limit", newBodyLimitMiddleware)
+}
+
+// BodyLimitConfig configures the body_limit middleware.
+type BodyLimitConfig struct {
+	// MaxBytes is the max size of the request body.
+	MaxBytes int64 `default:"4194304"`
+}
+
+func newBodyLimitMiddleware() func(http.Handler) http.Handler {
+	cfg := BodyLimitConfig{}
+	if err := config.Get(fmt.Sprintf(config.KeyRestMiddlewareCfg, "body_limit")).Scan(&cfg); err != nil {
+		logger.FatalField("fault to load body_limit middleware config", logger.Err(err))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > cfg.MaxBytes {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			// The body without the content length fails to read beyond the limit.
+			r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
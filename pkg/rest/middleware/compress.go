@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+)
+
+func init() {
+	RegisterBuilder("compress", newCompressMiddleware)
+}
+
+// CompressConfig configures the compress middleware.
+type CompressConfig struct {
+	// Encodings are the content encodings supported in the order of preference,
+	// the encodings are br, gzip and deflate.
+	Encodings []string `default:"[\"br\",\"gzip\",\"deflate\"]"`
+	// MinSize is the min size of the response body to compress.
+	MinSize int `default:"1024"`
+	// ContentTypes are the media types compressed, the one ending with / matches
+	// all the subtypes.
+	ContentTypes []string `default:"[\"application/json\",\"application/problem+json\",\"application/x-ndjson\",\"application/x-protobuf\",\"text/\"]"`
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderBuilders = map[string]func() encoder{
+	"br":      func() encoder { return brotli.NewWriter(nil) },
+	"gzip":    func() encoder { return gzip.NewWriter(nil) },
+	"deflate": func() encoder { return zlib.NewWriter(nil) },
+}
+
+// newCompressMiddleware compresses the response body by the encoding negotiated
+// from the Accept-Encoding header. The body smaller than the min size is not
+// compressed, and the responses with trailers are not compressed. The
+// websocket upgrade requests are not compressed.
+func newCompressMiddleware() func(http.Handler) http.Handler {
+	cfg := CompressConfig{}
+	if err := config.Get(fmt.Sprintf(config.KeyRestMiddlewareCfg, "compress")).Scan(&cfg); err != nil {
+		logger.FatalField("fault to load compress middleware config", logger.Err(err))
+	}
+	pools := make(map[string]*sync.Pool, len(cfg.Encodings))
+	for _, item := range cfg.Encodings {
+		f, ok := encoderBuilders[item]
+		if !ok {
+			logger.FatalField("unknown compress encoding", logger.String("encoding", item))
+		}
+		pools[item] = &sync.Pool{New: func() interface{} { return f() }}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := negotiateEncoding(r.Header.Values("Accept-Encoding"), cfg.Encodings)
+			if encoding == "" || r.Method == http.MethodHead || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				cfg:            &cfg,
+				encoding:       encoding,
+				pool:           pools[encoding],
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding selects the encoding with the highest quality, the earlier
+// one of the encodings is preferred if the qualities are equal.
+func negotiateEncoding(headers []string, encodings []string) string {
+	qualities := map[string]float64{}
+	for _, header := range headers {
+		for _, item := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				var err error
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+			qualities[name] = q
+		}
+	}
+	var (
+		best  string
+		bestQ float64
+		anyQ  = qualities["*"]
+	)
+	for _, item := range encodings {
+		q, ok := qualities[item]
+		if !ok {
+			q = anyQ
+		}
+		if q > bestQ {
+			best, bestQ = item, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the body until the min size is reached, then decides
+// whether to compress by the response headers.
+type compressWriter struct {
+	http.ResponseWriter
+	cfg      *CompressConfig
+	encoding string
+	pool     *sync.Pool
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	if code < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if !cw.compressible() {
+			if err := cw.start(false); err != nil {
+				return 0, err
+			}
+		} else {
+			cw.buf = append(cw.buf, p...)
+			if len(cw.buf) < cw.cfg.MinSize {
+				return len(p), nil
+			}
+			return len(p), cw.start(true)
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends the buffered body, the body is not compressed if it is smaller
+// than the min size when the first flush happens.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if err := cw.start(false); err != nil {
+			return
+		}
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) compressible() bool {
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Trailer") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, item := range cw.cfg.ContentTypes {
+		if item == mediaType || (strings.HasSuffix(item, "/") && strings.HasPrefix(mediaType, item)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cw *compressWriter) start(compress bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if compress {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+		// The compressed body is not byte-for-byte identical to the origin one.
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.enc = cw.pool.Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided && (cw.status != 0 || len(cw.buf) > 0) {
+		if err := cw.start(false); err != nil {
+			return
+		}
+	}
+	if cw.enc == nil {
+		return
+	}
+	if err := cw.enc.Close(); err != nil {
+		logger.WarnField("fault to close the compress encoder", logger.Err(err))
+	}
+	cw.enc.Reset(nil)
+	cw.pool.Put(cw.enc)
+	cw.enc = nil
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	encodings := []string{"br", "gzip", "deflate"}
+	cases := map[string]string{
+		"":                           "",
+		"identity":                   "",
+		"gzip":                       "gzip",
+		"gzip, br":                   "br",
+		"GZIP, deflate":              "gzip",
+		"br;q=0.5, gzip;q=0.8":       "gzip",
+		"br;q=0, gzip":               "gzip",
+		"*":                          "br",
+		"*;q=0.1, deflate":           "deflate",
+		"*, br;q=0":                  "gzip",
+		"gzip;q=x, deflate;q=0.5":    "deflate",
+		"compress, deflate;q=0.1, x": "deflate",
+	}
+	for header, want := range cases {
+		var headers []string
+		if header != "" {
+			headers = []string{header}
+		}
+		assert.Equal(t, want, negotiateEncoding(headers, encodings), header)
+	}
+	assert.Equal(t, "gzip", negotiateEncoding([]string{"br", "gzip"}, []string{"gzip"}))
+}
+
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch encoding {
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return string(body)
+	}
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// compressHandler builds the compress middleware of the min size around the
+// handler writing body with the content type and the headers.
+func compressHandler(t *testing.T, body, contentType string, header http.Header) http.Handler {
+	return buildMiddleware(t, "compress", map[string]interface{}{"minSize": 16},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, vs := range header {
+				w.Header()[k] = vs
+			}
+			w.Header().Set("Content-Type", contentType)
+			_, _ = io.WriteString(w, body)
+		}))
+}
+
+func serveCompress(h http.Handler, method, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCompress_Encodings(t *testing.T) {
+	body := strings.Repeat(`{"name":"book"}`, 10)
+	h := compressHandler(t, body, "application/json", http.Header{"Etag": []string{`"abc"`}})
+	for _, encoding := range []string{"br", "gzip", "deflate"} {
+		w := serveCompress(h, http.MethodGet, encoding)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, encoding, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		// The compressed body is weakly equal to the origin one.
+		assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+		assert.Equal(t, body, decodeBody(t, encoding, w.Body.Bytes()))
+	}
+
+	w := serveCompress(h, http.MethodGet, "")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+	assert.Equal(t, body, w.Body.String())
+
+	w = serveCompress(h, http.MethodHead, "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+}
+
+func TestCompress_MinSize(t *testing.T) {
+	h := compressHandler(t, `{"a":1}`, "application/json", nil)
+	w := serveCompress(h, http.MethodGet, "gzip")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `{"a":1}`, w.Body.String())
+}
+
+func TestCompress_Skip(t *testing.T) {
+	body := strings.Repeat("x", 64)
+	cases := map[string]http.Handler{
+		// The body encoded by the handler is not encoded again.
+		"encoded": compressHandler(t, body, "application/json", http.Header{"Content-Encoding": []string{"identity"}}),
+		"trailer": compressHandler(t, body, "application/json", http.Header{"Trailer": []string{"X-Checksum"}}),
+		"type":    compressHandler(t, body, "image/png", nil),
+	}
+	for name, h := range cases {
+		w := serveCompress(h, http.MethodGet, "gzip")
+		assert.NotEqual(t, "gzip", w.Header().Get("Content-Encoding"), name)
+		assert.Equal(t, body, w.Body.String(), name)
+	}
+
+	// The subtypes of text/ are compressed.
+	w := serveCompress(compressHandler(t, body, "text/plain; charset=utf-8", nil), http.MethodGet, "gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, decodeBody(t, "gzip", w.Body.Bytes()))
+}
+
+func TestCompress_NotModified(t *testing.T) {
+	h := buildMiddleware(t, "compress", map[string]interface{}{"minSize": 0},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotModified)
+		}))
+	w := serveCompress(h, http.MethodGet, "gzip")
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Body.Bytes())
+}
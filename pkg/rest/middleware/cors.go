@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+)
+
+func init() {
+	RegisterBuilder("cors", newCorsMiddleware)
+}
+
+// CorsConfig configures the cors middleware. The preflight requests are not
+// routed, so the middleware should be set in the All list.
+type CorsConfig struct {
+	// AllowedOrigins are the origins allowed, * allows all origins.
+	AllowedOrigins []string `default:"[\"*\"]"`
+	AllowedMethods []string `default:"[\"GET\",\"POST\",\"PUT\",\"PATCH\",\"DELETE\",\"HEAD\"]"`
+	// AllowedHeaders are the request headers allowed, the headers requested by
+	// the preflight are allowed if empty.
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is the seconds the preflight result can be cached, it is not sent
+	// if zero.
+	MaxAge int
+}
+
+func newCorsMiddleware() func(http.Handler) http.Handler {
+	cfg := CorsConfig{}
+	if err := config.Get(fmt.Sprintf(config.KeyRestMiddlewareCfg, "cors")).Scan(&cfg); err != nil {
+		logger.FatalField("fault to load cors middleware config", logger.Err(err))
+	}
+	return NewCors(cfg)
+}
+
+// NewCors returns the cors middleware of cfg. The preflight requests are
+// answered by the middleware, the disallowed ones are responded 403.
+func NewCors(cfg CorsConfig) func(http.Handler) http.Handler {
+	allowAll := false
+	for _, item := range cfg.AllowedOrigins {
+		if item == "*" {
+			allowAll = true
+		}
+	}
+	allowedMethods := strings.Join(cfg.AllowedMethods, ", ")
+	allowedHeaders := strings.Join(cfg.AllowedHeaders, ", ")
+	exposedHeaders := strings.Join(cfg.ExposedHeaders, ", ")
+	allowOrigin := func(origin string) bool {
+		if allowAll {
+			return true
+		}
+		for _, item := range cfg.AllowedOrigins {
+			if strings.EqualFold(item, origin) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !allowOrigin(origin) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			// The credentials are not allowed with the wildcard origin.
+			if allowAll && !cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if exposedHeaders != "" {
+					h.Set("Access-Control-Expose-Headers", exposedHeaders)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", allowedMethods)
+			if allowedHeaders != "" {
+				h.Set("Access-Control-Allow-Headers", allowedHeaders)
+			} else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+				h.Set("Access-Control-Allow-Headers", reqHeaders)
+			}
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildMiddleware builds the middleware of name with cfg around next.
+func buildMiddleware(t *testing.T, name string, cfg map[string]interface{}, next http.Handler) http.Handler {
+	require.NoError(t, config.Set(fmt.Sprintf(config.KeyRestMiddlewareCfg, name), cfg))
+	middlewares := GetMiddlewares(name)
+	require.Len(t, middlewares, 1)
+	return middlewares[0](next)
+}
+
+func okHandler(called *bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*called = true
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestCors_Preflight(t *testing.T) {
+	var called bool
+	h := buildMiddleware(t, "cors", map[string]interface{}{
+		"allowedOrigins":   []interface{}{"http://app.example.com"},
+		"exposedHeaders":   []interface{}{"X-Total"},
+		"allowCredentials": true,
+		"maxAge":           600,
+	}, okHandler(&called))
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, "/v1/books", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "content-type")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := preflight("http://app.example.com")
+	assert.False(t, called)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST, PUT, PATCH, DELETE, HEAD", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, w.Header().Values("Vary"))
+
+	w = preflight("http://evil.example.com")
+	assert.False(t, called)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+	r.Header.Set("Origin", "http://app.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.True(t, called)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "http://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Total", w.Header().Get("Access-Control-Expose-Headers"))
+}
+
+func TestCors_WildcardOrigin(t *testing.T) {
+	var called bool
+	h := NewCors(CorsConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{http.MethodGet}})
+	r := httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+	r.Header.Set("Origin", "http://any.example.com")
+	w := httptest.NewRecorder()
+	h(okHandler(&called)).ServeHTTP(w, r)
+	assert.True(t, called)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// The credentials are not allowed with the wildcard, the origin is echoed.
+	h = NewCors(CorsConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	w = httptest.NewRecorder()
+	h(okHandler(&called)).ServeHTTP(w, r)
+	assert.Equal(t, "http://any.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestBodyLimit(t *testing.T) {
+	var readErr error
+	h := buildMiddleware(t, "body_limit", map[string]interface{}{"maxBytes": 8},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, readErr = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", 16))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// The body without the content length is limited when it is read.
+	r := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(strings.Repeat("x", 16))))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var maxErr *http.MaxBytesError
+	assert.True(t, errors.As(readErr, &maxErr))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("12345678")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, readErr)
+}
+
+func TestTimeout(t *testing.T) {
+	h := buildMiddleware(t, "timeout", map[string]interface{}{"timeout": "20ms"},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/block" {
+				<-r.Context().Done()
+				return
+			}
+			_, ok := r.Context().Deadline()
+			w.Header().Set("X-Deadline", fmt.Sprint(ok))
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	w := httptest.NewRecorder()
+	begin := time.Now()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/block", nil))
+	assert.GreaterOrEqual(t, time.Since(begin), 20*time.Millisecond)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Deadline"))
+
+	r := httptest.NewRequest(http.MethodGet, "/ok", nil)
+	r.Header.Set("Upgrade", "websocket")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, "false", w.Header().Get("X-Deadline"))
+}
+
+func TestTimeout_Stream(t *testing.T) {
+	h := buildMiddleware(t, "timeout", map[string]interface{}{"timeout": "20ms"},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The stream runs longer than the timeout.
+			for i := 0; i < 5; i++ {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+				_, _ = io.WriteString(w, "{}\n")
+				w.(http.Flusher).Flush()
+			}
+			_, _ = io.WriteString(w, "end")
+		}))
+
+	for _, accept := range []string{"text/event-stream", "application/json, application/x-ndjson;q=0.9"} {
+		r := httptest.NewRequest(http.MethodGet, "/stream", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, accept)
+		assert.True(t, strings.HasSuffix(w.Body.String(), "end"), accept)
+	}
+
+	// The other requests are still limited.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	assert.False(t, strings.HasSuffix(w.Body.String(), "end"))
+}
+
+func TestRequestID(t *testing.T) {
+	var ctxID, headerID string
+	h := buildMiddleware(t, "request_id", map[string]interface{}{"header": "X-Trace-Id"},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctxID = RequestIDFromContext(r.Context())
+			headerID = r.Header.Get("X-Trace-Id")
+		}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Trace-Id", "abc")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, "abc", ctxID)
+	assert.Equal(t, "abc", headerID)
+	assert.Equal(t, "abc", w.Header().Get("X-Trace-Id"))
+
+	for _, id := range []string{"", strings.Repeat("x", maxRequestIDSize+1)} {
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Trace-Id", id)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Len(t, ctxID, 32)
+		// The generated id is set to the request, so it is accepted into the rpc
+		// metadata.
+		assert.Equal(t, ctxID, headerID)
+		assert.Equal(t, ctxID, w.Header().Get("X-Trace-Id"))
+	}
+	assert.Empty(t, RequestIDFromContext(r.Context()))
+}
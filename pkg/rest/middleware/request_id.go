@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+)
+
+func init() {
+	RegisterBuilder("request_id", newRequestIDMiddleware)
+}
+
+const maxRequestIDSize = 128
+
+// RequestIDConfig configures the request_id middleware.
+type RequestIDConfig struct {
+	// Header is the header carrying the request id, it should be listed in the
+	// AcceptHeader of the rest server to pass the id into the rpc metadata.
+	Header string `default:"X-Request-Id"`
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request id set by the request_id middleware.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestIDMiddleware keeps the request id of the request, or generates one
+// if absent, and echoes it in the response header.
+func newRequestIDMiddleware() func(http.Handler) http.Handler {
+	cfg := RequestIDConfig{}
+	if err := config.Get(fmt.Sprintf(config.KeyRestMiddlewareCfg, "request_id")).Scan(&cfg); err != nil {
+		logger.FatalField("fault to load request_id middleware config", logger.Err(err))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(cfg.Header)
+			if id == "" || len(id) > maxRequestIDSize {
+				id = newRequestID()
+				r.Header.Set(cfg.Header, id)
+			}
+			w.Header().Set(cfg.Header, id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+func newRequestID() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+)
+
+func init() {
+	RegisterBuilder("timeout", newTimeoutMiddleware)
+}
+
+// TimeoutConfig configures the timeout middleware.
+type TimeoutConfig struct {
+	// Timeout is the deadline of the request context.
+	Timeout time.Duration `default:"30s"`
+}
+
+// newTimeoutMiddleware sets the deadline of the request context, the rpc gets
+// the deadline from the context. The gateway timeout is responded if the
+// deadline exceeds before the handler writes the response. The long-lived
+// requests are not limited: the websocket upgrade requests, and the requests
+// of the server streams accepting text/event-stream or application/x-ndjson.
+// The clients of the newline delimited json streams must send the Accept header
+// to be exempted.
+func newTimeoutMiddleware() func(http.Handler) http.Handler {
+	cfg := TimeoutConfig{}
+	if err := config.Get(fmt.Sprintf(config.KeyRestMiddlewareCfg, "timeout")).Scan(&cfg); err != nil {
+		logger.FatalField("fault to load timeout middleware config", logger.Err(err))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Timeout <= 0 || isUpgrade(r) || acceptsStream(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), cfg.Timeout)
+			defer cancel()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+			if ww.Status() == 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
+
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != ""
+}
+
+// streamMediaTypes are the media types of the server streams.
+var streamMediaTypes = []string{"text/event-stream", "application/x-ndjson"}
+
+// acceptsStream reports whether the request accepts the response of the server
+// stream.
+func acceptsStream(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for _, item := range strings.Split(value, ",") {
+			mediaType, _, _ := strings.Cut(item, ";")
+			mediaType = strings.TrimSpace(mediaType)
+			for _, streamType := range streamMediaTypes {
+				if strings.EqualFold(mediaType, streamType) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package rest
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -12,16 +13,21 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
 	"github.com/imkuqin-zw/yggdrasil/pkg/config"
 	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
 	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
 	"github.com/imkuqin-zw/yggdrasil/pkg/metadata/peer"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
 	"github.com/imkuqin-zw/yggdrasil/pkg/rest/marshaler"
 	"github.com/imkuqin-zw/yggdrasil/pkg/rest/middleware"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
 	"github.com/imkuqin-zw/yggdrasil/pkg/status"
 	"github.com/imkuqin-zw/yggdrasil/pkg/utils/xarray"
 	"github.com/imkuqin-zw/yggdrasil/pkg/utils/xnet"
 	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/genproto/googleapis/rpc/code"
 	"google.golang.org/protobuf/proto"
 )
@@ -37,13 +43,38 @@ type Config struct {
 		Web string
 		All string
 	}
+	GrpcWeb   GrpcWebConfig
+	WebSocket WebSocketConfig
+	OpenAPI   OpenAPIConfig
+	TLS       TLSConfig
+	Error     ErrorConfig
+	Form      FormConfig
+	// ETag computes the ETag of the GET rpc responses if the handler does not
+	// set one, and responds 304 if the If-None-Match header matches.
+	ETag bool
+	// H2C serves the http/2 without TLS, it is ignored if TLS is enabled.
+	H2C bool
+	// ReadHeaderTimeout is the amount of time allowed to read the request
+	// headers.
+	ReadHeaderTimeout time.Duration
+	// IdleTimeout is the max amount of time to wait for the next request when
+	// the keep-alives are enabled.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes is the max size of the request headers, it defaults to
+	// http.DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
 }
 
 type serverInfo struct {
+	scheme     string
 	address    string
 	attributes map[string]string
 }
 
+func (s *serverInfo) GetScheme() string {
+	return s.scheme
+}
+
 func (s *serverInfo) GetAttributes() map[string]string {
 	return s.attributes
 }
@@ -59,6 +90,7 @@ type ServeMux struct {
 	rpcRouter chi.Router
 	webRouter chi.Router
 	svr       *http.Server
+	svrCfg    *Config
 	mu        sync.Mutex
 	listener  net.Listener
 	stopped   bool
@@ -69,6 +101,24 @@ type ServeMux struct {
 	acceptHeaders []string
 	outHeaders    []string
 	outTrailers   []string
+
+	defaultRenderer ErrorRenderer
+	// mediaRenderers are the renderers selected by the Accept header.
+	mediaRenderers []ErrorRenderer
+	// routeRenderers are the error renderers of the routes, keyed by the method
+	// and the route pattern.
+	routeRenderers map[string]ErrorRenderer
+
+	grpcWeb        GrpcWebConfig
+	grpcWebHandler http.Handler
+	streamHandle   remote.MethodHandle
+	statsHandler   stats.Handler
+
+	webSocket  WebSocketConfig
+	wsUpgrader *websocket.Upgrader
+	wsMu       sync.Mutex
+	wsStreams  map[*wsStream]struct{}
+	wsWG       sync.WaitGroup
 }
 
 func NewServer() Server {
@@ -76,7 +126,10 @@ func NewServer() Server {
 	if err := config.Get(config.KeyRest).Scan(cfg); err != nil {
 		logger.FatalField("fault to load rest config", logger.Err(err))
 	}
+	return newServeMux(cfg)
+}
 
+func newServeMux(cfg *Config) *ServeMux {
 	ip, _ := xnet.Extract(cfg.Host)
 	address := fmt.Sprintf("%s:%d", ip, cfg.Port)
 
@@ -101,11 +154,13 @@ func NewServer() Server {
 	webRouter := r.Group(func(r chi.Router) {
 		r.Use(middleware.GetMiddlewares(webMiddlewares...)...)
 	})
-	return &ServeMux{
+	mux := &ServeMux{
 		Router:    r,
 		rpcRouter: rpcRouter,
 		webRouter: webRouter,
+		svrCfg:    cfg,
 		info: &serverInfo{
+			scheme:     "http",
 			address:    address,
 			attributes: map[string]string{},
 		},
@@ -113,7 +168,37 @@ func NewServer() Server {
 		acceptHeaders: strings.Split(cfg.AcceptHeader, ","),
 		outHeaders:    strings.Split(cfg.OutHeader, ","),
 		outTrailers:   strings.Split(cfg.OutTrailer, ","),
+
+		grpcWeb:      cfg.GrpcWeb,
+		statsHandler: stats.GetServerHandler(),
+
+		webSocket:  cfg.WebSocket,
+		wsUpgrader: newWebSocketUpgrader(cfg.WebSocket),
+	}
+	if cfg.TLS.Enable {
+		mux.info.scheme = "https"
+	}
+	mux.defaultRenderer = getErrorRenderer(statusErrorRenderer)
+	mux.mediaRenderers = mediaErrorRenderers()
+	if cfg.Error.Renderer != "" {
+		mux.defaultRenderer = getErrorRenderer(cfg.Error.Renderer)
+	}
+	for _, item := range cfg.Error.Routes {
+		if mux.routeRenderers == nil {
+			mux.routeRenderers = map[string]ErrorRenderer{}
+		}
+		mux.routeRenderers[strings.ToUpper(item.Method)+" "+item.Path] = getErrorRenderer(item.Renderer)
+	}
+	if cfg.GrpcWeb.Enable {
+		mux.grpcWebHandler = mux.newGrpcWebHandler()
+	}
+	if mux.webSocket.MaxReceiveMessageSize <= 0 {
+		mux.webSocket.MaxReceiveMessageSize = defaultWebSocketMaxReceiveMessageSize
 	}
+	if cfg.OpenAPI.Enable {
+		mux.serveOpenAPI(cfg.OpenAPI)
+	}
+	return mux
 }
 
 func (s *ServeMux) RpcHandle(meth, path string, f HandlerFunc) {
@@ -122,6 +207,8 @@ func (s *ServeMux) RpcHandle(meth, path string, f HandlerFunc) {
 		ctx = metadata.WithStreamContext(ctx)
 		ctx = metadata.WithInContext(ctx, s.extractInMetadata(r))
 		ctx = peer.PeerWithContext(ctx, s.getPeer(r))
+		ctx, files := withFormContext(ctx, &s.svrCfg.Form)
+		defer files.remove()
 		r = r.WithContext(ctx)
 		res, err := f(w, r)
 		if err != nil {
@@ -146,6 +233,17 @@ func (s *ServeMux) Start() error {
 		return errors.New("server had already serve")
 	}
 	s.started = true
+	var handler http.Handler = s
+	var tlsCfg *tls.Config
+	if s.svrCfg.TLS.Enable {
+		cfg, err := s.svrCfg.TLS.serverTLSConfig()
+		if err != nil {
+			return errors.WithMessage(err, "fault to load the rest server tls config")
+		}
+		tlsCfg = cfg
+	} else if s.svrCfg.H2C {
+		handler = h2c.NewHandler(s, &http2.Server{IdleTimeout: s.svrCfg.IdleTimeout})
+	}
 	lis, err := net.Listen("tcp", s.info.address)
 	if err != nil {
 		return err
@@ -153,19 +251,32 @@ func (s *ServeMux) Start() error {
 	s.info.address = lis.Addr().String()
 	s.listener = lis
 	s.svr = &http.Server{
-		Handler: s,
+		Handler:           handler,
+		TLSConfig:         tlsCfg,
+		ReadHeaderTimeout: s.svrCfg.ReadHeaderTimeout,
+		IdleTimeout:       s.svrCfg.IdleTimeout,
+		MaxHeaderBytes:    s.svrCfg.MaxHeaderBytes,
 	}
 	return nil
 }
 
 func (s *ServeMux) Serve() error {
+	if s.svr.TLSConfig != nil {
+		// The certificate is got from the tls config, the h2 is added to the
+		// ALPN protocols.
+		return s.svr.ServeTLS(s.listener, "", "")
+	}
 	return s.svr.Serve(s.listener)
 }
 
-func (s *ServeMux) Stop() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	return s.svr.Shutdown(ctx)
+func (s *ServeMux) Stop(ctx context.Context) error {
+	var err error
+	if err = s.svr.Shutdown(ctx); err != nil {
+		logger.WarnField("rest server drain timeout, force close the active connections", logger.Err(err))
+		err = s.svr.Close()
+	}
+	s.drainWebSockets(ctx)
+	return err
 }
 
 func (s *ServeMux) Info() ServerInfo {
@@ -214,25 +325,22 @@ func (s *ServeMux) getPeer(r *http.Request) *peer.Peer {
 
 func (s *ServeMux) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
 	ctx := r.Context()
-	outbound := marshaler.OutboundFromContext(ctx)
 
 	// return Internal when Marshal failed
 	const fallback = `{"code": 13, "message": "failed to marshal error message"}`
 
 	st := status.FromError(err)
-	pb := st.Status()
 
 	w.Header().Del("Trailer")
 	w.Header().Del("Transfer-Encoding")
 
-	contentType := outbound.ContentType(pb)
+	contentType, buf, merr := s.errorRenderer(r).Render(r, st)
 	w.Header().Set("Content-Type", contentType)
 
 	if st.IsCode(code.Code_UNAUTHENTICATED) {
 		w.Header().Set("WWW-Authenticate", st.Message())
 	}
 
-	buf, merr := outbound.Marshal(pb)
 	if merr != nil {
 		logger.Errorf("failed to marshal error message %q: %v", st, merr)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -247,6 +355,9 @@ func (s *ServeMux) errorHandler(w http.ResponseWriter, r *http.Request, err erro
 
 	s.handleResponseHeader(w, header)
 
+	// The entity tag of the resource is not sent with the error.
+	w.Header().Del("ETag")
+
 	doForwardTrailers := s.requestAcceptsTrailers(r)
 
 	if doForwardTrailers && trailerHeader.Len() > 0 {
@@ -283,6 +394,10 @@ func (s *ServeMux) successHandler(w http.ResponseWriter, r *http.Request, resp p
 
 	s.handleResponseHeader(w, header)
 
+	if s.notModified(w, r, buf) {
+		return
+	}
+
 	doForwardTrailers := s.requestAcceptsTrailers(r)
 
 	if doForwardTrailers && trailerHeader.Len() > 0 {
@@ -310,6 +425,9 @@ func (s *ServeMux) handleResponseHeader(w http.ResponseWriter, md metadata.MD) {
 }
 
 func (s *ServeMux) outgoingHeaderMatcher(key string) (string, bool) {
+	if _, ok := passThroughHeaders[strings.ToLower(key)]; ok {
+		return key, true
+	}
 	for _, item := range s.outHeaders {
 		if item == key {
 			return key, true
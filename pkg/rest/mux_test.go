@@ -0,0 +1,183 @@
+package rest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newTestServer serves the mux of cfg by a test http server, the rpc carried
+// over http are dispatched by handle if it is not nil.
+func newTestServer(t *testing.T, cfg *Config, handle remote.MethodHandle) (*ServeMux, *httptest.Server) {
+	mux := newServeMux(cfg)
+	if handle != nil {
+		mux.StreamHandle(handle)
+	}
+	ts := httptest.NewUnstartedServer(mux)
+	mux.listener = ts.Listener
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return mux, ts
+}
+
+// doRequest sends the request of the method to url with the body and the
+// headers, the empty headers are not sent. It returns the response with its
+// body read.
+func doRequest(t *testing.T, method, url string, body io.Reader, header map[string]string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, body)
+	require.NoError(t, err)
+	for k, v := range header {
+		if v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, data
+}
+
+// rpcOK replies the StringValue "ok".
+func rpcOK(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return wrapperspb.String("ok"), nil
+}
+
+// rpcNotFound fails with the not found status carrying the reason and the field
+// violation.
+func rpcNotFound(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return nil, status.New(code.Code_NOT_FOUND, io.EOF,
+		&errdetails.ErrorInfo{Reason: "BOOK_MISSING", Domain: "library"},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "name", Description: "not exist"},
+		}})
+}
+
+// newRpcServer serves GET and POST /v1/ok by rpcOK and GET /v1/fail by
+// rpcNotFound.
+func newRpcServer(t *testing.T, cfg *Config) (*ServeMux, string) {
+	mux, ts := newTestServer(t, cfg, nil)
+	mux.RpcHandle(http.MethodGet, "/v1/ok", rpcOK)
+	mux.RpcHandle(http.MethodPost, "/v1/ok", rpcOK)
+	mux.RpcHandle(http.MethodGet, "/v1/fail", rpcNotFound)
+	return mux, ts.URL
+}
+
+// testHandle echoes the StringValue of /test/Unary, fails /test/NotFound and
+// blocks /test/Block until the rpc is done.
+func testHandle(ss remote.ServerStream) {
+	switch ss.Method() {
+	case "/test/Unary":
+		req := &wrapperspb.StringValue{}
+		if err := ss.Start(false, false); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		if err := ss.RecvMsg(req); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		ss.Finish(wrapperspb.String(req.Value), nil)
+	case "/test/NotFound":
+		_ = ss.Start(false, false)
+		ss.Finish(nil, status.Errorf(code.Code_NOT_FOUND, "not found: 100%"))
+	case "/test/Block":
+		_ = ss.Start(false, true)
+		<-ss.Context().Done()
+		ss.Finish(nil, ss.Context().Err())
+	default:
+		ss.Finish(nil, status.Errorf(code.Code_UNIMPLEMENTED, "unknown method"))
+	}
+}
+
+func startDrainServer(t *testing.T, started chan struct{}) *ServeMux {
+	mux := newServeMux(&Config{Host: "127.0.0.1"})
+	mux.RawHandle(http.MethodGet, "/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.RawHandle(http.MethodGet, "/block", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+	require.NoError(t, mux.Start())
+	go func() { _ = mux.Serve() }()
+	return mux
+}
+
+func TestServeMux_DrainGraceful(t *testing.T) {
+	mux := startDrainServer(t, make(chan struct{}))
+	resp, err := http.Get("http://" + mux.Info().GetAddress() + "/ok")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, mux.Stop(ctx))
+	assert.NoError(t, ctx.Err())
+}
+
+func TestServeMux_DrainTimeout(t *testing.T) {
+	started := make(chan struct{})
+	mux := startDrainServer(t, started)
+	errCh := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + mux.Info().GetAddress() + "/block")
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}
+		errCh <- err
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request is not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_ = mux.Stop(ctx)
+	assert.GreaterOrEqual(t, time.Since(begin), 100*time.Millisecond)
+	select {
+	case err := <-errCh:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the active connection is not closed")
+	}
+}
+
+func TestServeMux_RequestIDMetadata(t *testing.T) {
+	cfg := &Config{AcceptHeader: "X-Request-Id"}
+	cfg.Middleware.All = "request_id"
+	mux, ts := newTestServer(t, cfg, nil)
+	var ids []string
+	mux.RpcHandle(http.MethodGet, "/v1/id", func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		md, _ := metadata.FromInContext(r.Context())
+		ids = md.Get("X-Request-Id")
+		return wrapperspb.String("ok"), nil
+	})
+
+	resp, _ := doRequest(t, http.MethodGet, ts.URL+"/v1/id", nil, map[string]string{"X-Request-Id": "abc"})
+	assert.Equal(t, []string{"abc"}, ids)
+	assert.Equal(t, "abc", resp.Header.Get("X-Request-Id"))
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/v1/id", nil, nil)
+	require.Len(t, ids, 1)
+	assert.NotEmpty(t, ids[0])
+	assert.Equal(t, ids[0], resp.Header.Get("X-Request-Id"))
+}
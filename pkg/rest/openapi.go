@@ -0,0 +1,44 @@
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+)
+
+// OpenAPIConfig configures the OpenAPI document served by the rest server, such
+// as the one generated by protoc-gen-yggdrasil-openapi.
+type OpenAPIConfig struct {
+	Enable bool
+	// File is the path of the document, in yaml or json.
+	File string
+	// Path is the route of the document, it defaults to /openapi.yaml, or
+	// /openapi.json if the document is json.
+	Path string
+}
+
+func (s *ServeMux) serveOpenAPI(cfg OpenAPIConfig) {
+	info, err := os.Stat(cfg.File)
+	if err != nil {
+		logger.FatalField("fault to load openapi document", logger.String("file", cfg.File), logger.Err(err))
+	}
+	data, err := os.ReadFile(cfg.File)
+	if err != nil {
+		logger.FatalField("fault to load openapi document", logger.String("file", cfg.File), logger.Err(err))
+	}
+	contentType, path := "application/yaml", "/openapi.yaml"
+	if strings.EqualFold(filepath.Ext(cfg.File), ".json") {
+		contentType, path = "application/json", "/openapi.json"
+	}
+	if cfg.Path != "" {
+		path = cfg.Path
+	}
+	s.RawHandle(http.MethodGet, path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		http.ServeContent(w, r, filepath.Base(cfg.File), info.ModTime(), bytes.NewReader(data))
+	})
+}
@@ -1,13 +1,18 @@
 package rest
 
 import (
+	"context"
 	"net/http"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
 )
 
 // A HandlerFunc handles a specific pair of path pattern and HTTP method.
 type HandlerFunc func(w http.ResponseWriter, r *http.Request) (interface{}, error)
 
 type ServerInfo interface {
+	// GetScheme returns https if TLS is enabled, otherwise http.
+	GetScheme() string
 	GetAddress() string
 	GetAttributes() map[string]string
 }
@@ -15,8 +20,17 @@ type ServerInfo interface {
 type Server interface {
 	RpcHandle(method, path string, f HandlerFunc)
 	RawHandle(method, path string, h http.HandlerFunc)
+	// RpcStreamHandle registers the server streaming rpc, it is dispatched by the
+	// handle set by StreamHandle.
+	RpcStreamHandle(method, path, fullMethod string, dec StreamDecoder)
+	// RpcWebSocketHandle registers the client streaming or bidi streaming rpc
+	// over websocket, it is dispatched by the handle set by StreamHandle.
+	RpcWebSocketHandle(path, fullMethod string)
+	// StreamHandle sets the handle that dispatches the rpc carried over http,
+	// such as grpc-web, to the registered services.
+	StreamHandle(handle remote.MethodHandle)
 	Start() error
 	Serve() error
-	Stop() error
+	Stop(ctx context.Context) error
 	Info() ServerInfo
 }
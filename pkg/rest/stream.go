@@ -0,0 +1,329 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata/peer"
+	"github.com/imkuqin-zw/yggdrasil/pkg/rest/marshaler"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+const (
+	restStreamProtocol = "http"
+
+	sseContentType    = "text/event-stream"
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// StreamDecoder decodes the request message of a server streaming rpc from the
+// http request.
+type StreamDecoder func(r *http.Request, req interface{}) error
+
+// RpcStreamHandle registers the server streaming rpc fullMethod, the request is
+// decoded by dec and the rpc is dispatched by the handle set by StreamHandle.
+//
+// The messages are streamed as server-sent events if the request accepts
+// text/event-stream, otherwise as newline delimited json. Each message is a
+// "message" event or a {"result": message} line, and the stream ends with the
+// status of the rpc as a "status" event or a {"status": status} line. The
+// errors before the first message are written as the unary rpc errors.
+func (s *ServeMux) RpcStreamHandle(meth, path, fullMethod string, dec StreamDecoder) {
+	s.rpcRouter.MethodFunc(meth, path, func(w http.ResponseWriter, r *http.Request) {
+		if s.streamHandle == nil {
+			s.errorHandler(w, r, status.Errorf(code.Code_UNIMPLEMENTED, "the stream rpc is not supported"))
+			return
+		}
+		// The context is canceled when the client goes away, or the stream fails
+		// to write.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		md := s.extractInMetadata(r)
+		ctx = metadata.WithInContext(ctx, md)
+		ctx = peer.PeerWithContext(ctx, s.getPeer(r))
+		ctx, files := withFormContext(ctx, &s.svrCfg.Form)
+		defer files.remove()
+		ctx = s.statsHandler.TagRPC(ctx, &stats.RPCTagInfoBase{FullMethod: fullMethod})
+		s.statsHandler.HandleRPC(ctx, &stats.RPCServerInHeaderBase{
+			RPCInHeaderBase: stats.RPCInHeaderBase{
+				Header:   md,
+				Protocol: restStreamProtocol,
+			},
+			FullMethod:     fullMethod,
+			RemoteEndpoint: r.RemoteAddr,
+			LocalEndpoint:  s.info.address,
+		})
+		ss := &restStream{
+			mux:          s,
+			ctx:          ctx,
+			cancel:       cancel,
+			w:            w,
+			r:            r.WithContext(ctx),
+			method:       fullMethod,
+			dec:          dec,
+			sse:          acceptsEventStream(r),
+			outbound:     marshaler.OutboundFromContext(r.Context()),
+			statsHandler: s.statsHandler,
+		}
+		s.streamHandle(ss)
+	})
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	for _, item := range r.Header.Values("Accept") {
+		if strings.Contains(item, sseContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// restStream implements remote.ServerStream for the server streaming rpc over
+// http.
+type restStream struct {
+	mux          *ServeMux
+	ctx          context.Context
+	cancel       context.CancelFunc
+	w            http.ResponseWriter
+	r            *http.Request
+	method       string
+	dec          StreamDecoder
+	sse          bool
+	outbound     marshaler.Marshaler
+	statsHandler stats.Handler
+
+	mu          sync.Mutex
+	received    bool
+	header      metadata.MD
+	trailer     metadata.MD
+	wroteHeader bool
+
+	beginTime time.Time
+}
+
+func (ss *restStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *restStream) SetHeader(md metadata.MD) error {
+	if md.Len() == 0 {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.wroteHeader {
+		return status.Errorf(code.Code_INTERNAL, "rest: the header has been sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	return nil
+}
+
+func (ss *restStream) SendHeader(md metadata.MD) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.wroteHeader {
+		return status.Errorf(code.Code_INTERNAL, "rest: the header has been sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	ss.writeHeader()
+	return nil
+}
+
+func (ss *restStream) SetTrailer(md metadata.MD) {
+	if md.Len() == 0 {
+		return
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.trailer = metadata.Join(ss.trailer, md)
+}
+
+// writeHeader must be called with ss.mu held.
+func (ss *restStream) writeHeader() {
+	if ss.wroteHeader {
+		return
+	}
+	ss.wroteHeader = true
+	ss.mux.handleResponseHeader(ss.w, ss.header)
+	h := ss.w.Header()
+	if ss.sse {
+		h.Set("Content-Type", sseContentType)
+		h.Set("X-Accel-Buffering", "no")
+	} else {
+		h.Set("Content-Type", ndjsonContentType)
+	}
+	h.Set("Cache-Control", "no-cache")
+	ss.w.WriteHeader(http.StatusOK)
+	ss.flush()
+}
+
+func (ss *restStream) flush() {
+	if f, ok := ss.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeEvent must be called with ss.mu held.
+func (ss *restStream) writeEvent(event string, data []byte) error {
+	buf := &bytes.Buffer{}
+	if ss.sse {
+		if event != "message" {
+			buf.WriteString("event: " + event + "\n")
+		}
+		buf.WriteString("data: ")
+		buf.Write(data)
+		buf.WriteString("\n\n")
+	} else {
+		if event == "message" {
+			event = "result"
+		}
+		buf.WriteString(`{"` + event + `":`)
+		buf.Write(data)
+		buf.WriteString("}\n")
+	}
+	if _, err := ss.w.Write(buf.Bytes()); err != nil {
+		ss.cancel()
+		return err
+	}
+	ss.flush()
+	return nil
+}
+
+// marshal marshals the message as a single line json.
+func (ss *restStream) marshal(m interface{}) ([]byte, error) {
+	data, err := ss.outbound.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err = json.Compact(buf, data); err != nil {
+		return nil, fmt.Errorf("the stream message is not json: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (ss *restStream) SendMsg(m any) error {
+	if err := ss.ctx.Err(); err != nil {
+		return status.FromContextError(err)
+	}
+	data, err := ss.marshal(m)
+	if err != nil {
+		return status.New(code.Code_INTERNAL, err)
+	}
+	ss.mu.Lock()
+	ss.writeHeader()
+	err = ss.writeEvent("message", data)
+	ss.mu.Unlock()
+	if err != nil {
+		return status.New(code.Code_UNAVAILABLE, err)
+	}
+	ss.statsHandler.HandleRPC(ss.ctx, &stats.RPCOutPayloadBase{
+		Payload:       m,
+		Data:          data,
+		TransportSize: len(data),
+		SendTime:      time.Now(),
+		Protocol:      restStreamProtocol,
+	})
+	return nil
+}
+
+// RecvMsg decodes the request message once, then returns io.EOF.
+func (ss *restStream) RecvMsg(m any) error {
+	if ss.received {
+		return io.EOF
+	}
+	ss.received = true
+	if err := ss.dec(ss.r, m); err != nil {
+		return err
+	}
+	ss.statsHandler.HandleRPC(ss.ctx, &stats.RPCInPayloadBase{
+		Payload:  m,
+		RecvTime: time.Now(),
+		Protocol: restStreamProtocol,
+	})
+	return nil
+}
+
+func (ss *restStream) Method() string {
+	return ss.method
+}
+
+func (ss *restStream) Start(isClientStream, isServerStream bool) error {
+	if isClientStream || !isServerStream {
+		return status.Errorf(code.Code_UNIMPLEMENTED, "rest: only the server streaming rpc is supported")
+	}
+	begin := &stats.RPCBeginBase{
+		BeginTime:    time.Now(),
+		ClientStream: isClientStream,
+		ServerStream: isServerStream,
+		Protocol:     restStreamProtocol,
+	}
+	ss.statsHandler.HandleRPC(ss.ctx, begin)
+	ss.beginTime = begin.BeginTime
+	return nil
+}
+
+func (ss *restStream) Finish(_ any, err error) {
+	if !ss.beginTime.IsZero() {
+		defer func() {
+			ss.statsHandler.HandleRPC(ss.ctx, &stats.RPCEndBase{
+				BeginTime: ss.beginTime,
+				EndTime:   time.Now(),
+				Err:       err,
+				Protocol:  restStreamProtocol,
+			})
+		}()
+	}
+	var st *status.Status
+	if err != nil {
+		var ok bool
+		if st, ok = status.CoverError(err); !ok {
+			st = status.FromContextError(err)
+		}
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.mux.requestAcceptsTrailers(ss.r) {
+		// The trailers are declared by the prefix as the header may have been sent.
+		for k, vs := range ss.trailer {
+			h, _ := ss.mux.outgoingTrailerMatcher(k)
+			for _, v := range vs {
+				ss.w.Header().Add(http.TrailerPrefix+h, v)
+			}
+		}
+	}
+	if !ss.wroteHeader && st != nil {
+		// Nothing is streamed yet, respond the error as the unary rpc.
+		ss.wroteHeader = true
+		ss.mux.handleResponseHeader(ss.w, ss.header)
+		ss.mux.errorHandler(ss.w, ss.r, st)
+		return
+	}
+	ss.writeHeader()
+	pb := st.Status()
+	if pb == nil {
+		pb = &spb.Status{}
+	}
+	// The err of the rpc is reported by the deferred stats, it is not overwritten.
+	data, merr := ss.marshal(pb)
+	if merr != nil {
+		logger.ErrorField("rest: fault to marshal the stream status", logger.String("method", ss.method), logger.Err(merr))
+		data = []byte(`{"code":13,"message":"failed to marshal error message"}`)
+	}
+	if werr := ss.writeEvent("status", data); werr != nil {
+		logger.WarnField("rest: fault to write the stream status", logger.String("method", ss.method), logger.Err(werr))
+	}
+}
@@ -0,0 +1,79 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// recordStats records the end stats of the rpc.
+type recordStats struct {
+	mu  sync.Mutex
+	end []*stats.RPCEndBase
+}
+
+func (h *recordStats) TagRPC(ctx context.Context, _ stats.RPCTagInfo) context.Context { return ctx }
+
+func (h *recordStats) HandleRPC(_ context.Context, rs stats.RPCStats) {
+	if end, ok := rs.(*stats.RPCEndBase); ok {
+		h.mu.Lock()
+		h.end = append(h.end, end)
+		h.mu.Unlock()
+	}
+}
+
+func (h *recordStats) TagChannel(ctx context.Context, _ stats.ChanTagInfo) context.Context {
+	return ctx
+}
+
+func (h *recordStats) HandleChannel(context.Context, stats.ChanStats) {}
+
+func (h *recordStats) ends() []*stats.RPCEndBase {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*stats.RPCEndBase(nil), h.end...)
+}
+
+func TestRestStream_EndStats(t *testing.T) {
+	rpcErr := status.Errorf(code.Code_NOT_FOUND, "missing")
+	mux, ts := newTestServer(t, &Config{}, func(ss remote.ServerStream) {
+		if err := ss.Start(false, true); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		if ss.Method() == "/test/Fail" {
+			_ = ss.SendMsg(wrapperspb.String("a"))
+			ss.Finish(nil, rpcErr)
+			return
+		}
+		ss.Finish(nil, nil)
+	})
+	handler := &recordStats{}
+	mux.statsHandler = handler
+	dec := func(r *http.Request, req interface{}) error { return nil }
+	mux.RpcStreamHandle(http.MethodGet, "/v1/fail", "/test/Fail", dec)
+	mux.RpcStreamHandle(http.MethodGet, "/v1/ok", "/test/Ok", dec)
+
+	for _, path := range []string{"/v1/fail", "/v1/ok"} {
+		resp, body := doRequest(t, http.MethodGet, ts.URL+path, nil, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, string(body), `"status"`)
+	}
+
+	ends := handler.ends()
+	require.Len(t, ends, 2)
+	// The end stats report the error of the rpc, not the result of writing the
+	// status of the stream.
+	assert.Equal(t, rpcErr, ends[0].Err)
+	assert.NoError(t, ends[1].Err)
+	assert.Equal(t, restStreamProtocol, ends[0].Protocol)
+}
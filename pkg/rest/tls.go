@@ -0,0 +1,133 @@
+package rest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+)
+
+// TLSConfig configures the https of the rest server, the http/2 is negotiated
+// by ALPN.
+type TLSConfig struct {
+	Enable   bool
+	CertFile string
+	KeyFile  string
+	// ReloadInterval is the interval to check the modification of the cert and
+	// key files, the files are reloaded without restarting the server.
+	ReloadInterval time.Duration `default:"10s"`
+	// ClientAuth is the policy of the client certificate, one of none, request,
+	// require, verify_if_given and require_and_verify.
+	ClientAuth string
+	// ClientCAFile is the CA certificates to verify the client certificates.
+	ClientCAFile string
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+func (c *TLSConfig) serverTLSConfig() (*tls.Config, error) {
+	clientAuth, ok := clientAuthTypes[c.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unknown client auth %q", c.ClientAuth)
+	}
+	reloader := &certReloader{certFile: c.CertFile, keyFile: c.KeyFile, interval: c.ReloadInterval}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+		ClientAuth:     clientAuth,
+	}
+	if c.ClientCAFile != "" {
+		data, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", c.ClientCAFile)
+		}
+	}
+	return cfg, nil
+}
+
+// certReloader reloads the certificate when the cert or key file is modified.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkTime time.Time
+}
+
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.checkTime = time.Now()
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	cert := r.cert
+	check := r.interval > 0 && time.Since(r.checkTime) >= r.interval
+	if check {
+		r.checkTime = time.Now()
+	}
+	modTime := r.modTime
+	r.mu.Unlock()
+	if !check {
+		return cert, nil
+	}
+	if t, err := r.filesModTime(); err != nil || !t.After(modTime) {
+		return cert, nil
+	}
+	if err := r.load(); err != nil {
+		// The certificate being written may be incomplete, keep the old one.
+		logger.WarnField("fault to reload the rest server certificate", logger.Err(err))
+		return cert, nil
+	}
+	logger.InfoField("the rest server certificate reloaded", logger.String("cert", r.certFile))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
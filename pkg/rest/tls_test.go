@@ -0,0 +1,222 @@
+package rest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCert issues the certificate of cn signed by parent, it is self-signed
+// if parent is nil.
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.pem, c.keyPEM(t))
+	require.NoError(t, err)
+	return cert
+}
+
+// writeTestCert writes the cert and key files of c with the modification time.
+func writeTestCert(t *testing.T, certFile, keyFile string, c *testCert, modTime time.Time) {
+	require.NoError(t, os.WriteFile(certFile, c.pem, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, c.keyPEM(t), 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func servingCN(t *testing.T, r *certReloader) string {
+	cert, err := r.getCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca := newTestCert(t, "ca", nil)
+	now := time.Now()
+	writeTestCert(t, certFile, keyFile, newTestCert(t, "first", ca), now.Add(-time.Minute))
+
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: 10 * time.Millisecond}
+	require.NoError(t, r.load())
+	assert.Equal(t, "first", servingCN(t, r))
+
+	writeTestCert(t, certFile, keyFile, newTestCert(t, "second", ca), now)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, "second", servingCN(t, r))
+
+	// The incomplete files are not loaded, the old certificate is kept.
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0o600))
+	require.NoError(t, os.Chtimes(certFile, now.Add(time.Minute), now.Add(time.Minute)))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, "second", servingCN(t, r))
+
+	// The files are not checked before the interval.
+	r.interval = time.Hour
+	writeTestCert(t, certFile, keyFile, newTestCert(t, "third", ca), now.Add(2*time.Minute))
+	assert.Equal(t, "second", servingCN(t, r))
+}
+
+func TestTLSConfig_ClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca := newTestCert(t, "ca", nil)
+	writeTestCert(t, certFile, keyFile, newTestCert(t, "server", ca), time.Now())
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+
+	for name, want := range clientAuthTypes {
+		cfg, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: name}).serverTLSConfig()
+		require.NoError(t, err)
+		assert.Equal(t, want, cfg.ClientAuth, name)
+		assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	}
+
+	_, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "always"}).serverTLSConfig()
+	assert.Error(t, err)
+	_, err = (&TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile}).serverTLSConfig()
+	assert.Error(t, err)
+	_, err = (&TLSConfig{CertFile: certFile, KeyFile: filepath.Join(dir, "none.key")}).serverTLSConfig()
+	assert.Error(t, err)
+
+	cfg, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}).serverTLSConfig()
+	require.NoError(t, err)
+	require.NotNil(t, cfg.ClientCAs)
+}
+
+// startMux starts the mux of cfg serving /ok, it is stopped when the test ends.
+func startMux(t *testing.T, cfg *Config) *ServeMux {
+	cfg.Host = "127.0.0.1"
+	mux := newServeMux(cfg)
+	mux.RawHandle(http.MethodGet, "/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	require.NoError(t, mux.Start())
+	go func() { _ = mux.Serve() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = mux.Stop(ctx)
+	})
+	return mux
+}
+
+func TestServeMux_TLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca := newTestCert(t, "ca", nil)
+	writeTestCert(t, certFile, keyFile, newTestCert(t, "server", ca), time.Now())
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+
+	mux := startMux(t, &Config{TLS: TLSConfig{
+		Enable:       true,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientAuth:   "require_and_verify",
+		ClientCAFile: caFile,
+	}})
+	assert.Equal(t, "https", mux.Info().GetScheme())
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		cli := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			ForceAttemptHTTP2: true,
+		}}
+		defer cli.CloseIdleConnections()
+		return cli.Get("https://" + mux.Info().GetAddress() + "/ok")
+	}
+
+	_, err := get()
+	assert.Error(t, err)
+	_, err = get(newTestCert(t, "stranger", newTestCert(t, "other-ca", nil)).tlsCertificate(t))
+	assert.Error(t, err)
+
+	resp, err := get(newTestCert(t, "client", ca).tlsCertificate(t))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// The http/2 is negotiated by ALPN.
+	assert.Equal(t, 2, resp.ProtoMajor)
+}
+
+func TestServeMux_H2C(t *testing.T) {
+	mux := startMux(t, &Config{H2C: true})
+	url := "http://" + mux.Info().GetAddress() + "/ok"
+
+	h2c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := h2c.Get(url)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+
+	// The http/1 is still served.
+	resp, err = http.Get(url)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, resp.ProtoMajor)
+}
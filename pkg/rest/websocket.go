@@ -0,0 +1,450 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/imkuqin-zw/yggdrasil/pkg/logger"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata/peer"
+	"github.com/imkuqin-zw/yggdrasil/pkg/rest/marshaler"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+const (
+	webSocketProtocol = "websocket"
+
+	defaultWebSocketMaxReceiveMessageSize = 1024 * 1024 * 4
+
+	// webSocketCloseCodeBase is added to the status code as the close code of the
+	// failed rpc, the close codes 4000-4999 are for the private use.
+	webSocketCloseCodeBase = 4000
+	// maxCloseReasonSize is the max size of the close reason, the payload of the
+	// control frame is limited to 125 bytes including the close code.
+	maxCloseReasonSize    = 123
+	webSocketCloseTimeout = time.Second
+)
+
+// WebSocketConfig configures the streaming rpc served over websocket.
+type WebSocketConfig struct {
+	// AllowedOrigins are the origins allowed to upgrade, * allows all origins.
+	// Only the same origin is allowed if empty.
+	AllowedOrigins        []string
+	MaxReceiveMessageSize int
+}
+
+func newWebSocketUpgrader(cfg WebSocketConfig) *websocket.Upgrader {
+	upgrader := &websocket.Upgrader{}
+	if len(cfg.AllowedOrigins) == 0 {
+		return upgrader
+	}
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, item := range cfg.AllowedOrigins {
+			if item == "*" || strings.EqualFold(item, origin) {
+				return true
+			}
+		}
+		return false
+	}
+	return upgrader
+}
+
+// RpcWebSocketHandle registers the client streaming or bidi streaming rpc
+// fullMethod, the request is upgraded to websocket and the rpc is dispatched by
+// the handle set by StreamHandle.
+//
+// Each frame is a message marshaled by the marshaler of the request, the json
+// messages are sent as text frames and others as binary frames. The client ends
+// its stream by the normal closure, other close codes cancel the rpc. The server
+// closes the connection with the normal closure if the rpc succeeds, otherwise
+// with 4000 plus the status code and the status message as the reason. The
+// errors before the upgrade are written as the unary rpc errors.
+func (s *ServeMux) RpcWebSocketHandle(path, fullMethod string) {
+	s.rpcRouter.Get(path, func(w http.ResponseWriter, r *http.Request) {
+		if s.streamHandle == nil {
+			s.errorHandler(w, r, status.Errorf(code.Code_UNIMPLEMENTED, "the stream rpc is not supported"))
+			return
+		}
+		if !websocket.IsWebSocketUpgrade(r) {
+			s.errorHandler(w, r, status.Errorf(code.Code_INVALID_ARGUMENT, "the websocket upgrade is required"))
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		md := s.extractInMetadata(r)
+		ctx = metadata.WithInContext(ctx, md)
+		ctx = peer.PeerWithContext(ctx, s.getPeer(r))
+		ctx = s.statsHandler.TagRPC(ctx, &stats.RPCTagInfoBase{FullMethod: fullMethod})
+		s.statsHandler.HandleRPC(ctx, &stats.RPCServerInHeaderBase{
+			RPCInHeaderBase: stats.RPCInHeaderBase{
+				Header:   md,
+				Protocol: webSocketProtocol,
+			},
+			FullMethod:     fullMethod,
+			RemoteEndpoint: r.RemoteAddr,
+			LocalEndpoint:  s.info.address,
+		})
+		ss := &wsStream{
+			mux:          s,
+			ctx:          ctx,
+			cancel:       cancel,
+			w:            w,
+			r:            r.WithContext(ctx),
+			method:       fullMethod,
+			inbound:      marshaler.InboundFromContext(r.Context()),
+			outbound:     marshaler.OutboundFromContext(r.Context()),
+			statsHandler: s.statsHandler,
+			frames:       make(chan wsFrame),
+		}
+		s.addWebSocket(ss)
+		defer s.removeWebSocket(ss)
+		s.streamHandle(ss)
+	})
+}
+
+func (s *ServeMux) addWebSocket(ss *wsStream) {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	if s.wsStreams == nil {
+		s.wsStreams = map[*wsStream]struct{}{}
+	}
+	s.wsStreams[ss] = struct{}{}
+	s.wsWG.Add(1)
+}
+
+func (s *ServeMux) removeWebSocket(ss *wsStream) {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	delete(s.wsStreams, ss)
+	s.wsWG.Done()
+}
+
+// drainWebSockets waits for the websocket streams to finish, the streams still
+// active when ctx is done are closed with going away. The hijacked connections
+// are not tracked by the http server.
+func (s *ServeMux) drainWebSockets(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.wsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	for ss := range s.wsStreams {
+		ss.abort()
+	}
+}
+
+type wsFrame struct {
+	data []byte
+	err  error
+}
+
+// wsStream implements remote.ServerStream for the streaming rpc over websocket.
+// The request is upgraded by the first call that touches the connection, so the
+// header set before can be sent with the upgrade response.
+type wsStream struct {
+	mux          *ServeMux
+	ctx          context.Context
+	cancel       context.CancelFunc
+	w            http.ResponseWriter
+	r            *http.Request
+	method       string
+	inbound      marshaler.Marshaler
+	outbound     marshaler.Marshaler
+	statsHandler stats.Handler
+
+	// frames delivers the frames read by readLoop to RecvMsg.
+	frames      chan wsFrame
+	recvErr     error
+	closeStatus atomic.Pointer[status.Status]
+
+	mu         sync.Mutex
+	conn       atomic.Pointer[websocket.Conn]
+	upgradeErr error
+	header     metadata.MD
+
+	beginTime time.Time
+}
+
+func (ss *wsStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *wsStream) SetHeader(md metadata.MD) error {
+	if md.Len() == 0 {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.conn.Load() != nil {
+		return status.Errorf(code.Code_INTERNAL, "rest: the header has been sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	return nil
+}
+
+func (ss *wsStream) SendHeader(md metadata.MD) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.conn.Load() != nil {
+		return status.Errorf(code.Code_INTERNAL, "rest: the header has been sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	return ss.upgrade()
+}
+
+// SetTrailer drops the trailer, websocket has no trailer.
+func (ss *wsStream) SetTrailer(metadata.MD) {}
+
+// upgrade must be called with ss.mu held.
+func (ss *wsStream) upgrade() error {
+	if ss.conn.Load() != nil {
+		return nil
+	}
+	if ss.upgradeErr != nil {
+		return ss.upgradeErr
+	}
+	ss.mux.handleResponseHeader(ss.w, ss.header)
+	conn, err := ss.mux.wsUpgrader.Upgrade(ss.w, ss.r, ss.w.Header())
+	if err != nil {
+		// The upgrader has responded the error.
+		ss.upgradeErr = status.New(code.Code_INVALID_ARGUMENT, err)
+		ss.cancel()
+		return ss.upgradeErr
+	}
+	conn.SetReadLimit(int64(ss.mux.webSocket.MaxReceiveMessageSize))
+	// The close frame of the client only ends the client stream, the server
+	// replies it with the status of the rpc.
+	conn.SetCloseHandler(func(int, string) error { return nil })
+	ss.conn.Store(conn)
+	go ss.readLoop(conn)
+	return nil
+}
+
+func (ss *wsStream) readLoop(conn *websocket.Conn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if err = ss.readError(err); err != io.EOF {
+				// The rpc is canceled, RecvMsg returns the close status instead of
+				// the context error.
+				ss.closeStatus.Store(err.(*status.Status))
+				ss.cancel()
+				return
+			}
+		}
+		select {
+		case ss.frames <- wsFrame{data: data, err: err}:
+		case <-ss.ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readError converts the error of reading the frames, the normal closure ends
+// the client stream and others are the status that cancels the rpc.
+func (ss *wsStream) readError(err error) error {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) && ce.Code == websocket.CloseNormalClosure {
+		return io.EOF
+	}
+	if ce != nil {
+		return webSocketCloseStatus(ce)
+	}
+	if errors.Is(err, websocket.ErrReadLimit) {
+		return status.New(code.Code_RESOURCE_EXHAUSTED, err)
+	}
+	return status.New(code.Code_CANCELLED, err)
+}
+
+// webSocketCloseStatus converts the close code of the client to the status.
+func webSocketCloseStatus(ce *websocket.CloseError) *status.Status {
+	if c := ce.Code - webSocketCloseCodeBase; c > 0 && c <= int(code.Code_UNAUTHENTICATED) {
+		return status.New(code.Code(c), errors.New(ce.Text))
+	}
+	switch ce.Code {
+	case websocket.CloseGoingAway, websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure:
+		return status.New(code.Code_CANCELLED, ce)
+	case websocket.CloseMessageTooBig:
+		return status.New(code.Code_RESOURCE_EXHAUSTED, ce)
+	case websocket.ClosePolicyViolation:
+		return status.New(code.Code_PERMISSION_DENIED, ce)
+	case websocket.CloseProtocolError, websocket.CloseUnsupportedData, websocket.CloseInvalidFramePayloadData:
+		return status.New(code.Code_INVALID_ARGUMENT, ce)
+	default:
+		return status.New(code.Code_UNKNOWN, ce)
+	}
+}
+
+// webSocketCloseMessage returns the close frame of the status.
+func webSocketCloseMessage(st *status.Status) []byte {
+	if st == nil || st.IsCode(code.Code_OK) {
+		return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	}
+	reason := st.Message()
+	if len(reason) > maxCloseReasonSize {
+		reason = strings.ToValidUTF8(reason[:maxCloseReasonSize], "")
+	}
+	return websocket.FormatCloseMessage(webSocketCloseCodeBase+int(st.Code()), reason)
+}
+
+func (ss *wsStream) SendMsg(m any) error {
+	if err := ss.ctx.Err(); err != nil {
+		return status.FromContextError(err)
+	}
+	data, err := ss.outbound.Marshal(m)
+	if err != nil {
+		return status.New(code.Code_INTERNAL, err)
+	}
+	frameType := websocket.BinaryMessage
+	if strings.Contains(ss.outbound.ContentType(m), "json") {
+		frameType = websocket.TextMessage
+	}
+	ss.mu.Lock()
+	if err = ss.upgrade(); err == nil {
+		if err = ss.conn.Load().WriteMessage(frameType, data); err != nil {
+			ss.cancel()
+			err = status.New(code.Code_UNAVAILABLE, err)
+		}
+	}
+	ss.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	ss.statsHandler.HandleRPC(ss.ctx, &stats.RPCOutPayloadBase{
+		Payload:       m,
+		Data:          data,
+		TransportSize: len(data),
+		SendTime:      time.Now(),
+		Protocol:      webSocketProtocol,
+	})
+	return nil
+}
+
+func (ss *wsStream) RecvMsg(m any) error {
+	if ss.recvErr != nil {
+		return ss.recvErr
+	}
+	ss.mu.Lock()
+	err := ss.upgrade()
+	ss.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	var frame wsFrame
+	select {
+	case frame = <-ss.frames:
+	case <-ss.ctx.Done():
+		if st := ss.closeStatus.Load(); st != nil {
+			return st
+		}
+		return status.FromContextError(ss.ctx.Err())
+	}
+	if frame.err != nil {
+		ss.recvErr = frame.err
+		return frame.err
+	}
+	if err = ss.inbound.Unmarshal(frame.data, m); err != nil {
+		return status.New(code.Code_INVALID_ARGUMENT, err)
+	}
+	ss.statsHandler.HandleRPC(ss.ctx, &stats.RPCInPayloadBase{
+		Payload:       m,
+		Data:          frame.data,
+		TransportSize: len(frame.data),
+		RecvTime:      time.Now(),
+		Protocol:      webSocketProtocol,
+	})
+	return nil
+}
+
+func (ss *wsStream) Method() string {
+	return ss.method
+}
+
+func (ss *wsStream) Start(isClientStream, isServerStream bool) error {
+	if !isClientStream && !isServerStream {
+		return status.Errorf(code.Code_UNIMPLEMENTED, "rest: the unary rpc is not supported over websocket")
+	}
+	begin := &stats.RPCBeginBase{
+		BeginTime:    time.Now(),
+		ClientStream: isClientStream,
+		ServerStream: isServerStream,
+		Protocol:     webSocketProtocol,
+	}
+	ss.statsHandler.HandleRPC(ss.ctx, begin)
+	ss.beginTime = begin.BeginTime
+	return nil
+}
+
+func (ss *wsStream) Finish(_ any, err error) {
+	if !ss.beginTime.IsZero() {
+		defer func() {
+			ss.statsHandler.HandleRPC(ss.ctx, &stats.RPCEndBase{
+				BeginTime: ss.beginTime,
+				EndTime:   time.Now(),
+				Err:       err,
+				Protocol:  webSocketProtocol,
+			})
+		}()
+	}
+	var st *status.Status
+	if err != nil {
+		var ok bool
+		if st, ok = status.CoverError(err); !ok {
+			st = status.FromContextError(err)
+		}
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.conn.Load() == nil && ss.upgradeErr == nil && st != nil {
+		// Nothing is upgraded yet, respond the error as the unary rpc.
+		ss.upgradeErr = st
+		ss.mux.handleResponseHeader(ss.w, ss.header)
+		ss.mux.errorHandler(ss.w, ss.r, st)
+		return
+	}
+	if ss.upgrade() != nil {
+		return
+	}
+	conn := ss.conn.Load()
+	deadline := time.Now().Add(webSocketCloseTimeout)
+	if err := conn.WriteControl(websocket.CloseMessage, webSocketCloseMessage(st), deadline); err != nil &&
+		ss.ctx.Err() == nil {
+		logger.WarnField("rest: fault to write the websocket close", logger.String("method", ss.method), logger.Err(err))
+	}
+	_ = conn.Close()
+}
+
+// abort closes the connection with going away and cancels the rpc.
+func (ss *wsStream) abort() {
+	ss.cancel()
+	if conn := ss.conn.Load(); conn != nil {
+		deadline := time.Now().Add(webSocketCloseTimeout)
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is stopping"), deadline)
+		_ = conn.Close()
+	}
+}
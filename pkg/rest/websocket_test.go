@@ -0,0 +1,249 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/remote"
+	"github.com/imkuqin-zw/yggdrasil/pkg/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// wsTestHandle echoes the frames of /test/Echo, replies the concatenation of the
+// frames of /test/Concat after the client stream ends and fails /test/Fail after
+// the first frame. The error of RecvMsg that ends the rpc is sent to errs.
+func wsTestHandle(errs chan<- error) remote.MethodHandle {
+	return func(ss remote.ServerStream) {
+		if err := ss.Start(true, true); err != nil {
+			ss.Finish(nil, err)
+			return
+		}
+		var values []string
+		for {
+			req := &wrapperspb.StringValue{}
+			err := ss.RecvMsg(req)
+			if err == io.EOF && ss.Method() == "/test/Concat" {
+				err = ss.SendMsg(wrapperspb.String(strings.Join(values, "")))
+				ss.Finish(nil, err)
+				return
+			}
+			if err != nil {
+				errs <- err
+				ss.Finish(nil, err)
+				return
+			}
+			switch ss.Method() {
+			case "/test/Echo":
+				if err = ss.SendMsg(req); err != nil {
+					ss.Finish(nil, err)
+					return
+				}
+			case "/test/Concat":
+				values = append(values, req.Value)
+			default:
+				ss.Finish(nil, status.Errorf(code.Code_NOT_FOUND, "missing "+req.Value))
+				return
+			}
+		}
+	}
+}
+
+func newWebSocketServer(t *testing.T, cfg WebSocketConfig) (*ServeMux, string, chan error) {
+	errs := make(chan error, 1)
+	mux, ts := newTestServer(t, &Config{WebSocket: cfg}, wsTestHandle(errs))
+	for _, method := range []string{"Echo", "Concat", "Fail"} {
+		mux.RpcWebSocketHandle("/ws/"+strings.ToLower(method), "/test/"+method)
+	}
+	return mux, "ws" + strings.TrimPrefix(ts.URL, "http"), errs
+}
+
+func dialWebSocket(t *testing.T, url string, header http.Header) *websocket.Conn {
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// readClose reads the frames until the close frame of the server.
+func readClose(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var ce *websocket.CloseError
+			require.True(t, errors.As(err, &ce), "unexpected error %v", err)
+			return ce
+		}
+	}
+}
+
+func recvRpcErr(t *testing.T, errs <-chan error) error {
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rpc is not ended")
+		return nil
+	}
+}
+
+func TestWebSocket_Upgrade(t *testing.T) {
+	_, url, _ := newWebSocketServer(t, WebSocketConfig{AllowedOrigins: []string{"http://app.example.com"}})
+	httpURL := "http" + strings.TrimPrefix(url, "ws")
+
+	resp, _ := doRequest(t, http.MethodGet, httpURL+"/ws/echo", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	conn := dialWebSocket(t, url+"/ws/echo", http.Header{"Origin": []string{"http://app.example.com"}})
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`"ping"`)))
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.JSONEq(t, `"ping"`, string(data))
+
+	_, resp, err = websocket.DefaultDialer.Dial(url+"/ws/echo", http.Header{"Origin": []string{"http://evil.example.com"}})
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestWebSocket_TextFrames(t *testing.T) {
+	_, url, _ := newWebSocketServer(t, WebSocketConfig{})
+	conn := dialWebSocket(t, url+"/ws/echo", nil)
+	for _, item := range []string{"a", "b"} {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`"`+item+`"`)))
+		frameType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.TextMessage, frameType)
+		assert.JSONEq(t, `"`+item+`"`, string(data))
+	}
+}
+
+func TestWebSocket_BinaryFrames(t *testing.T) {
+	require.NoError(t, config.Set(config.KeyRestMarshalerSupport, "jsonpb,proto"))
+	t.Cleanup(func() { _ = config.Set(config.KeyRestMarshalerSupport, "jsonpb") })
+	_, url, _ := newWebSocketServer(t, WebSocketConfig{})
+	conn := dialWebSocket(t, url+"/ws/echo", http.Header{"Content-Type": []string{"proto"}})
+
+	data, err := proto.Marshal(wrapperspb.String("binary"))
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, data))
+	frameType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.BinaryMessage, frameType)
+	reply := &wrapperspb.StringValue{}
+	require.NoError(t, proto.Unmarshal(data, reply))
+	assert.Equal(t, "binary", reply.Value)
+}
+
+func TestWebSocket_HalfClose(t *testing.T) {
+	_, url, _ := newWebSocketServer(t, WebSocketConfig{})
+	conn := dialWebSocket(t, url+"/ws/concat", nil)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`"a"`)))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`"b"`)))
+	// The normal closure only ends the client stream, the reply is still sent.
+	require.NoError(t, conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.JSONEq(t, `"ab"`, string(data))
+	assert.Equal(t, websocket.CloseNormalClosure, readClose(t, conn).Code)
+}
+
+func TestWebSocket_CloseStatus(t *testing.T) {
+	t.Run("server", func(t *testing.T) {
+		_, url, _ := newWebSocketServer(t, WebSocketConfig{})
+		conn := dialWebSocket(t, url+"/ws/fail", nil)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`"book"`)))
+		ce := readClose(t, conn)
+		assert.Equal(t, webSocketCloseCodeBase+int(code.Code_NOT_FOUND), ce.Code)
+		assert.Equal(t, "missing book", ce.Text)
+	})
+
+	t.Run("client", func(t *testing.T) {
+		_, url, errs := newWebSocketServer(t, WebSocketConfig{})
+		conn := dialWebSocket(t, url+"/ws/echo", nil)
+		require.NoError(t, conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(webSocketCloseCodeBase+int(code.Code_ABORTED), "client aborted")))
+		st, ok := status.CoverError(recvRpcErr(t, errs))
+		require.True(t, ok)
+		assert.True(t, st.IsCode(code.Code_ABORTED))
+		assert.Equal(t, "client aborted", st.Message())
+	})
+
+	t.Run("reason size", func(t *testing.T) {
+		msg := webSocketCloseMessage(status.Errorf(code.Code_INTERNAL, strings.Repeat("x", 200)))
+		assert.LessOrEqual(t, len(msg), 125)
+		assert.Equal(t, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), webSocketCloseMessage(nil))
+	})
+}
+
+func TestWebSocket_ReadLimit(t *testing.T) {
+	_, url, errs := newWebSocketServer(t, WebSocketConfig{MaxReceiveMessageSize: 16})
+	conn := dialWebSocket(t, url+"/ws/echo", nil)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`"`+strings.Repeat("x", 64)+`"`)))
+
+	st, ok := status.CoverError(recvRpcErr(t, errs))
+	require.True(t, ok)
+	assert.True(t, st.IsCode(code.Code_RESOURCE_EXHAUSTED))
+	ce := readClose(t, conn)
+	assert.Contains(t, []int{websocket.CloseMessageTooBig, webSocketCloseCodeBase + int(code.Code_RESOURCE_EXHAUSTED)}, ce.Code)
+}
+
+func TestWebSocket_DrainAbort(t *testing.T) {
+	mux, url, errs := newWebSocketServer(t, WebSocketConfig{})
+	conn := dialWebSocket(t, url+"/ws/echo", nil)
+	// The round trip makes sure the stream is upgraded and tracked.
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`"ping"`)))
+	_, _, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	mux.drainWebSockets(ctx)
+	assert.GreaterOrEqual(t, time.Since(begin), 50*time.Millisecond)
+
+	ce := readClose(t, conn)
+	assert.Equal(t, websocket.CloseGoingAway, ce.Code)
+	st, ok := status.CoverError(recvRpcErr(t, errs))
+	require.True(t, ok)
+	assert.True(t, st.IsCode(code.Code_CANCELLED))
+
+	done := make(chan struct{})
+	go func() {
+		mux.wsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the websocket stream is not removed")
+	}
+}
+
+func TestWebSocket_DrainGraceful(t *testing.T) {
+	mux, url, _ := newWebSocketServer(t, WebSocketConfig{})
+	conn := dialWebSocket(t, url+"/ws/concat", nil)
+	require.NoError(t, conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	assert.Equal(t, websocket.CloseNormalClosure, readClose(t, conn).Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mux.drainWebSockets(ctx)
+	assert.NoError(t, ctx.Err())
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/interceptor"
+)
+
+// intChain is the interceptor chain of a protocol server.
+type intChain struct {
+	unary  interceptor.UnaryServerInterceptor
+	stream interceptor.StreamServerInterceptor
+}
+
+// serviceInterceptors builds the interceptors configured for a service and its
+// methods. They are chained after the interceptors of the protocol server, the
+// service ones first.
+type serviceInterceptors struct {
+	serviceName  string
+	unary        interceptor.UnaryServerInterceptor
+	stream       interceptor.StreamServerInterceptor
+	methodUnary  map[string]interceptor.UnaryServerInterceptor
+	methodStream map[string]interceptor.StreamServerInterceptor
+}
+
+// serviceInterceptors returns the interceptors of the service, they are built
+// once and shared by the rpc and rest handlers. It must be called with s.mu held.
+func (s *server) serviceInterceptors(serviceName string) *serviceInterceptors {
+	if ints, ok := s.svcInts[serviceName]; ok {
+		return ints
+	}
+	ints := &serviceInterceptors{
+		serviceName:  serviceName,
+		methodUnary:  map[string]interceptor.UnaryServerInterceptor{},
+		methodStream: map[string]interceptor.StreamServerInterceptor{},
+	}
+	if names := splitIntNames(config.Get(fmt.Sprintf(config.KeyServerServiceUnaryInt, serviceName)).String()); len(names) > 0 {
+		ints.unary = interceptor.ChainUnaryServerInterceptors(names)
+	}
+	if names := splitIntNames(config.Get(fmt.Sprintf(config.KeyServerServiceStreamInt, serviceName)).String()); len(names) > 0 {
+		ints.stream = interceptor.ChainStreamServerInterceptors(names)
+	}
+	s.svcInts[serviceName] = ints
+	return ints
+}
+
+// unaryInt returns the service and method interceptors of the unary method, it
+// returns nil if neither is configured.
+func (si *serviceInterceptors) unaryInt(methodName string) interceptor.UnaryServerInterceptor {
+	if item, ok := si.methodUnary[methodName]; ok {
+		return item
+	}
+	var methodInt interceptor.UnaryServerInterceptor
+	key := fmt.Sprintf(config.KeyServerMethodUnaryInt, si.serviceName, methodName)
+	if names := splitIntNames(config.Get(key).String()); len(names) > 0 {
+		methodInt = interceptor.ChainUnaryServerInterceptors(names)
+	}
+	var res interceptor.UnaryServerInterceptor
+	if si.unary != nil || methodInt != nil {
+		res = interceptor.ChainUnaryServer(si.unary, methodInt)
+	}
+	si.methodUnary[methodName] = res
+	return res
+}
+
+// streamInt returns the service and method interceptors of the stream method, it
+// returns nil if neither is configured.
+func (si *serviceInterceptors) streamInt(methodName string) interceptor.StreamServerInterceptor {
+	if item, ok := si.methodStream[methodName]; ok {
+		return item
+	}
+	var methodInt interceptor.StreamServerInterceptor
+	key := fmt.Sprintf(config.KeyServerMethodStreamInt, si.serviceName, methodName)
+	if names := splitIntNames(config.Get(key).String()); len(names) > 0 {
+		methodInt = interceptor.ChainStreamServerInterceptors(names)
+	}
+	var res interceptor.StreamServerInterceptor
+	if si.stream != nil || methodInt != nil {
+		res = interceptor.ChainStreamServer(si.stream, methodInt)
+	}
+	si.methodStream[methodName] = res
+	return res
+}
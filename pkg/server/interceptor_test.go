@@ -0,0 +1,148 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/config"
+	"github.com/imkuqin-zw/yggdrasil/pkg/interceptor"
+	"github.com/imkuqin-zw/yggdrasil/pkg/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registerRecordInts registers the unary and stream server interceptors of the
+// names, each of them records its name when it is called.
+func registerRecordInts(calls *[]string, names ...string) {
+	for _, name := range names {
+		name := name
+		interceptor.RegisterUnaryServerIntBuilder(name, func() interceptor.UnaryServerInterceptor {
+			return func(ctx context.Context, req interface{}, info *interceptor.UnaryServerInfo, handler interceptor.UnaryHandler) (interface{}, error) {
+				*calls = append(*calls, name)
+				return handler(ctx, req)
+			}
+		})
+		interceptor.RegisterStreamServerIntBuilder(name, func() interceptor.StreamServerInterceptor {
+			return func(srv interface{}, ss stream.ServerStream, info *interceptor.StreamServerInfo, handler stream.StreamHandler) error {
+				*calls = append(*calls, name)
+				return handler(srv, ss)
+			}
+		})
+	}
+}
+
+// setTestConfig sets the config of the key, it is cleared when the test ends.
+func setTestConfig(t *testing.T, key string, val interface{}) {
+	require.NoError(t, config.Set(key, val))
+	t.Cleanup(func() { _ = config.Set(key, "") })
+}
+
+func newInterceptorServer() *server {
+	s := &server{
+		services: map[string]*ServiceInfo{},
+		svcInts:  map[string]*serviceInterceptors{},
+	}
+	s.initInterceptor()
+	return s
+}
+
+func TestServer_InterceptorOrder(t *testing.T) {
+	var calls []string
+	registerRecordInts(&calls, "order-global", "order-service", "order-method")
+	const serviceName = "test.OrderService"
+	setTestConfig(t, config.KeyIntUnaryServe, "order-global")
+	setTestConfig(t, config.KeyIntStreamServer, "order-global")
+	setTestConfig(t, fmt.Sprintf(config.KeyServerServiceUnaryInt, serviceName), "order-service")
+	setTestConfig(t, fmt.Sprintf(config.KeyServerServiceStreamInt, serviceName), "order-service")
+	setTestConfig(t, fmt.Sprintf(config.KeyServerMethodUnaryInt, serviceName, "Unary"), "order-method")
+	setTestConfig(t, fmt.Sprintf(config.KeyServerMethodStreamInt, serviceName, "Stream"), "order-method")
+
+	s := newInterceptorServer()
+	s.registerServiceInfo(&ServiceDesc{
+		ServiceName: serviceName,
+		Methods:     []MethodDesc{{MethodName: "Unary"}, {MethodName: "Other"}},
+		Streams:     []stream.StreamDesc{{StreamName: "Stream"}},
+	}, nil)
+	info := s.services[serviceName]
+
+	t.Run("unary", func(t *testing.T) {
+		calls = nil
+		require.Len(t, info.unaryInts["Unary"], 1)
+		reply, err := info.unaryInts["Unary"][0](context.Background(), "req", &interceptor.UnaryServerInfo{},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				calls = append(calls, "handler")
+				return req, nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, "req", reply)
+		assert.Equal(t, []string{"order-global", "order-service", "order-method", "handler"}, calls)
+	})
+
+	t.Run("service only", func(t *testing.T) {
+		calls = nil
+		require.Len(t, info.unaryInts["Other"], 1)
+		_, err := info.unaryInts["Other"][0](context.Background(), "req", &interceptor.UnaryServerInfo{},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				calls = append(calls, "handler")
+				return req, nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"order-global", "order-service", "handler"}, calls)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		calls = nil
+		require.Len(t, info.streamInts["Stream"], 1)
+		err := info.streamInts["Stream"][0](nil, nil, &interceptor.StreamServerInfo{},
+			func(srv interface{}, ss stream.ServerStream) error {
+				calls = append(calls, "handler")
+				return nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"order-global", "order-service", "order-method", "handler"}, calls)
+	})
+}
+
+func TestServer_InterceptorNotConfigured(t *testing.T) {
+	s := newInterceptorServer()
+	s.registerServiceInfo(&ServiceDesc{
+		ServiceName: "test.PlainService",
+		Methods:     []MethodDesc{{MethodName: "Unary"}},
+		Streams:     []stream.StreamDesc{{StreamName: "Stream"}},
+	}, nil)
+	info := s.services["test.PlainService"]
+	// The methods without the service and method interceptors use the chain of
+	// the server.
+	assert.Empty(t, info.unaryInts)
+	assert.Empty(t, info.streamInts)
+}
+
+func TestServer_InterceptorCached(t *testing.T) {
+	var calls []string
+	registerRecordInts(&calls, "cached-service")
+	const serviceName = "test.CachedService"
+	setTestConfig(t, fmt.Sprintf(config.KeyServerServiceUnaryInt, serviceName), "cached-service")
+
+	s := newInterceptorServer()
+	ints := s.serviceInterceptors(serviceName)
+	assert.Same(t, ints, s.serviceInterceptors(serviceName))
+	require.NotNil(t, ints.unaryInt("Unary"))
+	assert.Contains(t, ints.methodUnary, "Unary")
+	assert.Nil(t, ints.streamInt("Stream"))
+	assert.Contains(t, ints.methodStream, "Stream")
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 The imkuqin-zw Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/interceptor"
+	"github.com/imkuqin-zw/yggdrasil/pkg/metadata"
+	"github.com/imkuqin-zw/yggdrasil/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRestServer records the routes registered by the server.
+type fakeRestServer struct {
+	rest.Server
+	handlers map[string]rest.HandlerFunc
+	streams  []string
+}
+
+func (f *fakeRestServer) RpcHandle(method, path string, h rest.HandlerFunc) {
+	f.handlers[method+" "+path] = h
+}
+
+func (f *fakeRestServer) RpcStreamHandle(method, path, fullMethod string, _ rest.StreamDecoder) {
+	f.streams = append(f.streams, fullMethod)
+}
+
+func (f *fakeRestServer) RpcWebSocketHandle(path, fullMethod string) {
+	f.streams = append(f.streams, fullMethod)
+}
+
+type fakeGateway struct{}
+
+func (fakeGateway) Invoke(context.Context, string, interface{}, interface{}) error {
+	return nil
+}
+
+func newRestServer() (*server, *fakeRestServer) {
+	s := newInterceptorServer()
+	restSvr := &fakeRestServer{handlers: map[string]rest.HandlerFunc{}}
+	s.restEnable = true
+	s.restSvr = restSvr
+	return s, restSvr
+}
+
+// newGatewayDesc returns the service of the unary method, the handlers record
+// the handle of the service and the gateway they are called with.
+func newGatewayDesc(served *interface{}) *RestServiceDesc {
+	return &RestServiceDesc{
+		ServiceName: "test.GatewayService",
+		Methods: []RestMethodDesc{
+			{
+				MethodName: "Get",
+				Method:     http.MethodGet,
+				Path:       "/v1/get",
+				Handler: func(w http.ResponseWriter, r *http.Request, srv interface{}, _ interceptor.UnaryServerInterceptor) (interface{}, error) {
+					*served = srv
+					return "local", nil
+				},
+				GatewayHandler: func(w http.ResponseWriter, r *http.Request, gw RestGateway, _ interceptor.UnaryServerInterceptor) (interface{}, error) {
+					*served = gw
+					md, _ := metadata.FromOutContext(r.Context())
+					return md.Get("x-test"), nil
+				},
+			},
+			{MethodName: "List", Method: http.MethodGet, Path: "/v1/list",
+				StreamDecoder: func(r *http.Request, req interface{}) error { return nil }},
+			{MethodName: "Chat", Path: "/v1/chat", WebSocket: true},
+		},
+	}
+}
+
+func TestServer_RegisterRestGateway(t *testing.T) {
+	var served interface{}
+	s, restSvr := newRestServer()
+	gw := &fakeGateway{}
+	s.RegisterRestGateway(newGatewayDesc(&served), gw, "api")
+
+	// The stream routes are not forwarded.
+	assert.Empty(t, restSvr.streams)
+	require.Len(t, restSvr.handlers, 1)
+	h := restSvr.handlers[http.MethodGet+" /api/v1/get"]
+	require.NotNil(t, h)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/get", nil)
+	r = r.WithContext(metadata.WithInContext(r.Context(), metadata.Pairs("x-test", "v")))
+	reply, err := h(httptest.NewRecorder(), r)
+	require.NoError(t, err)
+	// The incoming metadata is forwarded to the remote service.
+	assert.Equal(t, []string{"v"}, reply)
+	assert.Same(t, gw, served)
+}
+
+func TestServer_RegisterRestService(t *testing.T) {
+	var served interface{}
+	s, restSvr := newRestServer()
+	ss := &struct{}{}
+	s.registerRest(newGatewayDesc(&served), ss, nil)
+
+	assert.Equal(t, []string{"/test.GatewayService/List", "/test.GatewayService/Chat"}, restSvr.streams)
+	h := restSvr.handlers[http.MethodGet+" /v1/get"]
+	require.NotNil(t, h)
+	reply, err := h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/get", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "local", reply)
+	assert.Same(t, ss, served)
+}
+
+func TestCheckRestGateway(t *testing.T) {
+	var served interface{}
+	sd := newGatewayDesc(&served)
+	assert.NoError(t, checkRestGateway(sd))
+
+	// The handlers generated before the rest gateway have no gateway handler.
+	sd.Methods[0].GatewayHandler = nil
+	assert.Error(t, checkRestGateway(sd))
+
+	sd.Methods = sd.Methods[1:]
+	assert.NoError(t, checkRestGateway(sd))
+}
@@ -15,13 +15,16 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/imkuqin-zw/yggdrasil/pkg"
 	"github.com/imkuqin-zw/yggdrasil/pkg/config"
@@ -43,6 +46,13 @@ const (
 	serverStateInit = iota
 	serverStateRunning
 	serverStateClosing
+	serverStateStopped
+)
+
+const (
+	stopStateDraining = "draining"
+	stopStateStopped  = "stopped"
+	stopStateFailed   = "failed"
 )
 
 var (
@@ -73,32 +83,52 @@ func (si *serverInfo) Scheme() string {
 	return si.scheme
 }
 
+// listenerConfig is the config of the named listener, the listener has its own
+// protocol server and endpoint. The interceptors of the listener are chained
+// after the global interceptors.
+type listenerConfig struct {
+	Protocol     string `yaml:"protocol"`
+	UnaryServer  string `yaml:"unaryServer"`
+	StreamServer string `yaml:"streamServer"`
+}
+
 type server struct {
-	mu                sync.RWMutex
-	services          map[string]*ServiceInfo // service name -> service serverInfo
-	servicesDesc      map[string][]methodInfo
-	restRouterDesc    []restRouterInfo
-	unaryInterceptor  interceptor.UnaryServerInterceptor
-	streamInterceptor interceptor.StreamServerInterceptor
-	servers           []remote.Server
-	state             int
-	serverWG          sync.WaitGroup
-	stats             stats.Handler
+	mu             sync.RWMutex
+	services       map[string]*ServiceInfo // service name -> service serverInfo
+	servicesDesc   map[string][]methodInfo
+	restRouterDesc []restRouterInfo
+	// intChains is the interceptor chains of the protocol servers, the first
+	// one is the global chain.
+	intChains []intChain
+	// svcInts is the interceptors configured for the services.
+	svcInts  map[string]*serviceInterceptors
+	servers  []remote.Server
+	state    int
+	serverWG sync.WaitGroup
+	stats    stats.Handler
 
 	restSvr    rest.Server
 	restEnable bool
+
+	// activeRPC is the number of the rpc being handled.
+	activeRPC int64
+	// stopStates records the stop progress of each protocol server.
+	stopStates map[string]string
 }
 
 func NewServer() Server {
 	svr = &server{
 		services:       map[string]*ServiceInfo{},
 		servicesDesc:   map[string][]methodInfo{},
+		svcInts:        map[string]*serviceInterceptors{},
 		restRouterDesc: []restRouterInfo{},
 		stats:          stats.GetServerHandler(),
+		stopStates:     map[string]string{},
 	}
 	if config.GetBool(config.KeyRestEnable, false) {
 		svr.restEnable = true
 		svr.restSvr = rest.NewServer()
+		svr.restSvr.StreamHandle(svr.handleStream)
 	}
 	svr.initInterceptor()
 	svr.initRemoteServer()
@@ -126,6 +156,14 @@ func NewServer() Server {
 		}
 		_ = encoder.Encode(result)
 	})
+	governor.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(svr.stateInfo())
+	})
 
 	return svr
 }
@@ -158,7 +196,35 @@ func (s *server) RegisterRestService(sd *RestServiceDesc, ss interface{}, prefix
 	if !st.Implements(ht) {
 		logger.Fatalf("Server.RegisterService found the handler of type %v that does not satisfy %v", st, ht)
 	}
-	s.registerRest(sd, ss, prefix...)
+	s.registerRest(sd, ss, nil, prefix...)
+}
+
+func (s *server) RegisterRestGateway(sd *RestServiceDesc, gw RestGateway, prefix ...string) {
+	if !s.restEnable {
+		return
+	}
+	if gw == nil {
+		logger.Fatalf("Server.RegisterRestGateway gateway is nil")
+	}
+	if err := checkRestGateway(sd); err != nil {
+		logger.Fatalf("Server.RegisterRestGateway %v", err)
+	}
+	s.registerRest(sd, gw, gw, prefix...)
+}
+
+// checkRestGateway checks the unary methods of the service can be forwarded, the
+// handlers generated before the rest gateway have no GatewayHandler.
+func checkRestGateway(sd *RestServiceDesc) error {
+	for _, item := range sd.Methods {
+		if item.StreamDecoder != nil || item.WebSocket {
+			continue
+		}
+		if item.GatewayHandler == nil {
+			return fmt.Errorf("the method %s of the service %s has no gateway handler, regenerate the rest code",
+				item.MethodName, sd.ServiceName)
+		}
+	}
+	return nil
 }
 
 func (s *server) RegisterRestRawHandlers(sd ...*RestRawHandlerDesc) {
@@ -176,36 +242,99 @@ func (s *server) RegisterRestRawHandlers(sd ...*RestRawHandlerDesc) {
 	}
 }
 
-func (s *server) Stop() error {
+// Stop stops the protocol servers in two phases. All the servers stop accepting
+// new connections and rpc at first, then wait for the active rpc to finish until
+// ctx is done, and the remaining rpc are force closed.
+func (s *server) Stop(ctx context.Context) error {
 	s.mu.Lock()
 	if s.state == serverStateInit {
 		s.state = serverStateClosing
 		s.mu.Unlock()
 		return nil
 	}
-	if s.state == serverStateClosing {
+	if s.state >= serverStateClosing {
 		s.mu.Unlock()
 		return nil
 	}
 	s.state = serverStateClosing
 	s.mu.Unlock()
-	errs := make([]error, 0)
-	for _, item := range s.servers {
-		if err := item.Stop(); err != nil {
+	logger.InfoField("server stopping", logger.Int64("activeRPC", atomic.LoadInt64(&s.activeRPC)))
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make([]error, 0)
+	)
+	stop := func(key string, fn func(context.Context) error) {
+		defer wg.Done()
+		s.setStopState(key, stopStateDraining)
+		if err := fn(ctx); err != nil {
+			s.setStopState(key, stopStateFailed)
+			logger.ErrorField("fault to stop server", logger.String("server", key), logger.Err(err))
+			mu.Lock()
 			errs = append(errs, err)
-			logger.ErrorField("fault to stop server",
-				logger.String("protocol", item.Info().Protocol), logger.Err(err))
+			mu.Unlock()
+			return
 		}
+		s.setStopState(key, stopStateStopped)
+		logger.InfoField("server stopped", logger.String("server", key),
+			logger.Int64("activeRPC", atomic.LoadInt64(&s.activeRPC)))
+	}
+	for _, item := range s.servers {
+		wg.Add(1)
+		go stop(serverKey(item.Info()), item.Stop)
 	}
 	if s.restEnable {
-		if err := s.restSvr.Stop(); err != nil {
-			errs = append(errs, err)
-			logger.ErrorField("fault to stop rest server", logger.Err(err))
-		}
+		wg.Add(1)
+		go stop("rest", s.restSvr.Stop)
 	}
+	wg.Wait()
+	s.mu.Lock()
+	s.state = serverStateStopped
+	s.mu.Unlock()
 	return multierr.Combine(errs...)
 }
 
+func (s *server) setStopState(key, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopStates[key] = state
+}
+
+// serverKey returns the protocol of the default server, and protocol/name of the
+// named listener.
+func serverKey(info remote.ServerInfo) string {
+	if info.Name == "" {
+		return info.Protocol
+	}
+	return info.Protocol + "/" + info.Name
+}
+
+func (s *server) stateInfo() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var state string
+	switch s.state {
+	case serverStateInit:
+		state = "init"
+	case serverStateRunning:
+		state = "running"
+	case serverStateClosing:
+		state = stopStateDraining
+	default:
+		state = stopStateStopped
+	}
+	servers := make(map[string]string, len(s.stopStates))
+	for k, v := range s.stopStates {
+		servers[k] = v
+	}
+	return map[string]interface{}{
+		"appName":   pkg.Name(),
+		"state":     state,
+		"activeRPC": atomic.LoadInt64(&s.activeRPC),
+		"servers":   servers,
+	}
+}
+
 func (s *server) Serve(startFlag chan<- struct{}) error {
 	s.mu.Lock()
 	if s.state == serverStateClosing {
@@ -246,7 +375,7 @@ func (s *server) Endpoints() []Endpoint {
 	}
 	if s.restEnable {
 		endpoints = append(endpoints, &serverInfo{
-			scheme:   "http",
+			scheme:   s.restSvr.Info().GetScheme(),
 			address:  s.restSvr.Info().GetAddress(),
 			metadata: s.restSvr.Info().GetAttributes(),
 			svrKind:  pkg.ServerKindRest,
@@ -256,35 +385,79 @@ func (s *server) Endpoints() []Endpoint {
 }
 
 func (s *server) initInterceptor() {
-	if val := config.Get(config.KeyIntUnaryServe).String(); val != "" {
-		unaryIntNames := xarray.RemoveReplaceStrings(strings.Split(val, ","))
-		s.unaryInterceptor = interceptor.ChainUnaryServerInterceptors(unaryIntNames)
+	var chain intChain
+	if names := splitIntNames(config.Get(config.KeyIntUnaryServe).String()); len(names) > 0 {
+		chain.unary = interceptor.ChainUnaryServerInterceptors(names)
 	}
-	if val := config.Get(config.KeyIntStreamServer).String(); val != "" {
-		streamIntNames := xarray.RemoveReplaceStrings(strings.Split(val, ","))
-		s.streamInterceptor = interceptor.ChainStreamServerInterceptors(streamIntNames)
+	chain.stream = interceptor.ChainStreamServerInterceptors(splitIntNames(config.Get(config.KeyIntStreamServer).String()))
+	s.intChains = []intChain{chain}
+}
+
+func splitIntNames(val string) []string {
+	if val == "" {
+		return nil
 	}
+	return xarray.RemoveReplaceStrings(strings.Split(val, ","))
 }
 
 func (s *server) initRemoteServer() {
 	protocols := config.Get(config.KeyServerProtocol).StringSlice()
-	if len(protocols) == 0 {
-		return
-	}
 	for _, protocol := range protocols {
-		builder := remote.GetServerBuilder(protocol)
-		if builder == nil {
-			logger.FatalField("not found server builder",
-				logger.String("protocol", protocol))
+		s.newRemoteServer(protocol, "", s.handleStream)
+	}
+	s.initListeners()
+}
+
+// initListeners builds a protocol server for each named listener, the listeners
+// are built in the order of the name.
+func (s *server) initListeners() {
+	listeners := map[string]listenerConfig{}
+	if err := config.Get(config.KeyServerListeners).Scan(&listeners); err != nil {
+		logger.FatalField("fault to load server listeners config", logger.Err(err))
+	}
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cfg := listeners[name]
+		if cfg.Protocol == "" {
+			logger.FatalField("not found listener protocol", logger.String("listener", name))
+		}
+		// The address of the protocol is not inherited by the listeners.
+		if config.Get(config.Join(fmt.Sprintf(config.KeyServerListenerProtoCfg, name), "address")).String() == "" {
+			logger.FatalField("not found listener address", logger.String("listener", name))
+		}
+		chain := s.intChains[0]
+		if names := splitIntNames(cfg.UnaryServer); len(names) > 0 {
+			chain.unary = interceptor.ChainUnaryServer(chain.unary, interceptor.ChainUnaryServerInterceptors(names))
 		}
-		svr, err := builder(s.handleStream)
-		if err != nil {
-			logger.FatalField("fault to new remote server",
-				logger.String("protocol", protocol),
-				logger.Err(err))
+		if names := splitIntNames(cfg.StreamServer); len(names) > 0 {
+			chain.stream = interceptor.ChainStreamServer(chain.stream, interceptor.ChainStreamServerInterceptors(names))
 		}
-		s.servers = append(s.servers, svr)
+		s.intChains = append(s.intChains, chain)
+		idx := len(s.intChains) - 1
+		s.newRemoteServer(cfg.Protocol, name, func(ss remote.ServerStream) {
+			s.dispatch(ss, idx)
+		})
+	}
+}
+
+func (s *server) newRemoteServer(protocol, name string, handle remote.MethodHandle) {
+	builder := remote.GetServerBuilder(protocol)
+	if builder == nil {
+		logger.FatalField("not found server builder",
+			logger.String("protocol", protocol), logger.String("listener", name))
+	}
+	svr, err := builder(name, handle)
+	if err != nil {
+		logger.FatalField("fault to new remote server",
+			logger.String("protocol", protocol),
+			logger.String("listener", name),
+			logger.Err(err))
 	}
+	s.servers = append(s.servers, svr)
 }
 
 func (s *server) register(sd *ServiceDesc, ss interface{}) {
@@ -303,14 +476,31 @@ func (s *server) registerServiceInfo(sd *ServiceDesc, ss interface{}) {
 		Methods:     make(map[string]*MethodDesc),
 		Streams:     make(map[string]*stream.StreamDesc),
 		Metadata:    sd.Metadata,
+		unaryInts:   make(map[string][]interceptor.UnaryServerInterceptor),
+		streamInts:  make(map[string][]interceptor.StreamServerInterceptor),
 	}
+	ints := s.serviceInterceptors(sd.ServiceName)
 	for i := range sd.Methods {
 		d := &sd.Methods[i]
 		info.Methods[d.MethodName] = d
+		if extra := ints.unaryInt(d.MethodName); extra != nil {
+			chains := make([]interceptor.UnaryServerInterceptor, len(s.intChains))
+			for j, item := range s.intChains {
+				chains[j] = interceptor.ChainUnaryServer(item.unary, extra)
+			}
+			info.unaryInts[d.MethodName] = chains
+		}
 	}
 	for i := range sd.Streams {
 		d := &sd.Streams[i]
 		info.Streams[d.StreamName] = d
+		if extra := ints.streamInt(d.StreamName); extra != nil {
+			chains := make([]interceptor.StreamServerInterceptor, len(s.intChains))
+			for j, item := range s.intChains {
+				chains[j] = interceptor.ChainStreamServer(item.stream, extra)
+			}
+			info.streamInts[d.StreamName] = chains
+		}
 	}
 	s.services[sd.ServiceName] = info
 }
@@ -332,7 +522,9 @@ func (s *server) registerServiceDesc(desc *ServiceDesc) {
 	s.servicesDesc[desc.ServiceName] = methods
 }
 
-func (s *server) registerRest(sd *RestServiceDesc, ss interface{}, prefix ...string) {
+// registerRest registers the rest routes of the service, the unary methods are
+// forwarded by gw if it is not nil.
+func (s *server) registerRest(sd *RestServiceDesc, ss interface{}, gw RestGateway, prefix ...string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	var pathPrefix string
@@ -340,16 +532,59 @@ func (s *server) registerRest(sd *RestServiceDesc, ss interface{}, prefix ...str
 		pathPrefix = "/" + strings.TrimPrefix(prefix[0], "/")
 	}
 
+	var ints *serviceInterceptors
+	if sd.ServiceName != "" {
+		ints = s.serviceInterceptors(sd.ServiceName)
+	}
 	for _, item := range sd.Methods {
 		method := item.Method
 		path := pathPrefix + item.Path
+		if gw != nil && (item.StreamDecoder != nil || item.WebSocket) {
+			logger.WarnField("the stream rpc is not forwarded by the rest gateway",
+				logger.String("service", sd.ServiceName), logger.String("method", item.MethodName))
+			continue
+		}
+		if item.StreamDecoder != nil || item.WebSocket {
+			// The stream is dispatched as the rpc, it is served by the service
+			// registered by RegisterService with the interceptors of the rpc.
+			fullMethod := "/" + sd.ServiceName + "/" + item.MethodName
+			if item.WebSocket {
+				// The websocket is always upgraded from GET.
+				method = http.MethodGet
+				s.restSvr.RpcWebSocketHandle(path, fullMethod)
+			} else {
+				s.restSvr.RpcStreamHandle(method, path, fullMethod, rest.StreamDecoder(item.StreamDecoder))
+			}
+			s.restRouterDesc = append(s.restRouterDesc, restRouterInfo{
+				Method: method,
+				Path:   path,
+			})
+			continue
+		}
 		handler := item.Handler
+		unaryInt := s.intChains[0].unary
+		if ints != nil && item.MethodName != "" {
+			if extra := ints.unaryInt(item.MethodName); extra != nil {
+				unaryInt = interceptor.ChainUnaryServer(unaryInt, extra)
+			}
+		}
 		s.restRouterDesc = append(s.restRouterDesc, restRouterInfo{
 			Method: method,
 			Path:   path,
 		})
+		if gw != nil {
+			gwHandler := item.GatewayHandler
+			// The incoming metadata accepted from the request headers is forwarded
+			// to the remote service.
+			s.restSvr.RpcHandle(method, path, func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+				md, _ := metadata.FromInContext(r.Context())
+				r = r.WithContext(metadata.WithOutContext(r.Context(), md))
+				return gwHandler(w, r, gw, unaryInt)
+			})
+			continue
+		}
 		s.restSvr.RpcHandle(method, path, func(w http.ResponseWriter, r *http.Request) (interface{}, error) {
-			return handler(w, r, ss, s.unaryInterceptor)
+			return handler(w, r, ss, unaryInt)
 		})
 	}
 }
@@ -358,16 +593,16 @@ func (s *server) serve(svr remote.Server) error {
 	err := svr.Start()
 	if err != nil {
 		logger.ErrorField("the server was ended forcefully",
-			logger.String("protocol", svr.Info().Protocol), logger.Err(err))
+			logger.String("server", serverKey(svr.Info())), logger.Err(err))
 		return err
 	}
-	logger.InfoField("server started", logger.String("protocol", svr.Info().Protocol), logger.String("endpoint", svr.Info().Address))
+	logger.InfoField("server started", logger.String("server", serverKey(svr.Info())), logger.String("endpoint", svr.Info().Address))
 	s.serverWG.Add(1)
 	go func() {
 		defer s.serverWG.Done()
 		if err = svr.Handle(); err != nil {
 			logger.ErrorField("fault to handle channel",
-				logger.String("protocol", svr.Info().Protocol), logger.Err(err))
+				logger.String("server", serverKey(svr.Info())), logger.Err(err))
 		}
 	}()
 	return nil
@@ -394,6 +629,15 @@ func (s *server) restServe() error {
 }
 
 func (s *server) handleStream(ss remote.ServerStream) {
+	s.dispatch(ss, 0)
+}
+
+// dispatch handles the stream with the interceptor chain of the server it comes
+// from, the chain is replaced by the one with the service and method interceptors
+// if they are configured.
+func (s *server) dispatch(ss remote.ServerStream, chain int) {
+	atomic.AddInt64(&s.activeRPC, 1)
+	defer atomic.AddInt64(&s.activeRPC, -1)
 	sm := ss.Method()
 	if sm != "" && sm[0] == '/' {
 		sm = sm[1:]
@@ -409,11 +653,19 @@ func (s *server) handleStream(ss remote.ServerStream) {
 	srv, knownService := s.services[service]
 	if knownService {
 		if md, ok := srv.Methods[method]; ok {
-			s.processUnaryRPC(md, srv, ss)
+			unaryInt := s.intChains[chain].unary
+			if ints, ok := srv.unaryInts[method]; ok {
+				unaryInt = ints[chain]
+			}
+			s.processUnaryRPC(md, srv, ss, unaryInt)
 			return
 		}
 		if sd, ok := srv.Streams[method]; ok {
-			s.processStreamRpc(sd, srv, ss)
+			streamInt := s.intChains[chain].stream
+			if ints, ok := srv.streamInts[method]; ok {
+				streamInt = ints[chain]
+			}
+			s.processStreamRpc(sd, srv, ss, streamInt)
 			return
 		}
 	}
@@ -427,7 +679,8 @@ func (s *server) handleStream(ss remote.ServerStream) {
 	return
 }
 
-func (s *server) processUnaryRPC(desc *MethodDesc, srv *ServiceInfo, ss remote.ServerStream) {
+func (s *server) processUnaryRPC(desc *MethodDesc, srv *ServiceInfo, ss remote.ServerStream,
+	unaryInt interceptor.UnaryServerInterceptor) {
 	var (
 		reply any
 		err   error
@@ -440,7 +693,7 @@ func (s *server) processUnaryRPC(desc *MethodDesc, srv *ServiceInfo, ss remote.S
 	}
 
 	ctx := metadata.WithStreamContext(ss.Context())
-	reply, err = desc.Handler(srv.ServiceImpl, ctx, ss.RecvMsg, s.unaryInterceptor)
+	reply, err = desc.Handler(srv.ServiceImpl, ctx, ss.RecvMsg, unaryInt)
 	if header, ok := metadata.FromHeaderCtx(ctx); ok {
 		_ = ss.SetHeader(header)
 	}
@@ -450,7 +703,8 @@ func (s *server) processUnaryRPC(desc *MethodDesc, srv *ServiceInfo, ss remote.S
 	return
 }
 
-func (s *server) processStreamRpc(desc *stream.StreamDesc, srv *ServiceInfo, ss remote.ServerStream) {
+func (s *server) processStreamRpc(desc *stream.StreamDesc, srv *ServiceInfo, ss remote.ServerStream,
+	streamInt interceptor.StreamServerInterceptor) {
 	var err error
 	defer func() {
 		ss.Finish(nil, err)
@@ -463,6 +717,6 @@ func (s *server) processStreamRpc(desc *stream.StreamDesc, srv *ServiceInfo, ss
 		IsClientStream: desc.ClientStreams,
 		IsServerStream: desc.ServerStreams,
 	}
-	err = s.streamInterceptor(srv.ServiceImpl, ss, si, desc.Handler)
+	err = streamInt(srv.ServiceImpl, ss, si, desc.Handler)
 	return
 }
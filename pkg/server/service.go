@@ -47,6 +47,10 @@ type ServiceInfo struct {
 	Methods     map[string]*MethodDesc
 	Streams     map[string]*stream.StreamDesc
 	Metadata    interface{}
+	// unaryInts and streamInts are the interceptor chains of the methods with the
+	// service or method interceptors, indexed like the chains of the server.
+	unaryInts  map[string][]interceptor.UnaryServerInterceptor
+	streamInts map[string][]interceptor.StreamServerInterceptor
 }
 
 type methodInfo struct {
@@ -57,15 +61,44 @@ type methodInfo struct {
 
 type RestMethodHandler func(w http.ResponseWriter, r *http.Request, srv interface{}, interceptor interceptor.UnaryServerInterceptor) (interface{}, error)
 
+// RestStreamDecoder decodes the request of the server streaming method from the
+// http request.
+type RestStreamDecoder func(r *http.Request, req interface{}) error
+
+// RestGateway invokes the rpc of the remote service, the client.Client is the
+// gateway discovering the service by the resolvers.
+type RestGateway interface {
+	Invoke(ctx context.Context, method string, args, reply interface{}) error
+}
+
+// RestGatewayHandler decodes the request of the unary method and forwards it to
+// the remote service by gw.
+type RestGatewayHandler func(w http.ResponseWriter, r *http.Request, gw RestGateway, interceptor interceptor.UnaryServerInterceptor) (interface{}, error)
+
 type RestServiceDesc struct {
+	// ServiceName is the full name of the rpc service, it selects the interceptors
+	// configured for the service.
+	ServiceName string
 	HandlerType interface{}
 	Methods     []RestMethodDesc
 }
 
 type RestMethodDesc struct {
-	Method  string
-	Path    string
-	Handler RestMethodHandler
+	// MethodName is the name of the rpc method, it selects the interceptors
+	// configured for the method.
+	MethodName string
+	Method     string
+	Path       string
+	Handler    RestMethodHandler
+	// GatewayHandler is set with Handler by the generator supporting the rest
+	// gateway, the method is forwarded by it if registered by RegisterRestGateway.
+	GatewayHandler RestGatewayHandler
+	// StreamDecoder is set instead of Handler for the server streaming method,
+	// the method is dispatched to the registered service.
+	StreamDecoder RestStreamDecoder
+	// WebSocket upgrades the client streaming or bidi streaming method to
+	// websocket, the method is dispatched to the registered service.
+	WebSocket bool
 }
 
 type restRouterInfo struct {
@@ -15,6 +15,8 @@
 package server
 
 import (
+	"context"
+
 	"github.com/imkuqin-zw/yggdrasil/pkg"
 )
 
@@ -28,8 +30,12 @@ type Endpoint interface {
 type Server interface {
 	RegisterService(sd *ServiceDesc, ss interface{})
 	RegisterRestService(sd *RestServiceDesc, ss interface{}, prefix ...string)
+	// RegisterRestGateway registers the rest routes of the service forwarded to
+	// the remote service by gw, the stream routes are not forwarded. The rest code
+	// of the service must be generated with the gateway handlers.
+	RegisterRestGateway(sd *RestServiceDesc, gw RestGateway, prefix ...string)
 	RegisterRestRawHandlers(sd ...*RestRawHandlerDesc)
 	Serve(startFlag chan<- struct{}) error
-	Stop() error
+	Stop(ctx context.Context) error
 	Endpoints() []Endpoint
 }
@@ -63,3 +63,26 @@ type ChanEndBase struct {
 func (s *ChanEndBase) IsClient() bool { return s.Client }
 func (s *ChanEndBase) isChanStats()   {}
 func (s *ChanEndBase) isEnd()         {}
+
+type ChanReject interface {
+	ChanStats
+	GetReason() string
+	isReject()
+}
+
+// ChanRejectBase contains the stats of a connection rejected before it is served,
+// e.g. rejected by the connection limits.
+type ChanRejectBase struct {
+	// Client is true if this ChanReject is from client side.
+	Client bool
+	// Reason is why the connection is rejected.
+	Reason string
+}
+
+// IsClient indicates if this is from client side.
+func (s *ChanRejectBase) IsClient() bool { return s.Client }
+
+// GetReason returns why the connection is rejected.
+func (s *ChanRejectBase) GetReason() string { return s.Reason }
+func (s *ChanRejectBase) isChanStats()      {}
+func (s *ChanRejectBase) isReject()         {}
@@ -32,6 +32,7 @@ var (
 	peerEndpointKey         = attribute.Key("peer_endpoint")
 	protocolKey             = attribute.Key("protocol")
 	codeKey                 = attribute.Key("rpc.status_code")
+	rejectReasonKey         = attribute.Key("reject_reason")
 )
 
 type handler struct {
@@ -42,6 +43,8 @@ type handler struct {
 	rpcResponseSize    metric.Int64Histogram
 	rpcRequestsPerRPC  metric.Int64Histogram
 	rpcResponsesPerRPC metric.Int64Histogram
+	connRejected       metric.Int64Counter
+	rpcQueueDuration   metric.Float64Histogram
 
 	handleRPC func(context.Context, stats.RPCStats, bool)
 }
@@ -115,6 +118,26 @@ func newHandler(isSvr bool) handler {
 				h.rpcResponsesPerRPC = noop.Int64Histogram{}
 			}
 		}
+		if isSvr {
+			h.connRejected, err = meter.Int64Counter("rpc.server.rejected_connections",
+				metric.WithDescription("Measures the number of connections rejected by the connection limits."),
+				metric.WithUnit("{count}"))
+			if err != nil {
+				otel.Handle(err)
+				if h.connRejected == nil {
+					h.connRejected = noop.Int64Counter{}
+				}
+			}
+			h.rpcQueueDuration, err = meter.Float64Histogram("rpc.server.queue_duration",
+				metric.WithDescription("Measures the duration of inbound RPC waiting in the queue of the stream workers."),
+				metric.WithUnit("ms"))
+			if err != nil {
+				otel.Handle(err)
+				if h.rpcQueueDuration == nil {
+					h.rpcQueueDuration = noop.Float64Histogram{}
+				}
+			}
+		}
 		h.handleRPC = h.handleWithMetrics
 	} else {
 		h.handleRPC = h.handleWithOutMetrics
@@ -171,6 +194,12 @@ func (h *handler) handleWithMetrics(ctx context.Context, rs stats.RPCStats, isSe
 	case stats.RPCOutHeader:
 		span.SetAttributes(protocolKey.String(rs.GetProtocol()))
 		span.SetAttributes(peerEndpointKey.String(rs.GetRemoteEndpoint()))
+	case stats.RPCQueue:
+		if h.rpcQueueDuration != nil {
+			metricAttrs = append(metricAttrs, protocolKey.String(rs.GetProtocol()))
+			waitTime := float64(rs.GetWaitTime()) / float64(time.Millisecond)
+			h.rpcQueueDuration.Record(ctx, waitTime, metric.WithAttributes(metricAttrs...))
+		}
 	case stats.RPCEnd:
 		var rpcStatusAttr attribute.KeyValue
 
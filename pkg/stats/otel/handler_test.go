@@ -0,0 +1,71 @@
+package otel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+type recordHistogram struct {
+	noop.Float64Histogram
+	mu     sync.Mutex
+	values []float64
+	attrs  []attribute.Set
+}
+
+func (h *recordHistogram) Record(_ context.Context, v float64, opts ...metric.RecordOption) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.values = append(h.values, v)
+	h.attrs = append(h.attrs, metric.NewRecordConfig(opts).Attributes())
+}
+
+// recordMeterProvider records the values of the float64 histograms by name.
+type recordMeterProvider struct {
+	noop.MeterProvider
+	hists map[string]*recordHistogram
+}
+
+func (p *recordMeterProvider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return &recordMeter{hists: p.hists}
+}
+
+type recordMeter struct {
+	noop.Meter
+	hists map[string]*recordHistogram
+}
+
+func (m *recordMeter) Float64Histogram(name string, _ ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	h := &recordHistogram{}
+	m.hists[name] = h
+	return h, nil
+}
+
+func TestServerHandler_QueueDuration(t *testing.T) {
+	mp := &recordMeterProvider{hists: map[string]*recordHistogram{}}
+	prev := otel.GetMeterProvider()
+	otel.SetMeterProvider(mp)
+	defer otel.SetMeterProvider(prev)
+
+	h := newSvrHandler()
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfoBase{FullMethod: "/pkg.Service/Method"})
+	h.HandleRPC(ctx, &stats.RPCQueueBase{WaitTime: 1500 * time.Microsecond, Protocol: "grpc"})
+
+	hist := mp.hists["rpc.server.queue_duration"]
+	require.NotNil(t, hist)
+	require.Equal(t, []float64{1.5}, hist.values)
+	protocol, ok := hist.attrs[0].Value(protocolKey)
+	assert.True(t, ok)
+	assert.Equal(t, "grpc", protocol.AsString())
+	_, ok = mp.hists["rpc.client.queue_duration"]
+	assert.False(t, ok)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/imkuqin-zw/yggdrasil/pkg/stats"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -19,11 +20,23 @@ func newSvrHandler() *serverHandler {
 	}
 }
 
+type chanContextKey struct{}
+
 func (h *serverHandler) TagChannel(ctx context.Context, info stats.ChanTagInfo) context.Context {
-	return ctx
+	if h.connRejected == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, chanContextKey{}, info.GetProtocol())
 }
 
 func (h *serverHandler) HandleChannel(ctx context.Context, info stats.ChanStats) {
+	if rs, ok := info.(stats.ChanReject); ok && h.connRejected != nil {
+		protocol, _ := ctx.Value(chanContextKey{}).(string)
+		h.connRejected.Add(ctx, 1, metric.WithAttributes(
+			protocolKey.String(protocol),
+			rejectReasonKey.String(rs.GetReason()),
+		))
+	}
 }
 
 // TagRPC can attach some information to the given context.
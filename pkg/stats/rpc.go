@@ -479,3 +479,30 @@ func (s *RPCEndBase) Error() error {
 func (s *RPCEndBase) GetProtocol() string {
 	return s.Protocol
 }
+
+// RPCQueue contains the stats of an RPC waiting in the queue of the server
+// workers before it is handled.
+type RPCQueue interface {
+	RPCStats
+	// GetWaitTime returns how long the RPC waits in the queue.
+	GetWaitTime() time.Duration
+	// GetProtocol returns the protocol used for the RPC.
+	GetProtocol() string
+}
+
+// RPCQueueBase contains the stats of an RPC waiting in the queue.
+type RPCQueueBase struct {
+	// WaitTime is how long the RPC waits in the queue.
+	WaitTime time.Duration
+	Protocol string
+}
+
+func (s *RPCQueueBase) isRPCStats() {}
+
+func (s *RPCQueueBase) GetWaitTime() time.Duration {
+	return s.WaitTime
+}
+
+func (s *RPCQueueBase) GetProtocol() string {
+	return s.Protocol
+}
@@ -40,6 +40,7 @@ var (
 	opts        = &options{
 		serviceDesc:     map[*server.ServiceDesc]interface{}{},
 		restServiceDesc: map[*server.RestServiceDesc]restServiceDesc{},
+		restGatewayDesc: map[*server.RestServiceDesc]restGatewayDesc{},
 	}
 )
 
@@ -199,7 +200,8 @@ func initGovernor(opts *options) {
 }
 
 func initServer(opts *options) {
-	if len(opts.serviceDesc) == 0 && len(opts.restServiceDesc) == 0 && len(opts.restRawHandleDesc) == 0 {
+	if len(opts.serviceDesc) == 0 && len(opts.restServiceDesc) == 0 &&
+		len(opts.restGatewayDesc) == 0 && len(opts.restRawHandleDesc) == 0 {
 		return
 	}
 	svr := server.NewServer()
@@ -209,6 +211,9 @@ func initServer(opts *options) {
 	for k, v := range opts.restServiceDesc {
 		svr.RegisterRestService(k, v.ss, v.Prefix...)
 	}
+	for k, v := range opts.restGatewayDesc {
+		svr.RegisterRestGateway(k, NewClient(v.serviceName), v.Prefix...)
+	}
 
 	if len(opts.restRawHandleDesc) > 0 {
 		svr.RegisterRestRawHandlers(opts.restRawHandleDesc...)